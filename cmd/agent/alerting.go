@@ -19,6 +19,7 @@ package main
 
 import (
 	"context"
+	"net/http"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
@@ -26,6 +27,7 @@ import (
 	"github.com/traefik/hub-agent-traefik/pkg/alerting"
 	"github.com/traefik/hub-agent-traefik/pkg/logger"
 	"github.com/traefik/hub-agent-traefik/pkg/metrics"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
 const (
@@ -38,11 +40,12 @@ const (
 	alertSchedulerInterval = time.Minute
 )
 
-func runAlerting(ctx context.Context, token, platformURL string, store *metrics.Store) error {
+func runAlerting(ctx context.Context, token secret.Token, platformURL string, transport http.RoundTripper, store *metrics.Store) error {
 	retryableClient := retryablehttp.NewClient()
 	retryableClient.RetryWaitMin = time.Second
 	retryableClient.RetryWaitMax = 10 * time.Second
 	retryableClient.RetryMax = 4
+	retryableClient.HTTPClient.Transport = transport
 	retryableClient.Logger = logger.NewRetryableHTTPWrapper(log.Logger.With().Str("component", "alerting_client").Logger())
 
 	httpClient := retryableClient.StandardClient()
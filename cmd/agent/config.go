@@ -0,0 +1,432 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
+	"github.com/traefik/paerser/file"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "<redacted>"
+
+// fileConfig is the configuration file of the run command.
+// Each leaf is tied, through its `flag` tag, to the run command flag it sets. Leaves are kept as strings and parsed by the
+// flags themselves, to share the exact same validation. Leaves tagged `secret:"true"` are redacted when dumped, and those
+// tagged `secret:"url"` only have their password redacted.
+type fileConfig struct {
+	Log        logFileConfig        `yaml:"log,omitempty"`
+	Traefik    traefikFileConfig    `yaml:"traefik,omitempty"`
+	Hub        hubFileConfig        `yaml:"hub,omitempty"`
+	AuthServer authServerFileConfig `yaml:"authServer,omitempty"`
+	Tunnel     tunnelFileConfig     `yaml:"tunnel,omitempty"`
+	Edge       edgeFileConfig       `yaml:"edge,omitempty"`
+	Secret     secretFileConfig     `yaml:"secret,omitempty"`
+	Status     statusFileConfig     `yaml:"status,omitempty"`
+	Metrics    metricsFileConfig    `yaml:"metrics,omitempty"`
+	DataDir    string               `yaml:"dataDir,omitempty" flag:"data-dir"`
+}
+
+type logFileConfig struct {
+	Level  string `yaml:"level,omitempty" flag:"log.level"`
+	Format string `yaml:"format,omitempty" flag:"log.format"`
+}
+
+type traefikFileConfig struct {
+	Host          string                         `yaml:"host,omitempty" flag:"traefik.host"`
+	APIPort       string                         `yaml:"apiPort,omitempty" flag:"traefik.api-port"`
+	TunnelPort    string                         `yaml:"tunnelPort,omitempty" flag:"traefik.tunnel-port"`
+	Metrics       traefikMetricsFileConfig       `yaml:"metrics,omitempty"`
+	Tunnel        traefikTunnelFileConfig        `yaml:"tunnel,omitempty"`
+	TLS           traefikTLSFileConfig           `yaml:"tls,omitempty"`
+	Docker        traefikDockerFileConfig        `yaml:"docker,omitempty"`
+	ConsulCatalog traefikConsulCatalogFileConfig `yaml:"consulCatalog,omitempty"`
+}
+
+type traefikMetricsFileConfig struct {
+	Targets string `yaml:"targets,omitempty" flag:"traefik.metrics.targets"`
+	Label   string `yaml:"label,omitempty" flag:"traefik.metrics.label"`
+}
+
+type traefikTunnelFileConfig struct {
+	TLS           string `yaml:"tls,omitempty" flag:"traefik.tunnel.tls"`
+	DialTimeout   string `yaml:"dialTimeout,omitempty" flag:"traefik.tunnel.dial-timeout"`
+	DialRetries   string `yaml:"dialRetries,omitempty" flag:"traefik.tunnel.dial-retries"`
+	ProxyProtocol string `yaml:"proxyProtocol,omitempty" flag:"traefik.tunnel.proxy-protocol"`
+	PoolSize      string `yaml:"poolSize,omitempty" flag:"traefik.tunnel.pool-size"`
+}
+
+type traefikTLSFileConfig struct {
+	CA       string `yaml:"ca,omitempty" flag:"traefik.tls.ca"`
+	Cert     string `yaml:"cert,omitempty" flag:"traefik.tls.cert"`
+	Key      string `yaml:"key,omitempty" flag:"traefik.tls.key"`
+	Insecure string `yaml:"insecure,omitempty" flag:"traefik.tls.insecure"`
+}
+
+type traefikDockerFileConfig struct {
+	SwarmMode         string                     `yaml:"swarmMode,omitempty" flag:"traefik.docker.swarm-mode"`
+	Endpoint          string                     `yaml:"endpoint,omitempty" flag:"traefik.docker.endpoint"`
+	HTTPClientTimeout string                     `yaml:"httpClientTimeout,omitempty" flag:"traefik.docker.http-client-timeout"`
+	TLS               traefikDockerTLSFileConfig `yaml:"tls,omitempty"`
+}
+
+type traefikDockerTLSFileConfig struct {
+	CA                 string `yaml:"ca,omitempty" flag:"traefik.docker.tls.ca"`
+	CAOptional         string `yaml:"caOptional,omitempty" flag:"traefik.docker.tls.ca-optional"`
+	Cert               string `yaml:"cert,omitempty" flag:"traefik.docker.tls.cert"`
+	Key                string `yaml:"key,omitempty" flag:"traefik.docker.tls.key" secret:"true"`
+	InsecureSkipVerify string `yaml:"insecureSkipVerify,omitempty" flag:"traefik.docker.tls.insecure-skip-verify"`
+}
+
+type traefikConsulCatalogFileConfig struct {
+	Namespace        string                                 `yaml:"namespace,omitempty" flag:"traefik.consulcatalog.namespace"`
+	ExposedByDefault string                                 `yaml:"exposedByDefault,omitempty" flag:"traefik.consulcatalog.exposedbydefault"`
+	Cache            string                                 `yaml:"cache,omitempty" flag:"traefik.consulcatalog.cache"`
+	Watch            string                                 `yaml:"watch,omitempty" flag:"traefik.consulcatalog.watch"`
+	Endpoint         traefikConsulCatalogEndpointFileConfig `yaml:"endpoint,omitempty"`
+}
+
+type traefikConsulCatalogEndpointFileConfig struct {
+	Address    string `yaml:"address,omitempty" flag:"traefik.consulcatalog.endpoint.address"`
+	Scheme     string `yaml:"scheme,omitempty" flag:"traefik.consulcatalog.endpoint.scheme"`
+	Token      string `yaml:"token,omitempty" flag:"traefik.consulcatalog.endpoint.token" secret:"true"`
+	Datacenter string `yaml:"datacenter,omitempty" flag:"traefik.consulcatalog.endpoint.datacenter"`
+}
+
+type hubFileConfig struct {
+	Token    string             `yaml:"token,omitempty" flag:"hub.token" secret:"true"`
+	URL      string             `yaml:"url,omitempty" flag:"hub.url"`
+	UI       hubUIFileConfig    `yaml:"ui,omitempty"`
+	Proxy    hubProxyFileConfig `yaml:"proxy,omitempty"`
+	CABundle string             `yaml:"caBundle,omitempty" flag:"hub.ca-bundle"`
+}
+
+type hubUIFileConfig struct {
+	URL string `yaml:"url,omitempty" flag:"hub.ui.url"`
+}
+
+type hubProxyFileConfig struct {
+	URL     string `yaml:"url,omitempty" flag:"hub.proxy.url" secret:"url"`
+	NoProxy string `yaml:"noProxy,omitempty" flag:"hub.proxy.no-proxy"`
+}
+
+type authServerFileConfig struct {
+	ListenAddr   string                   `yaml:"listenAddr,omitempty" flag:"auth-server.listen-addr"`
+	AdvertiseURL string                   `yaml:"advertiseURL,omitempty" flag:"auth-server.advertise-url"`
+	JWKs         authServerJWKsFileConfig `yaml:"jwks,omitempty"`
+}
+
+type authServerJWKsFileConfig struct {
+	MinTTL             string `yaml:"minTTL,omitempty" flag:"auth-server.jwks.min-ttl"`
+	MinRefetchInterval string `yaml:"minRefetchInterval,omitempty" flag:"auth-server.jwks.min-refetch-interval"`
+}
+
+type tunnelFileConfig struct {
+	Sessions            string `yaml:"sessions,omitempty" flag:"tunnel.sessions"`
+	HealthCheckInterval string `yaml:"healthCheckInterval,omitempty" flag:"tunnel.health-check-interval"`
+	DrainTimeout        string `yaml:"drainTimeout,omitempty" flag:"tunnel.drain-timeout"`
+	AcceptBacklog       string `yaml:"acceptBacklog,omitempty" flag:"tunnel.accept-backlog"`
+	MaxStreamWindowSize string `yaml:"maxStreamWindowSize,omitempty" flag:"tunnel.max-stream-window-size"`
+	SummaryInterval     string `yaml:"summaryInterval,omitempty" flag:"tunnel.summary-interval"`
+	Compression         string `yaml:"compression,omitempty" flag:"tunnel.compression"`
+}
+
+type edgeFileConfig struct {
+	LocalDir  string `yaml:"localDir,omitempty" flag:"edge.local-dir"`
+	LocalMode string `yaml:"localMode,omitempty" flag:"edge.local-mode"`
+}
+
+type secretFileConfig struct {
+	ReloadInterval string                `yaml:"reloadInterval,omitempty" flag:"secret.reload-interval"`
+	Vault          secretVaultFileConfig `yaml:"vault,omitempty"`
+}
+
+type secretVaultFileConfig struct {
+	Addr  string `yaml:"addr,omitempty" flag:"secret.vault.addr"`
+	Token string `yaml:"token,omitempty" flag:"secret.vault.token" secret:"true"`
+}
+
+type statusFileConfig struct {
+	ListenAddr string `yaml:"listenAddr,omitempty" flag:"status.listen-addr"`
+}
+
+type metricsFileConfig struct {
+	MaxPoints   string                       `yaml:"maxPoints,omitempty" flag:"metrics.max-points"`
+	BatchSize   string                       `yaml:"batchSize,omitempty" flag:"metrics.batch-size"`
+	OTLP        metricsOTLPFileConfig        `yaml:"otlp,omitempty"`
+	RemoteWrite metricsRemoteWriteFileConfig `yaml:"remoteWrite,omitempty"`
+	Prometheus  metricsPrometheusFileConfig  `yaml:"prometheus,omitempty"`
+}
+
+type metricsOTLPFileConfig struct {
+	Endpoint string `yaml:"endpoint,omitempty" flag:"metrics.otlp.endpoint"`
+}
+
+type metricsRemoteWriteFileConfig struct {
+	URL string `yaml:"url,omitempty" flag:"metrics.remote-write.url"`
+}
+
+type metricsPrometheusFileConfig struct {
+	ListenAddr string `yaml:"listenAddr,omitempty" flag:"metrics.prometheus.listen-addr"`
+}
+
+// fileConfigLeaf is a setting of the configuration file.
+type fileConfigLeaf struct {
+	// Key is the dotted path of the setting in the configuration file.
+	Key    string
+	Flag   string
+	Secret string
+	Value  reflect.Value
+}
+
+// visitFileConfig calls fn for each setting of the given configuration.
+func visitFileConfig(cfg *fileConfig, fn func(leaf fileConfigLeaf) error) error {
+	return visitFileConfigValue(reflect.ValueOf(cfg).Elem(), "", fn)
+}
+
+func visitFileConfigValue(v reflect.Value, prefix string, fn func(leaf fileConfigLeaf) error) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+
+		key := strings.TrimSuffix(field.Tag.Get("yaml"), ",omitempty")
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := visitFileConfigValue(v.Field(i), key, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := fn(fileConfigLeaf{
+			Key:    key,
+			Flag:   field.Tag.Get("flag"),
+			Secret: field.Tag.Get("secret"),
+			Value:  v.Field(i),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadConfigFile applies the configuration file given through the `config` flag, if any, to the flags of the given context.
+// Flags set on the command line or through environment variables take precedence over the configuration file.
+func loadConfigFile(cliCtx *cli.Context) error {
+	path := cliCtx.String(flagConfig)
+	if path == "" {
+		return nil
+	}
+
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return fmt.Errorf("load configuration file %q: %w", path, err)
+	}
+
+	return visitFileConfig(cfg, func(leaf fileConfigLeaf) error {
+		value := leaf.Value.String()
+		if value == "" || cliCtx.IsSet(leaf.Flag) {
+			return nil
+		}
+
+		if err = cliCtx.Set(leaf.Flag, value); err != nil {
+			return fmt.Errorf("invalid value %q for key %q in configuration file %q: %w", value, leaf.Key, path, err)
+		}
+
+		return nil
+	})
+}
+
+// readConfigFile reads the given YAML or TOML configuration file.
+func readConfigFile(path string) (*fileConfig, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext != ".yaml" && ext != ".yml" && ext != ".toml" {
+		return nil, fmt.Errorf("unsupported file extension %q, must be .yaml, .yml or .toml", ext)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unknown top-level keys are silently dropped by the decoder, and unknown nested ones are reported without their
+	// path, so all keys are checked beforehand.
+	data := make(map[string]interface{})
+	if err = file.DecodeContent(string(content), ext, &data); err != nil {
+		return nil, err
+	}
+
+	if err = checkKeys(data, reflect.TypeOf(fileConfig{}), ""); err != nil {
+		return nil, err
+	}
+
+	var cfg fileConfig
+	if err = file.DecodeContent(string(content), ext, &cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// checkKeys checks that the keys of the given raw configuration match the `yaml` tags of the given struct type,
+// recursively. Keys are matched case-insensitively, as the decoder does.
+func checkKeys(data map[string]interface{}, t reflect.Type, prefix string) error {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fields[strings.ToLower(strings.TrimSuffix(field.Tag.Get("yaml"), ",omitempty"))] = field
+	}
+
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+
+		field, ok := fields[strings.ToLower(key)]
+		if !ok {
+			return fmt.Errorf("unknown key %q", path)
+		}
+
+		// Empty sections are decoded as empty values.
+		section, isSection := data[key].(map[string]interface{})
+		switch {
+		case field.Type.Kind() == reflect.Struct && data[key] == "":
+		case field.Type.Kind() == reflect.Struct && !isSection:
+			return fmt.Errorf("key %q must be a section", path)
+		case field.Type.Kind() == reflect.Struct:
+			if err := checkKeys(section, field.Type, path); err != nil {
+				return err
+			}
+		case isSection:
+			return fmt.Errorf("key %q must be a value, not a section", path)
+		}
+	}
+
+	return nil
+}
+
+// dumpConfig writes as YAML the effective configuration of the given context, secrets redacted.
+func dumpConfig(w io.Writer, cliCtx *cli.Context) error {
+	var cfg fileConfig
+	err := visitFileConfig(&cfg, func(leaf fileConfigLeaf) error {
+		value := cliCtx.Value(leaf.Flag)
+		if value == nil {
+			return fmt.Errorf("unknown flag %q for key %q", leaf.Flag, leaf.Key)
+		}
+
+		leaf.Value.SetString(redact(fmt.Sprint(value), leaf.Secret))
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	if err = enc.Encode(cfg); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+func redact(value, kind string) string {
+	if value == "" {
+		return ""
+	}
+
+	switch kind {
+	case "true":
+		// References only tell where the secret is, they can be shown.
+		for _, scheme := range []string{secret.SchemeFile, secret.SchemeEnv, secret.SchemeVault} {
+			if strings.HasPrefix(value, scheme+":") {
+				return value
+			}
+		}
+
+		return redacted
+	case "url":
+		u, err := url.Parse(value)
+		if err != nil {
+			return redacted
+		}
+
+		return u.Redacted()
+	default:
+		return value
+	}
+}
+
+type configCmd struct {
+	flags []cli.Flag
+}
+
+func newConfigCmd() configCmd {
+	return configCmd{
+		flags: newRunCmd().flags,
+	}
+}
+
+func (c configCmd) build() *cli.Command {
+	return &cli.Command{
+		Name:  "config",
+		Usage: "Manages the configuration of the Hub Agent",
+		Subcommands: []*cli.Command{
+			{
+				Name:   "dump",
+				Usage:  "Prints the effective configuration of the run command, secrets redacted",
+				Flags:  c.flags,
+				Action: c.dump,
+			},
+		},
+	}
+}
+
+func (c configCmd) dump(cliCtx *cli.Context) error {
+	if err := loadConfigFile(cliCtx); err != nil {
+		return err
+	}
+
+	if err := dumpConfig(os.Stdout, cliCtx); err != nil {
+		return fmt.Errorf("dump configuration: %w", err)
+	}
+
+	return nil
+}
@@ -24,6 +24,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -38,19 +39,28 @@ const quotaExceededMiddleware = "quota-exceeded"
 
 const defaultHubTunnelEntrypoint = "traefikhub-tunl"
 
+// CertificateGetter gets the certificates served for edge ingresses.
+type CertificateGetter interface {
+	GetWildcardCertificate(ctx context.Context) (certificate.Certificate, error)
+	GetCertificateByDomains(ctx context.Context, domains []string) (certificate.Certificate, error)
+}
+
 // EdgeUpdater keep edge ingresses and Traefik configuration synchronized.
 type EdgeUpdater struct {
-	certClient    *certificate.Client
+	certClient    CertificateGetter
 	traefikClient *traefik.Client
 	provider      ProviderWatcher
 
 	authServerReachableAddr string
 	catchAllURL             string
-	maxSecuredRoute         int
+
+	maxSecuredRouteMu sync.RWMutex
+	maxSecuredRoute   int
 }
 
-// NewEdgeUpdater creates EdgeUpdater.
-func NewEdgeUpdater(certClient *certificate.Client, traefikClient *traefik.Client, provider ProviderWatcher, authServerReachableAddr, catchAllURL string, maxSecuredRoute int) *EdgeUpdater {
+// NewEdgeUpdater creates EdgeUpdater. When certClient is nil, as in standalone mode, no certificate is pushed
+// and Traefik serves its default one.
+func NewEdgeUpdater(certClient CertificateGetter, traefikClient *traefik.Client, provider ProviderWatcher, authServerReachableAddr, catchAllURL string, maxSecuredRoute int) *EdgeUpdater {
 	return &EdgeUpdater{
 		certClient:              certClient,
 		traefikClient:           traefikClient,
@@ -61,8 +71,17 @@ func NewEdgeUpdater(certClient *certificate.Client, traefikClient *traefik.Clien
 	}
 }
 
+// SetMaxSecuredRoutes sets the maximum number of edge ingresses which can be secured by an ACP.
+// Zero or less means no limit.
+func (e *EdgeUpdater) SetMaxSecuredRoutes(maxSecuredRoute int) {
+	e.maxSecuredRouteMu.Lock()
+	defer e.maxSecuredRouteMu.Unlock()
+
+	e.maxSecuredRoute = maxSecuredRoute
+}
+
 // Update updates Traefik configuration from edge ingresses and ACPs.
-func (e EdgeUpdater) Update(ctx context.Context, ingresses []edge.Ingress, acps []edge.ACP) error {
+func (e *EdgeUpdater) Update(ctx context.Context, ingresses []edge.Ingress, acps []edge.ACP) error {
 	cfg, err := e.defaultDynamicConfiguration(ctx)
 	if err != nil {
 		return fmt.Errorf("default configuration: %w", err)
@@ -83,7 +102,12 @@ func (e EdgeUpdater) Update(ctx context.Context, ingresses []edge.Ingress, acps
 	return nil
 }
 
-func (e EdgeUpdater) appendEdgeToTraefikCfg(ctx context.Context, cfg *dynamic.Configuration, edgeIngresses []edge.Ingress) error {
+func (e *EdgeUpdater) appendEdgeToTraefikCfg(ctx context.Context, cfg *dynamic.Configuration, edgeIngresses []edge.Ingress) error {
+	e.maxSecuredRouteMu.RLock()
+	maxSecuredRoute := e.maxSecuredRoute
+	e.maxSecuredRouteMu.RUnlock()
+
+	var securedRoutes int
 	for _, ingress := range edgeIngresses {
 		logger := log.With().Str("workspace_id", ingress.WorkspaceID).
 			Str("cluster_id", ingress.ClusterID).
@@ -105,7 +129,15 @@ func (e EdgeUpdater) appendEdgeToTraefikCfg(ctx context.Context, cfg *dynamic.Co
 
 		var middleware []string
 		if ingress.ACP != nil {
-			middleware = append(middleware, ingress.ACP.Name)
+			securedRoutes++
+
+			if maxSecuredRoute > 0 && securedRoutes > maxSecuredRoute {
+				// Rather than exposing the route without its ACP, deny all access to it.
+				logger.Warn().Int("max_secured_routes", maxSecuredRoute).Msg("Secured routes quota exceeded")
+				middleware = append(middleware, quotaExceededMiddleware)
+			} else {
+				middleware = append(middleware, ingress.ACP.Name)
+			}
 		}
 
 		var customDomains []string
@@ -117,17 +149,19 @@ func (e EdgeUpdater) appendEdgeToTraefikCfg(ctx context.Context, cfg *dynamic.Co
 
 		routerRule := fmt.Sprintf("Host(`%s`)", ingress.Domain)
 		if len(customDomains) > 0 {
-			certCustom, err := e.certClient.GetCertificateByDomains(ctx, customDomains)
-			if err != nil {
-				return fmt.Errorf("get certificate by domains %q: %w", strings.Join(customDomains, ","), err)
-			}
+			if e.certClient != nil {
+				certCustom, err := e.certClient.GetCertificateByDomains(ctx, customDomains)
+				if err != nil {
+					return fmt.Errorf("get certificate by domains %q: %w", strings.Join(customDomains, ","), err)
+				}
 
-			cfg.TLS.Certificates = append(cfg.TLS.Certificates, &tls.CertAndStores{
-				Certificate: tls.Certificate{
-					CertFile: string(certCustom.Certificate),
-					KeyFile:  string(certCustom.PrivateKey),
-				},
-			})
+				cfg.TLS.Certificates = append(cfg.TLS.Certificates, &tls.CertAndStores{
+					Certificate: tls.Certificate{
+						CertFile: string(certCustom.Certificate),
+						KeyFile:  string(certCustom.PrivateKey),
+					},
+				})
+			}
 
 			routerRule = fmt.Sprintf("Host(`%s`)", ingress.Domain+"`,`"+strings.Join(customDomains, "`,`"))
 		}
@@ -153,7 +187,7 @@ func (e EdgeUpdater) appendEdgeToTraefikCfg(ctx context.Context, cfg *dynamic.Co
 	return nil
 }
 
-func (e EdgeUpdater) appendACPToTraefikCfg(cfg *dynamic.Configuration, acps []edge.ACP) error {
+func (e *EdgeUpdater) appendACPToTraefikCfg(cfg *dynamic.Configuration, acps []edge.ACP) error {
 	for _, acp := range acps {
 		headerToFwd, err := headerToForward(acp)
 		if err != nil {
@@ -171,10 +205,20 @@ func (e EdgeUpdater) appendACPToTraefikCfg(cfg *dynamic.Configuration, acps []ed
 	return nil
 }
 
-func (e EdgeUpdater) defaultDynamicConfiguration(ctx context.Context) (*dynamic.Configuration, error) {
-	cert, err := e.certClient.GetWildcardCertificate(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("get certificate: %w", err)
+func (e *EdgeUpdater) defaultDynamicConfiguration(ctx context.Context) (*dynamic.Configuration, error) {
+	var certificates []*tls.CertAndStores
+	if e.certClient != nil {
+		cert, err := e.certClient.GetWildcardCertificate(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("get certificate: %w", err)
+		}
+
+		certificates = append(certificates, &tls.CertAndStores{
+			Certificate: tls.Certificate{
+				CertFile: string(cert.Certificate),
+				KeyFile:  string(cert.PrivateKey),
+			},
+		})
 	}
 
 	return &dynamic.Configuration{
@@ -226,16 +270,9 @@ func (e EdgeUpdater) defaultDynamicConfiguration(ctx context.Context) (*dynamic.
 			Services: make(map[string]*dynamic.TCPService),
 		},
 		TLS: &dynamic.TLSConfiguration{
-			Stores:  make(map[string]tls.Store),
-			Options: make(map[string]tls.Options),
-			Certificates: []*tls.CertAndStores{
-				{
-					Certificate: tls.Certificate{
-						CertFile: string(cert.Certificate),
-						KeyFile:  string(cert.PrivateKey),
-					},
-				},
-			},
+			Stores:       make(map[string]tls.Store),
+			Options:      make(map[string]tls.Options),
+			Certificates: certificates,
 		},
 		UDP: &dynamic.UDPConfiguration{
 			Routers:  make(map[string]*dynamic.UDPRouter),
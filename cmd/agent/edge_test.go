@@ -34,6 +34,7 @@ import (
 	"github.com/traefik/genconf/dynamic/tls"
 	"github.com/traefik/hub-agent-traefik/pkg/certificate"
 	"github.com/traefik/hub-agent-traefik/pkg/edge"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 	"github.com/traefik/hub-agent-traefik/pkg/traefik"
 )
 
@@ -252,6 +253,76 @@ func TestEdgeUpdater_Update(t *testing.T) {
 	assert.Equal(t, expectedCfg, pushedCfg)
 }
 
+func TestEdgeUpdater_Update_withoutCertClient(t *testing.T) {
+	traefikClient, traefikClientMux := setupTraefikClient(t)
+
+	var pushedCfg *dynamic.Configuration
+	traefikClientMux.HandleFunc("/config", func(rw http.ResponseWriter, req *http.Request) {
+		var gotCfg struct {
+			Configuration *dynamic.Configuration `json:"configuration"`
+		}
+		err := json.NewDecoder(req.Body).Decode(&gotCfg)
+		require.NoError(t, err)
+
+		pushedCfg = gotCfg.Configuration
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	ingresses := []edge.Ingress{
+		{
+			Name:          "name",
+			Domain:        "name.localhost",
+			CustomDomains: []edge.Domain{{Name: "a.com", Verified: true}},
+			Service:       edge.Service{Name: "service-name", Port: 8080},
+		},
+	}
+
+	edgeUpdater := NewEdgeUpdater(nil, traefikClient, providerMock{}, "127.0.0.1", "localhost", 0)
+	err := edgeUpdater.Update(context.Background(), ingresses, nil)
+	require.NoError(t, err)
+
+	require.NotNil(t, pushedCfg)
+	assert.Empty(t, pushedCfg.TLS.Certificates)
+	assert.Equal(t, "Host(`name.localhost`,`a.com`)", pushedCfg.HTTP.Routers["name"].Rule)
+}
+
+func TestEdgeUpdater_Update_maxSecuredRoutes(t *testing.T) {
+	traefikClient, traefikClientMux := setupTraefikClient(t)
+
+	var pushedCfg *dynamic.Configuration
+	traefikClientMux.HandleFunc("/config", func(rw http.ResponseWriter, req *http.Request) {
+		var gotCfg struct {
+			Configuration *dynamic.Configuration `json:"configuration"`
+		}
+		err := json.NewDecoder(req.Body).Decode(&gotCfg)
+		require.NoError(t, err)
+
+		pushedCfg = gotCfg.Configuration
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	ingresses := []edge.Ingress{
+		{Name: "a", Domain: "a.localhost", Service: edge.Service{Name: "a", Port: 80}, ACP: &edge.ACPInfo{Name: "acp"}},
+		{Name: "b", Domain: "b.localhost", Service: edge.Service{Name: "b", Port: 80}},
+		{Name: "c", Domain: "c.localhost", Service: edge.Service{Name: "c", Port: 80}, ACP: &edge.ACPInfo{Name: "acp"}},
+	}
+	acps := []edge.ACP{{Name: "acp", JWT: &edge.ACPJWTConfig{SigningSecret: "secret"}}}
+
+	edgeUpdater := NewEdgeUpdater(nil, traefikClient, providerMock{}, "127.0.0.1", "localhost", 1)
+	err := edgeUpdater.Update(context.Background(), ingresses, acps)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"acp"}, pushedCfg.HTTP.Routers["a"].Middlewares)
+	assert.Empty(t, pushedCfg.HTTP.Routers["b"].Middlewares)
+	assert.Equal(t, []string{quotaExceededMiddleware}, pushedCfg.HTTP.Routers["c"].Middlewares)
+
+	edgeUpdater.SetMaxSecuredRoutes(2)
+	err = edgeUpdater.Update(context.Background(), ingresses, acps)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"acp"}, pushedCfg.HTTP.Routers["c"].Middlewares)
+}
+
 func setupTraefikClient(t *testing.T) (*traefik.Client, *http.ServeMux) {
 	t.Helper()
 
@@ -272,7 +343,7 @@ func setupCertClient(t *testing.T) (*certificate.Client, *http.ServeMux) {
 	srv := httptest.NewServer(mux)
 	t.Cleanup(srv.Close)
 
-	client, err := certificate.NewClient(srv.URL, "token")
+	client, err := certificate.NewClient(srv.URL, secret.StaticToken("token"), http.DefaultTransport)
 	require.NoError(t, err)
 
 	return client, mux
@@ -0,0 +1,160 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/ettle/strcase"
+	"github.com/traefik/hub-agent-traefik/pkg/hubmock"
+	"github.com/traefik/hub-agent-traefik/pkg/logger"
+	"github.com/urfave/cli/v2"
+)
+
+type hubMockCmd struct {
+	flags []cli.Flag
+}
+
+func newHubMockCmd() hubMockCmd {
+	return hubMockCmd{
+		flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    flagLogLevel,
+				Usage:   "Log level to use (debug, info, warn, error or fatal)",
+				EnvVars: []string{strcase.ToSNAKE(flagLogLevel)},
+				Value:   "info",
+			},
+			&cli.StringFlag{
+				Name:    flagLogFormat,
+				Usage:   "Log format to use (json or console)",
+				EnvVars: []string{strcase.ToSNAKE(flagLogFormat)},
+				Value:   "json",
+			},
+			&cli.StringFlag{
+				Name:    flagHubMockListenAddr,
+				Usage:   "Address on which the mock platform listens",
+				EnvVars: []string{hubMockEnvVar(flagHubMockListenAddr)},
+				Value:   "0.0.0.0:8443",
+			},
+			&cli.StringFlag{
+				Name:     flagHubMockFixture,
+				Usage:    "Path to the JSON fixture describing the state served by the mock platform",
+				EnvVars:  []string{hubMockEnvVar(flagHubMockFixture)},
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:    flagHubMockGitRoot,
+				Usage:   "Directory from which topology Git repositories are served. Set to an empty value to not serve them",
+				EnvVars: []string{hubMockEnvVar(flagHubMockGitRoot)},
+				Value:   "hub-mock-git",
+			},
+			&cli.StringFlag{
+				Name:    flagHubMockTLSCert,
+				Usage:   "Path to the certificate of the mock platform. A self-signed one is generated when not set",
+				EnvVars: []string{hubMockEnvVar(flagHubMockTLSCert)},
+			},
+			&cli.StringFlag{
+				Name:    flagHubMockTLSKey,
+				Usage:   "Path to the key of the mock platform certificate",
+				EnvVars: []string{hubMockEnvVar(flagHubMockTLSKey)},
+			},
+			&cli.StringFlag{
+				Name:    flagHubMockTLSCAOut,
+				Usage:   "Path to which the generated self-signed certificate is written, to be given to the agent with --" + flagHubCABundle,
+				EnvVars: []string{hubMockEnvVar(flagHubMockTLSCAOut)},
+				Value:   "hub-mock-ca.pem",
+			},
+		},
+	}
+}
+
+func (h hubMockCmd) build() *cli.Command {
+	return &cli.Command{
+		Name:   "hub-mock",
+		Usage:  "Runs a local stand-in Hub platform, serving the state described by a fixture, for development and testing",
+		Flags:  h.flags,
+		Action: h.run,
+	}
+}
+
+func (h hubMockCmd) run(cliCtx *cli.Context) error {
+	logger.Setup(cliCtx.String(flagLogLevel), cliCtx.String(flagLogFormat))
+
+	fixture, err := hubmock.LoadFixture(cliCtx.String(flagHubMockFixture))
+	if err != nil {
+		return err
+	}
+
+	srv, err := hubmock.NewServer(fixture, cliCtx.String(flagHubMockGitRoot))
+	if err != nil {
+		return fmt.Errorf("create Hub mock: %w", err)
+	}
+
+	listenAddr := cliCtx.String(flagHubMockListenAddr)
+
+	tlsConfig, err := hubMockTLSConfig(cliCtx, listenAddr)
+	if err != nil {
+		return err
+	}
+
+	return srv.Run(cliCtx.Context, listenAddr, tlsConfig)
+}
+
+// hubMockTLSConfig loads the configured certificate, or generates a self-signed one for localhost and
+// the listen address, which is written in PEM to be trusted by agents.
+func hubMockTLSConfig(cliCtx *cli.Context, listenAddr string) (*tls.Config, error) {
+	certFile, keyFile := cliCtx.String(flagHubMockTLSCert), cliCtx.String(flagHubMockTLSKey)
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load certificate: %w", err)
+		}
+
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+	}
+
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if host, _, err := net.SplitHostPort(listenAddr); err == nil && host != "" && !net.ParseIP(host).IsUnspecified() {
+		hosts = append(hosts, host)
+	}
+
+	certPEM, keyPEM, err := hubmock.SelfSignedCertificate(hosts)
+	if err != nil {
+		return nil, fmt.Errorf("generate certificate: %w", err)
+	}
+
+	if caOut := cliCtx.String(flagHubMockTLSCAOut); caOut != "" {
+		if err = os.WriteFile(caOut, certPEM, 0o600); err != nil {
+			return nil, fmt.Errorf("write certificate: %w", err)
+		}
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("load generated certificate: %w", err)
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+func hubMockEnvVar(flag string) string {
+	return "HUB_MOCK_" + strcase.ToSNAKE(flag)
+}
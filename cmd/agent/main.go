@@ -33,14 +33,39 @@ import (
 const (
 	flagAuthServerListenAddr                   = "auth-server.listen-addr"
 	flagAuthServerAdvertiseURL                 = "auth-server.advertise-url"
+	flagAuthServerJWKsMinTTL                   = "auth-server.jwks.min-ttl"
+	flagAuthServerJWKsMinRefetchInterval       = "auth-server.jwks.min-refetch-interval"
+	flagConfig                                 = "config"
+	flagDataDir                                = "data-dir"
+	flagEdgeLocalDir                           = "edge.local-dir"
+	flagEdgeLocalMode                          = "edge.local-mode"
 	flagHubToken                               = "hub.token"
 	flagHubURL                                 = "hub.url"
 	flagHubUIURL                               = "hub.ui.url"
+	flagHubProxyURL                            = "hub.proxy.url"
+	flagHubProxyNoProxy                        = "hub.proxy.no-proxy"
+	flagHubCABundle                            = "hub.ca-bundle"
 	flagLogLevel                               = "log.level"
 	flagLogFormat                              = "log.format"
+	flagMetricsMaxPoints                       = "metrics.max-points"
+	flagMetricsBatchSize                       = "metrics.batch-size"
+	flagMetricsOTLPEndpoint                    = "metrics.otlp.endpoint"
+	flagMetricsRemoteWriteURL                  = "metrics.remote-write.url"
+	flagMetricsPrometheusListenAddr            = "metrics.prometheus.listen-addr"
+	flagSecretReloadInterval                   = "secret.reload-interval"
+	flagSecretVaultAddr                        = "secret.vault.addr"
+	flagSecretVaultToken                       = "secret.vault.token"
+	flagStatusListenAddr                       = "status.listen-addr"
 	flagTraefikHost                            = "traefik.host"
 	flagTraefikAPIPort                         = "traefik.api-port"
 	flagTraefikTunnelPort                      = "traefik.tunnel-port"
+	flagTraefikMetricsTargets                  = "traefik.metrics.targets"
+	flagTraefikMetricsLabel                    = "traefik.metrics.label"
+	flagTraefikTunnelTLS                       = "traefik.tunnel.tls"
+	flagTraefikTunnelDialTimeout               = "traefik.tunnel.dial-timeout"
+	flagTraefikTunnelDialRetries               = "traefik.tunnel.dial-retries"
+	flagTraefikTunnelProxyProtocol             = "traefik.tunnel.proxy-protocol"
+	flagTraefikTunnelPoolSize                  = "traefik.tunnel.pool-size"
 	flagTraefikTLSCA                           = "traefik.tls.ca"
 	flagTraefikTLSCert                         = "traefik.tls.cert"
 	flagTraefikTLSKey                          = "traefik.tls.key"
@@ -61,6 +86,23 @@ const (
 	flagTraefikConsulCatalogEndpointScheme     = "traefik.consulcatalog.endpoint.scheme"
 	flagTraefikConsulCatalogEndpointToken      = "traefik.consulcatalog.endpoint.token"
 	flagTraefikConsulCatalogEndpointDatacenter = "traefik.consulcatalog.endpoint.datacenter"
+	flagTunnelSessions                         = "tunnel.sessions"
+	flagTunnelHealthCheckInterval              = "tunnel.health-check-interval"
+	flagTunnelDrainTimeout                     = "tunnel.drain-timeout"
+	flagTunnelAcceptBacklog                    = "tunnel.accept-backlog"
+	flagTunnelMaxStreamWindowSize              = "tunnel.max-stream-window-size"
+	flagTunnelSummaryInterval                  = "tunnel.summary-interval"
+	flagTunnelCompression                      = "tunnel.compression"
+)
+
+// Flags of the hub-mock command.
+const (
+	flagHubMockListenAddr = "listen-addr"
+	flagHubMockFixture    = "fixture"
+	flagHubMockGitRoot    = "git-root"
+	flagHubMockTLSCert    = "tls.cert"
+	flagHubMockTLSKey     = "tls.key"
+	flagHubMockTLSCAOut   = "tls.ca-out"
 )
 
 func main() {
@@ -77,7 +119,9 @@ func run() error {
 		Usage: "Manages a Traefik Hub agent installation",
 		Commands: []*cli.Command{
 			newRunCmd().build(),
+			newConfigCmd().build(),
 			newVersionCmd().build(),
+			newHubMockCmd().build(),
 		},
 		Version: version.String(),
 	}
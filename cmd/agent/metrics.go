@@ -18,6 +18,13 @@ along with this program. If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
@@ -25,14 +32,234 @@ import (
 	"github.com/traefik/hub-agent-traefik/pkg/logger"
 	"github.com/traefik/hub-agent-traefik/pkg/metrics"
 	"github.com/traefik/hub-agent-traefik/pkg/platform"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 	"github.com/traefik/hub-agent-traefik/pkg/traefik"
+	"github.com/urfave/cli/v2"
 )
 
-func newMetrics(token, platformURL string, cfg platform.MetricsConfig, cfgWatcher *platform.ConfigWatcher, traefikClient *traefik.Client) (*metrics.Manager, *metrics.Store, error) {
+// metricsOptions holds the local settings of the metrics.
+type metricsOptions struct {
+	// DataDir is the directory in which metrics are persisted. Disabled when empty.
+	DataDir string
+	// MaxPoints is the maximum number of data points kept for each table.
+	MaxPoints map[string]int
+	// BatchSize is the maximum number of data points sent in a single request.
+	BatchSize int
+	// Exporters are the exporters to which metrics are exported, by name.
+	Exporters map[string]metrics.Exporter
+	// Prometheus is the exporter serving metrics on a local Prometheus endpoint. Nil when disabled.
+	Prometheus *metrics.PrometheusExporter
+}
+
+func newMetricsOptions(cliCtx *cli.Context) (metricsOptions, error) {
+	maxPoints, err := parseMetricsMaxPoints(cliCtx.String(flagMetricsMaxPoints))
+	if err != nil {
+		return metricsOptions{}, fmt.Errorf("invalid value in `%s` flag: %w", flagMetricsMaxPoints, err)
+	}
+
+	batchSize := cliCtx.Int(flagMetricsBatchSize)
+	if batchSize < 0 {
+		return metricsOptions{}, fmt.Errorf("invalid value %d in `%s` flag, must be positive", batchSize, flagMetricsBatchSize)
+	}
+
+	opts := metricsOptions{
+		DataDir:   cliCtx.String(flagDataDir),
+		MaxPoints: maxPoints,
+		BatchSize: batchSize,
+		Exporters: make(map[string]metrics.Exporter),
+	}
+
+	exportClient := &http.Client{Timeout: 10 * time.Second}
+
+	if endpoint := cliCtx.String(flagMetricsOTLPEndpoint); endpoint != "" {
+		opts.Exporters["otlp"], err = metrics.NewOTLPExporter(exportClient, endpoint)
+		if err != nil {
+			return metricsOptions{}, fmt.Errorf("invalid value in `%s` flag: %w", flagMetricsOTLPEndpoint, err)
+		}
+	}
+
+	if rawURL := cliCtx.String(flagMetricsRemoteWriteURL); rawURL != "" {
+		opts.Exporters["remote-write"], err = metrics.NewRemoteWriteExporter(exportClient, rawURL)
+		if err != nil {
+			return metricsOptions{}, fmt.Errorf("invalid value in `%s` flag: %w", flagMetricsRemoteWriteURL, err)
+		}
+	}
+
+	if listenAddr := cliCtx.String(flagMetricsPrometheusListenAddr); listenAddr != "" {
+		opts.Prometheus = metrics.NewPrometheusExporter(listenAddr)
+		opts.Exporters["prometheus"] = opts.Prometheus
+	}
+
+	return opts, nil
+}
+
+// parseMetricsMaxPoints parses comma separated <table>=<count> pairs.
+func parseMetricsMaxPoints(value string) (map[string]int, error) {
+	maxPoints := make(map[string]int)
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q is not a <table>=<count> pair", pair)
+		}
+
+		tbl := strings.TrimSpace(parts[0])
+		count, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("invalid count %q for table %q", parts[1], tbl)
+		}
+
+		maxPoints[tbl] = count
+	}
+
+	return maxPoints, nil
+}
+
+// metricsTargetsRefreshInterval is the interval at which the scraped Traefik instances are discovered again.
+const metricsTargetsRefreshInterval = 30 * time.Second
+
+// TraefikDiscoverer discovers Traefik instances.
+type TraefikDiscoverer interface {
+	GetTraefikInstances(ctx context.Context, label string) ([]string, error)
+}
+
+// metricsTargets resolves the Traefik instances whose metrics are scraped: the static ones and the ones discovered by
+// label, or the main Traefik instance when there are none.
+type metricsTargets struct {
+	main       metrics.Target
+	static     []string
+	label      string
+	discoverer TraefikDiscoverer
+	apiPort    string
+	newClient  func(apiURL string) (*traefik.Client, error)
+
+	clients    map[string]*traefik.Client
+	discovered []string
+}
+
+func newMetricsTargets(cliCtx *cli.Context, traefikClient *traefik.Client, discoverer TraefikDiscoverer) *metricsTargets {
+	apiPort := cliCtx.String(flagTraefikAPIPort)
+
+	var static []string
+	for _, host := range strings.Split(cliCtx.String(flagTraefikMetricsTargets), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			static = append(static, host)
+		}
+	}
+
+	traefikTLSCA := cliCtx.String(flagTraefikTLSCA)
+	traefikTLSCert := cliCtx.String(flagTraefikTLSCert)
+	traefikTLSKey := cliCtx.String(flagTraefikTLSKey)
+	traefikTLSInsecure := cliCtx.Bool(flagTraefikTLSInsecure)
+
+	return &metricsTargets{
+		main: metrics.Target{
+			Name:   net.JoinHostPort(cliCtx.String(flagTraefikHost), apiPort),
+			Client: traefikClient,
+		},
+		static:     static,
+		label:      cliCtx.String(flagTraefikMetricsLabel),
+		discoverer: discoverer,
+		apiPort:    apiPort,
+		newClient: func(apiURL string) (*traefik.Client, error) {
+			return traefik.NewClient(apiURL, traefikTLSInsecure, traefikTLSCA, traefikTLSCert, traefikTLSKey)
+		},
+		clients: make(map[string]*traefik.Client),
+	}
+}
+
+// Resolve returns the Traefik instances to scrape. When the discovery fails, the previously discovered instances are
+// kept.
+func (t *metricsTargets) Resolve(ctx context.Context) []metrics.Target {
+	if t.label != "" && t.discoverer != nil {
+		discovered, err := t.discoverer.GetTraefikInstances(ctx, t.label)
+		if err != nil {
+			log.Error().Err(err).Str("label", t.label).Msg("Unable to discover Traefik instances")
+		} else {
+			t.discovered = discovered
+		}
+	}
+
+	addrs := metricsTargetAddrs(append(append([]string{}, t.static...), t.discovered...), t.apiPort)
+	if len(addrs) == 0 {
+		return []metrics.Target{t.main}
+	}
+
+	clients := make(map[string]*traefik.Client, len(addrs))
+	targets := make([]metrics.Target, 0, len(addrs))
+	for _, addr := range addrs {
+		client, ok := t.clients[addr]
+		if !ok {
+			var err error
+			client, err = t.newClient("https://" + addr)
+			if err != nil {
+				log.Error().Err(err).Str("target", addr).Msg("Unable to create Traefik client")
+				continue
+			}
+		}
+
+		clients[addr] = client
+		targets = append(targets, metrics.Target{Name: addr, Client: client})
+	}
+	t.clients = clients
+
+	if len(targets) == 0 {
+		return []metrics.Target{t.main}
+	}
+
+	return targets
+}
+
+// Run periodically updates the targets of the given scraper.
+func (t *metricsTargets) Run(ctx context.Context, scraper *metrics.Scraper) {
+	if t.label == "" {
+		return
+	}
+
+	ticker := time.NewTicker(metricsTargetsRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scraper.SetTargets(t.Resolve(ctx))
+		}
+	}
+}
+
+// metricsTargetAddrs returns the deduplicated addresses of the given hosts, using the given port for the hosts without
+// one.
+func metricsTargetAddrs(hosts []string, port string) []string {
+	seen := make(map[string]struct{}, len(hosts))
+	var addrs []string
+	for _, host := range hosts {
+		addr := host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			addr = net.JoinHostPort(host, port)
+		}
+
+		if _, ok := seen[addr]; ok {
+			continue
+		}
+		seen[addr] = struct{}{}
+		addrs = append(addrs, addr)
+	}
+
+	return addrs
+}
+
+func newMetrics(token secret.Token, platformURL string, transport http.RoundTripper, cfg platform.MetricsConfig, cfgWatcher *platform.ConfigWatcher, scraper *metrics.Scraper, opts metricsOptions) (*metrics.Manager, *metrics.Store, error) {
 	rc := retryablehttp.NewClient()
 	rc.RetryWaitMin = time.Second
 	rc.RetryWaitMax = 10 * time.Second
 	rc.RetryMax = 4
+	rc.HTTPClient.Transport = transport
 	rc.Logger = logger.NewRetryableHTTPWrapper(log.Logger.With().Str("component", "metrics_client").Logger())
 
 	httpClient := rc.StandardClient()
@@ -43,13 +270,37 @@ func newMetrics(token, platformURL string, cfg platform.MetricsConfig, cfgWatche
 	}
 
 	store := metrics.NewStore()
-	scraper := metrics.NewScraper(traefikClient)
+	if opts.DataDir != "" {
+		store, err = metrics.NewPersistentStore(opts.DataDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("create metrics store: %w", err)
+		}
+	}
+
+	for tbl, maxPoints := range opts.MaxPoints {
+		if err = store.SetMaxPoints(tbl, maxPoints); err != nil {
+			_ = store.Close()
+			return nil, nil, fmt.Errorf("invalid value in `%s` flag: %w", flagMetricsMaxPoints, err)
+		}
+	}
 
 	mgr := metrics.NewManager(client, store, scraper)
 	mgr.SetConfig(cfg.Interval, cfg.Tables)
+	mgr.SetBatchSize(opts.BatchSize)
+
+	names := make([]string, 0, len(opts.Exporters))
+	for name := range opts.Exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		mgr.AddExporter(name, opts.Exporters[name])
+	}
 
-	cfgWatcher.AddListener(func(cfg platform.Config) {
+	cfgWatcher.AddListener(func(_ context.Context, cfg platform.Config) error {
 		mgr.SetConfig(cfg.Metrics.Interval, cfg.Metrics.Tables)
+		return nil
 	})
 
 	return mgr, store, nil
@@ -0,0 +1,100 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/metrics"
+	"github.com/traefik/hub-agent-traefik/pkg/traefik"
+)
+
+func TestParseMetricsMaxPoints(t *testing.T) {
+	got, err := parseMetricsMaxPoints(" 1m=100, 10m = 20 ,1d=0,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"1m": 100, "10m": 20, "1d": 0}, got)
+
+	got, err = parseMetricsMaxPoints("")
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	for _, value := range []string{"1m", "1m=many", "1m=-1"} {
+		_, err = parseMetricsMaxPoints(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestMetricsTargetAddrs(t *testing.T) {
+	got := metricsTargetAddrs([]string{"traefik-1", "traefik-2:8443", "10.0.0.3", "traefik-1:9900", "::1"}, "9900")
+
+	assert.Equal(t, []string{"traefik-1:9900", "traefik-2:8443", "10.0.0.3:9900", "[::1]:9900"}, got)
+}
+
+type discovererMock func(ctx context.Context, label string) ([]string, error)
+
+func (m discovererMock) GetTraefikInstances(ctx context.Context, label string) ([]string, error) {
+	return m(ctx, label)
+}
+
+func TestMetricsTargets_Resolve(t *testing.T) {
+	var (
+		discovered   = []string{"10.0.0.2", "10.0.0.3"}
+		discoveryErr error
+	)
+	mainClient, err := traefik.NewClient("https://traefik:9900", true, "", "", "")
+	require.NoError(t, err)
+
+	targets := &metricsTargets{
+		main:   metrics.Target{Name: "traefik:9900", Client: mainClient},
+		static: []string{"10.0.0.2"},
+		label:  "traefik.replica",
+		discoverer: discovererMock(func(_ context.Context, label string) ([]string, error) {
+			assert.Equal(t, "traefik.replica", label)
+			return discovered, discoveryErr
+		}),
+		apiPort: "9900",
+		newClient: func(apiURL string) (*traefik.Client, error) {
+			return traefik.NewClient(apiURL, true, "", "", "")
+		},
+		clients: make(map[string]*traefik.Client),
+	}
+
+	assert.Equal(t, []string{"10.0.0.2:9900", "10.0.0.3:9900"}, targetNames(targets.Resolve(context.Background())))
+
+	// The previously discovered instances are kept when the discovery fails.
+	discoveryErr = errors.New("docker is unreachable")
+	assert.Equal(t, []string{"10.0.0.2:9900", "10.0.0.3:9900"}, targetNames(targets.Resolve(context.Background())))
+
+	// The main instance is scraped when no other one is known.
+	discovered, discoveryErr = nil, nil
+	targets.static = nil
+	assert.Equal(t, []string{"traefik:9900"}, targetNames(targets.Resolve(context.Background())))
+}
+
+func targetNames(targets []metrics.Target) []string {
+	names := make([]string, 0, len(targets))
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+
+	return names
+}
@@ -32,3 +32,7 @@ func (m providerMock) Watch(ctx context.Context, clusterID string, fn func(map[s
 func (m providerMock) GetIP(ctx context.Context, containerName, network string) (string, error) {
 	return "127.0.0.1", nil
 }
+
+func (m providerMock) GetTraefikInstances(ctx context.Context, label string) ([]string, error) {
+	return nil, nil
+}
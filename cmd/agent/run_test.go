@@ -0,0 +1,80 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/outbound"
+	"github.com/urfave/cli/v2"
+)
+
+func TestCreateTunnelConfig(t *testing.T) {
+	tests := []struct {
+		desc    string
+		args    []string
+		wantErr string
+	}{
+		{
+			desc: "default values",
+		},
+		{
+			desc: "valid values",
+			args: []string{"--tunnel.accept-backlog", "1", "--tunnel.max-stream-window-size", "1048576"},
+		},
+		{
+			desc:    "accept backlog of zero",
+			args:    []string{"--tunnel.accept-backlog", "0"},
+			wantErr: "invalid value 0 in `tunnel.accept-backlog` flag, must be positive",
+		},
+		{
+			desc:    "negative accept backlog",
+			args:    []string{"--tunnel.accept-backlog", "-1"},
+			wantErr: "invalid value -1 in `tunnel.accept-backlog` flag, must be positive",
+		},
+		{
+			desc:    "stream window size below the yamux minimum",
+			args:    []string{"--tunnel.max-stream-window-size", "1024"},
+			wantErr: "invalid value 1024 in `tunnel.max-stream-window-size` flag, must be between 262144 and 4294967295",
+		},
+		{
+			desc:    "stream window size overflowing",
+			args:    []string{"--tunnel.max-stream-window-size", "4294967296"},
+			wantErr: "invalid value 4294967296 in `tunnel.max-stream-window-size` flag, must be between 262144 and 4294967295",
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			err := runConfigApp(t, test.args, func(cliCtx *cli.Context) error {
+				_, err := createTunnelConfig(cliCtx, outbound.Config{}, nil)
+				return err
+			})
+
+			if test.wantErr != "" {
+				assert.EqualError(t, err, test.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+)
+
+// agentSecrets holds the secret settings of the run command, resolved from their references and kept up to date.
+type agentSecrets struct {
+	HubToken     *secret.Source
+	DockerTLSKey *secret.Source
+	ConsulToken  *secret.Source
+}
+
+// newAgentSecrets resolves the secret settings of the given context.
+func newAgentSecrets(cliCtx *cli.Context) (agentSecrets, error) {
+	resolver := secret.NewResolver()
+
+	if addr := cliCtx.String(flagSecretVaultAddr); addr != "" {
+		// The Vault token can itself be given as a file or environment variable reference.
+		vaultToken, err := resolver.Resolve(cliCtx.Context, cliCtx.String(flagSecretVaultToken))
+		if err != nil {
+			return agentSecrets{}, fmt.Errorf("resolve %s: %w", flagSecretVaultToken, err)
+		}
+
+		vault, err := secret.NewVaultProvider(addr, vaultToken, nil)
+		if err != nil {
+			return agentSecrets{}, fmt.Errorf("create Vault provider: %w", err)
+		}
+
+		resolver.Register(secret.SchemeVault, vault)
+	}
+
+	var (
+		secrets agentSecrets
+		err     error
+	)
+
+	secrets.HubToken, err = resolver.NewSource(cliCtx.Context, flagHubToken, cliCtx.String(flagHubToken))
+	if err != nil {
+		return agentSecrets{}, err
+	}
+
+	secrets.DockerTLSKey, err = resolver.NewSource(cliCtx.Context, flagTraefikDockerTLSKey, cliCtx.String(flagTraefikDockerTLSKey))
+	if err != nil {
+		return agentSecrets{}, err
+	}
+
+	secrets.ConsulToken, err = resolver.NewSource(cliCtx.Context, flagTraefikConsulCatalogEndpointToken, cliCtx.String(flagTraefikConsulCatalogEndpointToken))
+	if err != nil {
+		return agentSecrets{}, err
+	}
+
+	// The Docker and Consul clients are created once, with the secrets known at startup.
+	for name, src := range map[string]*secret.Source{
+		flagTraefikDockerTLSKey:               secrets.DockerTLSKey,
+		flagTraefikConsulCatalogEndpointToken: secrets.ConsulToken,
+	} {
+		name := name
+		src.OnChange(func(string) {
+			log.Warn().Str("secret", name).Msg("Secret changed, restart the agent to apply it")
+		})
+	}
+
+	return secrets, nil
+}
+
+// watch reloads the secrets at the reload interval of the given context, and whenever the agent receives SIGHUP,
+// until ctx is canceled.
+func (s agentSecrets) watch(ctx context.Context, cliCtx *cli.Context, group *errgroup.Group) {
+	sources := []*secret.Source{s.HubToken, s.DockerTLSKey, s.ConsulToken}
+
+	group.Go(func() error {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
+		for {
+			select {
+			case <-hup:
+				log.Info().Msg("SIGHUP received, reloading secrets")
+
+				for _, src := range sources {
+					if err := src.Reload(ctx); err != nil {
+						log.Error().Err(err).Msg("Unable to reload secret")
+					}
+				}
+
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	})
+
+	interval := cliCtx.Duration(flagSecretReloadInterval)
+	if interval <= 0 {
+		return
+	}
+
+	for _, src := range sources {
+		src := src
+		group.Go(func() error {
+			src.Run(ctx, interval)
+			return nil
+		})
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+	"github.com/traefik/hub-agent-traefik/pkg/status"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/sync/errgroup"
+)
+
+// runStandalone runs the agent without the Hub platform: edge ingresses and ACPs only come from the local
+// definitions of the given directory, and are served by the ACP server and the generated Traefik configuration.
+// No certificate is pushed to Traefik, which serves its default one.
+func (r runCmd) runStandalone(cliCtx *cli.Context, localDir string, secrets agentSecrets) error {
+	log.Info().Str("dir", localDir).Msg("Running standalone from local edge definitions")
+
+	localDefs := edge.NewLocalDir(localDir)
+	if _, _, err := localDefs.Load(); err != nil {
+		return fmt.Errorf("load local edge definitions: %w", err)
+	}
+
+	traefikClient, err := newTraefikClient(cliCtx)
+	if err != nil {
+		return err
+	}
+
+	reachableURL, err := authServerReachableURL(cliCtx, traefikClient)
+	if err != nil {
+		return err
+	}
+
+	acpServer := newACPServer(cliCtx)
+
+	dockerProvider, err := newDockerProvider(cliCtx, secrets.DockerTLSKey.Value())
+	if err != nil {
+		return err
+	}
+
+	edgeUpdater := NewEdgeUpdater(nil, traefikClient, dockerProvider, reachableURL, cliCtx.String(flagHubUIURL), 0)
+
+	edgeWatcher := edge.NewWatcher(nil, time.Minute)
+	edgeWatcher.SetLocalDir(localDefs, edge.LocalModeReplace)
+
+	edgeWatcher.AddListener(edgeUpdater.Update)
+	edgeWatcher.AddListener(func(_ context.Context, _ []edge.Ingress, acps []edge.ACP) error {
+		return acpServer.UpdateHandler(acps)
+	})
+
+	group, ctx := errgroup.WithContext(cliCtx.Context)
+	secrets.watch(ctx, cliCtx, group)
+
+	group.Go(func() error {
+		edgeWatcher.Run(ctx)
+		return nil
+	})
+
+	group.Go(func() error {
+		return acpServer.Run(ctx)
+	})
+
+	if listenAddr := cliCtx.String(flagStatusListenAddr); listenAddr != "" {
+		statusServer := status.NewServer(listenAddr)
+		statusServer.Register("mode", func() interface{} {
+			return "standalone"
+		})
+
+		group.Go(func() error {
+			return statusServer.Run(ctx)
+		})
+	}
+
+	return group.Wait()
+}
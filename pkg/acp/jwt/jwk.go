@@ -20,14 +20,17 @@ package jwt
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/pquerna/cachecontrol"
+	"github.com/rs/zerolog/log"
 	"gopkg.in/square/go-jose.v2"
 )
 
@@ -156,21 +159,50 @@ func (k *FileKeySet) updateKeySet() error {
 	return nil
 }
 
+// RemoteKeySetOptions configures how RemoteKeySets are fetched and refreshed.
+type RemoteKeySetOptions struct {
+	// MinTTL is the minimum duration fetched keys are considered fresh, whatever the cache headers sent by the server.
+	MinTTL time.Duration
+	// MinRefetchInterval is the minimum interval between two fetches triggered from the request path,
+	// that is when an unknown key ID is presented or when a previous refresh failed.
+	MinRefetchInterval time.Duration
+}
+
 // RemoteKeySet resolves a key set based on a key set URL, and keeps it up to date.
+// Once keys have been fetched, expired keys keep being served while they are refreshed in the background,
+// and if the refresh fails.
 type RemoteKeySet struct {
 	url string
-
-	mu       sync.RWMutex
-	keys     jose.JSONWebKeySet
-	expiry   time.Time
-	updating *inflight
-	client   *http.Client
+	// discovery indicates url is the URL of an OpenID provider, and the key set URL must be read
+	// from its discovery document.
+	discovery bool
+	opts      RemoteKeySetOptions
+
+	mu        sync.RWMutex
+	keys      jose.JSONWebKeySet
+	expiry    time.Time
+	lastFetch time.Time
+	lastUsed  time.Time
+	updating  *inflight
+	client    *http.Client
 }
 
 // NewRemoteKeySet returns a RemoteKeySet.
-func NewRemoteKeySet(url string) *RemoteKeySet {
+func NewRemoteKeySet(url string, opts RemoteKeySetOptions) *RemoteKeySet {
+	return newRemoteKeySet(url, false, opts)
+}
+
+// NewDiscoveryRemoteKeySet returns a RemoteKeySet fetching its keys from the key set URL advertised by
+// the OpenID provider discovery document of the given issuer.
+func NewDiscoveryRemoteKeySet(issuerURL string, opts RemoteKeySetOptions) *RemoteKeySet {
+	return newRemoteKeySet(issuerURL, true, opts)
+}
+
+func newRemoteKeySet(url string, discovery bool, opts RemoteKeySetOptions) *RemoteKeySet {
 	return &RemoteKeySet{
-		url: url,
+		url:       url,
+		discovery: discovery,
+		opts:      opts,
 		client: &http.Client{
 			Transport: &http.Transport{
 				DialContext: (&net.Dialer{
@@ -186,36 +218,68 @@ func NewRemoteKeySet(url string) *RemoteKeySet {
 
 // Key returns a key for a given key ID.
 func (s *RemoteKeySet) Key(ctx context.Context, keyID string) (*jose.JSONWebKey, error) {
-	if err := s.updateKeySet(ctx); err != nil {
-		return nil, err
+	s.mu.Lock()
+	s.lastUsed = time.Now()
+	fetched := !s.expiry.IsZero()
+	s.mu.Unlock()
+
+	// Until keys have been fetched once, there is nothing to serve: block on the fetch.
+	if !fetched {
+		if err := s.Refresh(ctx); err != nil {
+			return nil, err
+		}
 	}
 
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	if s.isExpired() && s.canRefetch() {
+		go func() {
+			refreshCtx, cancel := context.WithTimeout(context.Background(), s.client.Timeout)
+			defer cancel()
 
-	keys := s.keys.Key(keyID)
-	if len(keys) == 0 {
+			if err := s.Refresh(refreshCtx); err != nil {
+				log.Error().Err(err).Str("url", s.url).Msg("Unable to refresh JWK set, serving stale keys")
+			}
+		}()
+	}
+
+	if key := s.key(keyID); key != nil {
+		return key, nil
+	}
+
+	// The key may have been rotated since the last fetch.
+	if !s.canRefetch() {
 		return nil, nil
 	}
-	return &keys[0], nil
-}
 
-func (s *RemoteKeySet) updateKeySet(ctx context.Context) error {
-	if !s.isExpired() {
-		return nil
+	if err := s.Refresh(ctx); err != nil {
+		return nil, err
 	}
 
+	return s.key(keyID), nil
+}
+
+// Refresh fetches the key set. If a fetch is already in progress, it waits for its result instead.
+// On failure, the previously fetched keys are kept.
+func (s *RemoteKeySet) Refresh(ctx context.Context) error {
 	s.mu.Lock()
 	if s.updating == nil {
 		s.updating = newInflight()
+		s.lastFetch = time.Now()
 
 		go func() {
-			keySet, expiry, err := fetchKeys(ctx, s.client, s.url)
+			// The fetch outlives the context of the caller which triggered it, as others may be waiting for it.
+			fetchCtx, cancel := context.WithTimeout(context.Background(), s.client.Timeout)
+			defer cancel()
+
+			keySet, expiry, err := s.fetch(fetchCtx)
 
 			s.mu.Lock()
 			defer s.mu.Unlock()
 
 			if err == nil {
+				if minExpiry := time.Now().Add(s.opts.MinTTL); expiry.Before(minExpiry) {
+					expiry = minExpiry
+				}
+
 				s.keys = *keySet
 				s.expiry = expiry
 			}
@@ -231,6 +295,30 @@ func (s *RemoteKeySet) updateKeySet(ctx context.Context) error {
 	return updating.Wait(ctx)
 }
 
+func (s *RemoteKeySet) fetch(ctx context.Context) (*jose.JSONWebKeySet, time.Time, error) {
+	keySetURL := s.url
+	if s.discovery {
+		var err error
+		keySetURL, err = discoverKeySetURL(ctx, s.client, s.url)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+
+	return fetchKeys(ctx, s.client, keySetURL)
+}
+
+func (s *RemoteKeySet) key(keyID string) *jose.JSONWebKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := s.keys.Key(keyID)
+	if len(keys) == 0 {
+		return nil
+	}
+	return &keys[0]
+}
+
 func (s *RemoteKeySet) isExpired() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -238,6 +326,212 @@ func (s *RemoteKeySet) isExpired() bool {
 	return time.Now().After(s.expiry)
 }
 
+// expiresWithin returns whether the keys expire in less than d.
+func (s *RemoteKeySet) expiresWithin(d time.Duration) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return time.Now().Add(d).After(s.expiry)
+}
+
+// canRefetch returns whether a fetch can be triggered from the request path.
+func (s *RemoteKeySet) canRefetch() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return time.Since(s.lastFetch) >= s.opts.MinRefetchInterval
+}
+
+func (s *RemoteKeySet) unusedFor() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return time.Since(s.lastUsed)
+}
+
+// RemoteKeySets holds the RemoteKeySets used by JWT handlers, so they survive handler updates,
+// and refreshes them in the background before they expire.
+type RemoteKeySets struct {
+	opts RemoteKeySetOptions
+
+	mu   sync.Mutex
+	sets map[remoteKeySetKey]*RemoteKeySet
+	// dynSets holds the key sets of trusted issuers, created from the tokens presented to handlers.
+	dynSets map[remoteKeySetKey]*RemoteKeySet
+}
+
+type remoteKeySetKey struct {
+	url       string
+	discovery bool
+}
+
+// NewRemoteKeySets returns a new RemoteKeySets.
+func NewRemoteKeySets(opts RemoteKeySetOptions) *RemoteKeySets {
+	return &RemoteKeySets{
+		opts:    opts,
+		sets:    make(map[remoteKeySetKey]*RemoteKeySet),
+		dynSets: make(map[remoteKeySetKey]*RemoteKeySet),
+	}
+}
+
+// KeySet returns the RemoteKeySet for the given URL, creating it if needed.
+// When discovery is true, url is the URL of an OpenID provider.
+func (r *RemoteKeySets) KeySet(url string, discovery bool) *RemoteKeySet {
+	k := remoteKeySetKey{url: url, discovery: discovery}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ks, ok := r.sets[k]; ok {
+		return ks
+	}
+
+	ks := newRemoteKeySet(url, discovery, r.opts)
+	ks.lastUsed = time.Now()
+	r.sets[k] = ks
+
+	return ks
+}
+
+// DynamicKeySet returns the RemoteKeySet for the given URL, derived from the issuer of a token, creating it if needed.
+// A new key set is fetched right away and only kept if the fetch succeeded, so that unreachable URLs are not polled in
+// the background. At most maxDynamicKeySets key sets are kept.
+func (r *RemoteKeySets) DynamicKeySet(ctx context.Context, url string, discovery bool) (*RemoteKeySet, error) {
+	k := remoteKeySetKey{url: url, discovery: discovery}
+
+	r.mu.Lock()
+	ks, ok := r.dynSets[k]
+	full := len(r.dynSets) >= maxDynamicKeySets
+	r.mu.Unlock()
+
+	if ok {
+		return ks, nil
+	}
+	if full {
+		return nil, fmt.Errorf("too many issuer key sets, unable to add %q", url)
+	}
+
+	ks = newRemoteKeySet(url, discovery, r.opts)
+	if err := ks.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.dynSets[k]; ok {
+		return existing, nil
+	}
+	if len(r.dynSets) >= maxDynamicKeySets {
+		return nil, fmt.Errorf("too many issuer key sets, unable to add %q", url)
+	}
+
+	ks.mu.Lock()
+	ks.lastUsed = time.Now()
+	ks.mu.Unlock()
+
+	r.dynSets[k] = ks
+
+	return ks, nil
+}
+
+// Prefetch fetches in the background the given key set if it has never been fetched.
+func (r *RemoteKeySets) Prefetch(ks *RemoteKeySet) {
+	ks.mu.RLock()
+	fetched := !ks.expiry.IsZero()
+	ks.mu.RUnlock()
+
+	if fetched {
+		return
+	}
+
+	go func() {
+		if err := ks.Refresh(context.Background()); err != nil {
+			log.Error().Err(err).Str("url", ks.url).Msg("Unable to prefetch JWK set")
+		}
+	}()
+}
+
+// Run refreshes key sets about to expire at the given interval, and forgets key sets which haven't been used
+// for a while, until the given context is canceled.
+func (r *RemoteKeySets) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			r.refresh(ctx, interval)
+		}
+	}
+}
+
+func (r *RemoteKeySets) refresh(ctx context.Context, interval time.Duration) {
+	r.mu.Lock()
+	var sets []*RemoteKeySet
+	for _, all := range []map[remoteKeySetKey]*RemoteKeySet{r.sets, r.dynSets} {
+		for k, ks := range all {
+			if ks.unusedFor() > unusedKeySetTTL {
+				delete(all, k)
+				continue
+			}
+
+			// Refresh a bit before the keys expire, so requests never wait for them or see stale keys.
+			if ks.expiresWithin(2 * interval) {
+				sets = append(sets, ks)
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	for _, ks := range sets {
+		if err := ks.Refresh(ctx); err != nil {
+			log.Error().Err(err).Str("url", ks.url).Msg("Unable to refresh JWK set")
+		}
+	}
+}
+
+const (
+	// unusedKeySetTTL is the duration after which a key set which hasn't been used is not refreshed anymore.
+	unusedKeySetTTL = 24 * time.Hour
+	// maxDynamicKeySets is the maximum number of key sets created from the issuer of tokens.
+	maxDynamicKeySets = 100
+)
+
+func discoverKeySetURL(ctx context.Context, client *http.Client, issuerURL string) (string, error) {
+	discoveryURL := strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, http.NoBody)
+	if err != nil {
+		return "", fmt.Errorf("unable to build discovery request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("unable to fetch discovery document: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected discovery status code %q", resp.Status)
+	}
+
+	var doc struct {
+		JWKsURI string `json:"jwks_uri"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", fmt.Errorf("unable to decode discovery document: %w", err)
+	}
+
+	if doc.JWKsURI == "" {
+		return "", errors.New("no jwks_uri in discovery document")
+	}
+
+	return doc.JWKsURI, nil
+}
+
 func fetchKeys(ctx context.Context, client *http.Client, url string) (*jose.JSONWebKeySet, time.Time, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
 	if err != nil {
@@ -260,7 +554,7 @@ func fetchKeys(ctx context.Context, client *http.Client, url string) (*jose.JSON
 	}
 
 	// If the server doesn't provide cache control headers, assume the
-	// keys expire immediately.
+	// keys expire immediately. The minimum TTL of the key set applies on top of it.
 	expiry := time.Now()
 	_, e, err := cachecontrol.CachableResponse(req, resp, cachecontrol.Options{})
 	if err == nil && e.After(expiry) {
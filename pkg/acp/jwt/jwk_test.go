@@ -20,9 +20,12 @@ package jwt_test
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -108,7 +111,7 @@ func TestRemoteKeySet_KeysFetchesKeySet(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(hdlr))
 	defer srv.Close()
 
-	ks := jwt.NewRemoteKeySet(srv.URL)
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetOptions{})
 
 	gotFooKey, err := ks.Key(context.Background(), "foo-key")
 	require.NoError(t, err)
@@ -121,14 +124,14 @@ func TestRemoteKeySet_KeysFetchesKeySet(t *testing.T) {
 	assert.Equal(t, wantKeys.Key("bar-key")[0], *gotBarKey)
 }
 
-func TestRemoteKeySet_KeysFetchesKeySetWithoutCache(t *testing.T) {
+func TestRemoteKeySet_KeysAppliesMinTTLWithoutCache(t *testing.T) {
 	var wantKeys jose.JSONWebKeySet
 	err := json.Unmarshal([]byte(jwkeys), &wantKeys)
 	require.NoError(t, err)
 
-	var hdlrCalled int
+	var hdlrCalled int32
 	hdlr := func(rw http.ResponseWriter, req *http.Request) {
-		hdlrCalled++
+		atomic.AddInt32(&hdlrCalled, 1)
 
 		_, _ = rw.Write([]byte(jwkeys))
 	}
@@ -136,7 +139,7 @@ func TestRemoteKeySet_KeysFetchesKeySetWithoutCache(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(hdlr))
 	defer srv.Close()
 
-	ks := jwt.NewRemoteKeySet(srv.URL)
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetOptions{MinTTL: time.Minute})
 
 	gotFooKey, err := ks.Key(context.Background(), "foo-key")
 	require.NoError(t, err)
@@ -144,11 +147,214 @@ func TestRemoteKeySet_KeysFetchesKeySetWithoutCache(t *testing.T) {
 	gotBarKey, err := ks.Key(context.Background(), "bar-key")
 	require.NoError(t, err)
 
-	assert.Equal(t, 2, hdlrCalled)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hdlrCalled))
 	assert.Equal(t, wantKeys.Key("foo-key")[0], *gotFooKey)
 	assert.Equal(t, wantKeys.Key("bar-key")[0], *gotBarKey)
 }
 
+func TestRemoteKeySet_KeysServesStaleKeysWhenRefreshFails(t *testing.T) {
+	var wantKeys jose.JSONWebKeySet
+	err := json.Unmarshal([]byte(jwkeys), &wantKeys)
+	require.NoError(t, err)
+
+	var hdlrCalled int32
+	hdlr := func(rw http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&hdlrCalled, 1) > 1 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = rw.Write([]byte(jwkeys))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(hdlr))
+	defer srv.Close()
+
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetOptions{})
+
+	_, err = ks.Key(context.Background(), "foo-key")
+	require.NoError(t, err)
+
+	// Keys are expired, a background refresh is triggered and fails.
+	require.Eventually(t, func() bool {
+		gotFooKey, err := ks.Key(context.Background(), "foo-key")
+		require.NoError(t, err)
+		assert.Equal(t, wantKeys.Key("foo-key")[0], *gotFooKey)
+
+		return atomic.LoadInt32(&hdlrCalled) > 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRemoteKeySet_KeysRefetchesOnUnknownKeyID(t *testing.T) {
+	var wantKeys jose.JSONWebKeySet
+	err := json.Unmarshal([]byte(jwkeys), &wantKeys)
+	require.NoError(t, err)
+
+	var hdlrCalled int32
+	hdlr := func(rw http.ResponseWriter, req *http.Request) {
+		keySet := jose.JSONWebKeySet{Keys: wantKeys.Key("foo-key")}
+		// Simulate a key rotation after the first fetch.
+		if atomic.AddInt32(&hdlrCalled, 1) > 1 {
+			keySet = wantKeys
+		}
+
+		rw.Header().Add("Cache-Control", "max-age=600")
+		_ = json.NewEncoder(rw).Encode(keySet)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(hdlr))
+	defer srv.Close()
+
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetOptions{})
+
+	_, err = ks.Key(context.Background(), "foo-key")
+	require.NoError(t, err)
+
+	gotBarKey, err := ks.Key(context.Background(), "bar-key")
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hdlrCalled))
+	require.NotNil(t, gotBarKey)
+	assert.Equal(t, wantKeys.Key("bar-key")[0].KeyID, gotBarKey.KeyID)
+}
+
+func TestRemoteKeySet_KeysRateLimitsUnknownKeyIDRefetches(t *testing.T) {
+	var hdlrCalled int32
+	hdlr := func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hdlrCalled, 1)
+
+		rw.Header().Add("Cache-Control", "max-age=600")
+		_, _ = rw.Write([]byte(jwkeys))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(hdlr))
+	defer srv.Close()
+
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetOptions{MinRefetchInterval: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		gotKey, err := ks.Key(context.Background(), "meh-key")
+		require.NoError(t, err)
+		assert.Nil(t, gotKey)
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hdlrCalled))
+}
+
+func TestRemoteKeySet_KeysUsesOIDCDiscovery(t *testing.T) {
+	var wantKeys jose.JSONWebKeySet
+	err := json.Unmarshal([]byte(jwkeys), &wantKeys)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/realms/foo/.well-known/openid-configuration", func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{"issuer":"` + srv.URL + `/realms/foo","jwks_uri":"` + srv.URL + `/realms/foo/certs"}`))
+	})
+	mux.HandleFunc("/realms/foo/certs", func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(jwkeys))
+	})
+
+	ks := jwt.NewDiscoveryRemoteKeySet(srv.URL+"/realms/foo", jwt.RemoteKeySetOptions{})
+
+	gotFooKey, err := ks.Key(context.Background(), "foo-key")
+	require.NoError(t, err)
+
+	assert.Equal(t, wantKeys.Key("foo-key")[0], *gotFooKey)
+}
+
+func TestRemoteKeySets_RunRefreshesKeySetsBeforeExpiry(t *testing.T) {
+	var hdlrCalled int32
+	hdlr := func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hdlrCalled, 1)
+
+		_, _ = rw.Write([]byte(jwkeys))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(hdlr))
+	defer srv.Close()
+
+	keySets := jwt.NewRemoteKeySets(jwt.RemoteKeySetOptions{MinTTL: 50 * time.Millisecond, MinRefetchInterval: time.Minute})
+
+	ks := keySets.KeySet(srv.URL, false)
+	assert.Same(t, ks, keySets.KeySet(srv.URL, false))
+
+	_, err := ks.Key(context.Background(), "foo-key")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go keySets.Run(ctx, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&hdlrCalled) > 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRemoteKeySets_DynamicKeySetNotKeptWhenFetchFails(t *testing.T) {
+	var fail int32 = 1
+	var hdlrCalled int32
+	hdlr := func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&hdlrCalled, 1)
+
+		if atomic.LoadInt32(&fail) == 1 {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = rw.Write([]byte(jwkeys))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(hdlr))
+	defer srv.Close()
+
+	keySets := jwt.NewRemoteKeySets(jwt.RemoteKeySetOptions{MinRefetchInterval: time.Minute})
+
+	_, err := keySets.DynamicKeySet(context.Background(), srv.URL, false)
+	require.Error(t, err)
+
+	// The failed key set isn't kept, so the next token retries right away.
+	atomic.StoreInt32(&fail, 0)
+
+	ks, err := keySets.DynamicKeySet(context.Background(), srv.URL, false)
+	require.NoError(t, err)
+
+	got, err := keySets.DynamicKeySet(context.Background(), srv.URL, false)
+	require.NoError(t, err)
+	assert.Same(t, ks, got)
+
+	key, err := ks.Key(context.Background(), "foo-key")
+	require.NoError(t, err)
+	assert.NotNil(t, key)
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hdlrCalled))
+}
+
+func TestRemoteKeySets_DynamicKeySetIsBounded(t *testing.T) {
+	hdlr := func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(jwkeys))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(hdlr))
+	defer srv.Close()
+
+	keySets := jwt.NewRemoteKeySets(jwt.RemoteKeySetOptions{MinRefetchInterval: time.Minute})
+
+	for i := 0; i < 100; i++ {
+		_, err := keySets.DynamicKeySet(context.Background(), fmt.Sprintf("%s/%d", srv.URL, i), false)
+		require.NoError(t, err)
+	}
+
+	_, err := keySets.DynamicKeySet(context.Background(), srv.URL+"/100", false)
+	assert.Error(t, err)
+
+	// Existing key sets are still served.
+	_, err = keySets.DynamicKeySet(context.Background(), srv.URL+"/0", false)
+	assert.NoError(t, err)
+}
+
 func TestRemoteKeySet_KeysReturnsNilWhenKeyIsUnknown(t *testing.T) {
 	hdlr := func(rw http.ResponseWriter, req *http.Request) {
 		rw.Header().Add("Cache-Control", "max-age=600")
@@ -158,7 +364,7 @@ func TestRemoteKeySet_KeysReturnsNilWhenKeyIsUnknown(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(hdlr))
 	defer srv.Close()
 
-	ks := jwt.NewRemoteKeySet(srv.URL)
+	ks := jwt.NewRemoteKeySet(srv.URL, jwt.RemoteKeySetOptions{})
 
 	gotKey, err := ks.Key(context.Background(), "meh-key")
 	require.NoError(t, err)
@@ -27,7 +27,6 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
-	"sync"
 
 	"github.com/golang-jwt/jwt"
 	jwtreq "github.com/golang-jwt/jwt/request"
@@ -46,11 +45,13 @@ type Handler struct {
 
 	// Either `keySet` or `dynKeySets` should be set at a time.
 	// If `jwksURL` is a complete URL, `keySet` is used.
-	// If `jwksURL` is a path, `dynKeySets` is used.
-	jwksURL      string
-	keySet       KeySet
-	dynKeySetsMu sync.RWMutex
-	dynKeySets   map[string]*RemoteKeySet
+	// If `jwksURL` is a path, or is empty with OIDC discovery enabled, `dynKeySets` is used.
+	jwksURL       string
+	oidcDiscovery bool
+	keySet        KeySet
+	dynKeySets    *RemoteKeySets
+	// trustedIssuers holds the issuers for which `dynKeySets` can be used. All issuers can be used when empty.
+	trustedIssuers map[string]struct{}
 
 	stripAuthorization bool
 	fwdHeaders         map[string]string
@@ -59,9 +60,10 @@ type Handler struct {
 }
 
 // NewHandler returns a new JWT ACP Handler.
-func NewHandler(cfg *edge.ACPJWTConfig, polName string) (*Handler, error) {
-	if cfg.PublicKey == "" && cfg.SigningSecret == "" && cfg.JWKsFile == "" && cfg.JWKsURL == "" {
-		return nil, errors.New("at least a signing secret, public key or a JWKs file or URL is required")
+// Remote key sets are taken from the given RemoteKeySets, so they can be shared between handlers.
+func NewHandler(cfg *edge.ACPJWTConfig, polName string, keySets *RemoteKeySets) (*Handler, error) {
+	if cfg.PublicKey == "" && cfg.SigningSecret == "" && cfg.JWKsFile == "" && cfg.JWKsURL == "" && !cfg.OIDCDiscovery {
+		return nil, errors.New("at least a signing secret, public key, a JWKs file or URL or OIDC discovery is required")
 	}
 
 	var (
@@ -103,18 +105,25 @@ func NewHandler(cfg *edge.ACPJWTConfig, polName string) (*Handler, error) {
 		tokenQueryKey = cfg.TokenQueryKey
 	}
 
-	ks, err := keySet(cfg)
+	ks, err := keySet(cfg, keySets)
 	if err != nil {
 		return nil, err
 	}
 
+	trustedIssuers := make(map[string]struct{}, len(cfg.TrustedIssuers))
+	for _, iss := range cfg.TrustedIssuers {
+		trustedIssuers[iss] = struct{}{}
+	}
+
 	return &Handler{
 		name:                 polName,
 		signingSecret:        signingSecret,
 		pubKey:               pubKey,
 		jwksURL:              cfg.JWKsURL,
+		oidcDiscovery:        cfg.OIDCDiscovery,
 		keySet:               ks,
-		dynKeySets:           make(map[string]*RemoteKeySet),
+		dynKeySets:           keySets,
+		trustedIssuers:       trustedIssuers,
 		stripAuthorization:   cfg.StripAuthorizationHeader,
 		fwdHeaders:           cfg.ForwardHeaders,
 		tokQryKey:            tokenQueryKey,
@@ -122,7 +131,7 @@ func NewHandler(cfg *edge.ACPJWTConfig, polName string) (*Handler, error) {
 	}, nil
 }
 
-func keySet(src *edge.ACPJWTConfig) (KeySet, error) {
+func keySet(src *edge.ACPJWTConfig, keySets *RemoteKeySets) (KeySet, error) {
 	if src.JWKsFile != "" {
 		if src.JWKsFile.IsPath() {
 			return NewFileKeySet(src.JWKsFile.String()), nil
@@ -136,7 +145,10 @@ func keySet(src *edge.ACPJWTConfig) (KeySet, error) {
 	}
 
 	if src.JWKsURL != "" && !strings.HasPrefix(src.JWKsURL, "/") {
-		return NewRemoteKeySet(src.JWKsURL), nil
+		ks := keySets.KeySet(src.JWKsURL, src.OIDCDiscovery)
+		keySets.Prefetch(ks)
+
+		return ks, nil
 	}
 
 	return nil, nil
@@ -257,7 +269,15 @@ func (h *Handler) resolveKey(ctx context.Context, tok *jwt.Token, kid string) (k
 			return nil, errors.New("expected `iss` claim to be a string")
 		}
 
-		ks, err = h.remoteKeySet(c["iss"].(string))
+		iss := c["iss"].(string)
+
+		// The token isn't verified yet: when trusted issuers are configured, only they can make the agent fetch keys.
+		// Otherwise, the number of key sets and their fetches are bounded by the RemoteKeySets.
+		if _, ok = h.trustedIssuers[iss]; len(h.trustedIssuers) > 0 && !ok {
+			return nil, fmt.Errorf("issuer %q is not trusted", iss)
+		}
+
+		ks, err = h.remoteKeySet(ctx, iss)
 		if err != nil {
 			return nil, err
 		}
@@ -275,7 +295,7 @@ func (h *Handler) resolveKey(ctx context.Context, tok *jwt.Token, kid string) (k
 }
 
 // remoteKeySet returns the remote key set for the given issuer, or creates a new one if none is found.
-func (h *Handler) remoteKeySet(iss string) (*RemoteKeySet, error) {
+func (h *Handler) remoteKeySet(ctx context.Context, iss string) (*RemoteKeySet, error) {
 	base, err := url.Parse(iss)
 	if err != nil {
 		return nil, err
@@ -286,22 +306,5 @@ func (h *Handler) remoteKeySet(iss string) (*RemoteKeySet, error) {
 		return nil, err
 	}
 
-	ksURL := parsed.String()
-
-	h.dynKeySetsMu.RLock()
-	rks, ok := h.dynKeySets[ksURL]
-	h.dynKeySetsMu.RUnlock()
-	if ok {
-		return rks, nil
-	}
-
-	h.dynKeySetsMu.Lock()
-	rks = h.dynKeySets[ksURL]
-	if rks == nil {
-		rks = NewRemoteKeySet(ksURL)
-		h.dynKeySets[ksURL] = rks
-	}
-	h.dynKeySetsMu.Unlock()
-
-	return rks, nil
+	return h.dynKeySets.DynamicKeySet(ctx, parsed.String(), h.oidcDiscovery)
 }
@@ -19,8 +19,10 @@ package jwt
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -87,7 +89,7 @@ func TestNew(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			_, err := NewHandler(&test.jwtCfg, "acp@my-ns")
+			_, err := NewHandler(&test.jwtCfg, "acp@my-ns", NewRemoteKeySets(RemoteKeySetOptions{}))
 
 			test.wantErr(t, err)
 		})
@@ -181,7 +183,7 @@ func TestServeHTTP(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
-			middleware, err := NewHandler(&test.jwtCfg, "acp@my-ns")
+			middleware, err := NewHandler(&test.jwtCfg, "acp@my-ns", NewRemoteKeySets(RemoteKeySetOptions{}))
 			require.NoError(t, err)
 
 			rec := httptest.NewRecorder()
@@ -313,7 +315,9 @@ func TestKeyFunc(t *testing.T) {
 			name: "jwks key found",
 			handler: &Handler{
 				keySet: &RemoteKeySet{
-					expiry: time.Now().Add(60 * time.Second),
+					opts:      RemoteKeySetOptions{MinRefetchInterval: time.Minute},
+					lastFetch: time.Now(),
+					expiry:    time.Now().Add(60 * time.Second),
 					keys: jose.JSONWebKeySet{
 						Keys: []jose.JSONWebKey{
 							{
@@ -332,7 +336,9 @@ func TestKeyFunc(t *testing.T) {
 			name: "jwks key not found",
 			handler: &Handler{
 				keySet: &RemoteKeySet{
-					expiry: time.Now().Add(60 * time.Second),
+					opts:      RemoteKeySetOptions{MinRefetchInterval: time.Minute},
+					lastFetch: time.Now(),
+					expiry:    time.Now().Add(60 * time.Second),
 					keys: jose.JSONWebKeySet{
 						Keys: []jose.JSONWebKey{},
 					},
@@ -363,6 +369,91 @@ func TestKeyFunc(t *testing.T) {
 	}
 }
 
+func TestKeyFunc_dynamicKeySets(t *testing.T) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks, err := json.Marshal(jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{Key: &privKey.PublicKey, KeyID: "foo", Algorithm: "RS256", Use: "sig"}},
+	})
+	require.NoError(t, err)
+
+	var hdlrCalled int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jwks", func(rw http.ResponseWriter, req *http.Request) {
+		hdlrCalled++
+
+		_, _ = rw.Write(jwks)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	keySets := NewRemoteKeySets(RemoteKeySetOptions{MinRefetchInterval: time.Minute})
+	handler, err := NewHandler(&edge.ACPJWTConfig{
+		JWKsURL:        "/jwks",
+		TrustedIssuers: []string{srv.URL},
+	}, "acp@my-ns", keySets)
+	require.NoError(t, err)
+
+	kf := handler.keyFunc(context.Background())
+
+	_, err = kf(&jwt.Token{
+		Method: jwt.SigningMethodRS256,
+		Header: map[string]interface{}{"kid": "foo"},
+		Claims: jwt.MapClaims{"iss": "https://attacker.example.com"},
+	})
+	require.Error(t, err)
+	assert.Equal(t, 0, hdlrCalled)
+	assert.Empty(t, keySets.dynSets)
+
+	key, err := kf(&jwt.Token{
+		Method: jwt.SigningMethodRS256,
+		Header: map[string]interface{}{"kid": "foo"},
+		Claims: jwt.MapClaims{"iss": srv.URL},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &privKey.PublicKey, key)
+	assert.Equal(t, 1, hdlrCalled)
+	assert.Len(t, keySets.dynSets, 1)
+}
+
+func TestServeHTTP_dynamicKeySetsWithoutTrustedIssuers(t *testing.T) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks, err := json.Marshal(jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{{Key: &privKey.PublicKey, KeyID: "foo", Algorithm: "RS256", Use: "sig"}},
+	})
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jwks", func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write(jwks)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// Policies configured before trusted issuers existed accept tokens from any issuer.
+	handler, err := NewHandler(&edge.ACPJWTConfig{JWKsURL: "/jwks"}, "acp@my-ns", NewRemoteKeySets(RemoteKeySetOptions{}))
+	require.NoError(t, err)
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": srv.URL})
+	tok.Header["kid"] = "foo"
+	signed, err := tok.SignedString(privKey)
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+signed)
+
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
 const (
 	invalidPubKey = `-----BEGIN PUBLIC KEY-----
 MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA3VoPN9PKUjKFLMwOge6+
@@ -442,7 +533,7 @@ func Test_JWTAuthNew(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			t.Parallel()
 
-			_, err := NewHandler(&test.static, "acp@my-ns")
+			_, err := NewHandler(&test.static, "acp@my-ns", NewRemoteKeySets(RemoteKeySetOptions{}))
 			test.wantErr(t, err)
 		})
 	}
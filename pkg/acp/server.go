@@ -32,23 +32,28 @@ import (
 	"github.com/traefik/hub-agent-traefik/pkg/edge"
 )
 
+// jwksRefreshInterval is the interval at which remote JWK sets about to expire are refreshed.
+const jwksRefreshInterval = 10 * time.Second
+
 // Server serves ACP endpoints.
 type Server struct {
 	listenAddr string
 	handler    *httpHandler
+	keySets    *jwt.RemoteKeySets
 }
 
 // NewServer creates a new ACP Server.
-func NewServer(listenAddr string) *Server {
+func NewServer(listenAddr string, jwksOpts jwt.RemoteKeySetOptions) *Server {
 	return &Server{
 		listenAddr: listenAddr,
 		handler:    newHTTPHandler(),
+		keySets:    jwt.NewRemoteKeySets(jwksOpts),
 	}
 }
 
 // UpdateHandler updates auth routes served by the Server.
 func (s *Server) UpdateHandler(acps []edge.ACP) error {
-	routes, err := buildRoutes(acps)
+	routes, err := buildRoutes(acps, s.keySets)
 	if err != nil {
 		return fmt.Errorf("build routes: %w", err)
 	}
@@ -77,6 +82,8 @@ func (s *Server) Run(ctx context.Context) error {
 		ErrorLog: stdlog.New(log.Logger.Level(zerolog.DebugLevel), "", 0),
 	}
 
+	go s.keySets.Run(ctx, jwksRefreshInterval)
+
 	srvDone := make(chan struct{})
 
 	go func() {
@@ -106,13 +113,13 @@ func (s *Server) Run(ctx context.Context) error {
 	}
 }
 
-func buildRoutes(acps []edge.ACP) (http.Handler, error) {
+func buildRoutes(acps []edge.ACP, keySets *jwt.RemoteKeySets) (http.Handler, error) {
 	mux := http.NewServeMux()
 
 	for _, acp := range acps {
 		switch {
 		case acp.JWT != nil:
-			jwtHandler, err := jwt.NewHandler(acp.JWT, acp.Name)
+			jwtHandler, err := jwt.NewHandler(acp.JWT, acp.Name, keySets)
 			if err != nil {
 				return nil, fmt.Errorf("create %q JWT ACP handler: %w", acp.Name, err)
 			}
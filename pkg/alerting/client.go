@@ -26,6 +26,8 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
 // APIError represents an error returned by the API.
@@ -50,11 +52,11 @@ type Client struct {
 	baseURL    *url.URL
 	httpClient *http.Client
 
-	token string
+	token secret.Token
 }
 
 // NewClient creates an alerting service client.
-func NewClient(client *http.Client, baseURL, token string) (*Client, error) {
+func NewClient(client *http.Client, baseURL string, token secret.Token) (*Client, error) {
 	base, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid alerting client url: %w", err)
@@ -155,7 +157,7 @@ func (c *Client) SendAlerts(ctx context.Context, data []Alert) error {
 }
 
 func (c Client) do(req *http.Request, result interface{}) error {
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Authorization", "Bearer "+c.token.Value())
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -28,6 +28,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/traefik/hub-agent-traefik/pkg/alerting"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
 func TestClient_GetRules(t *testing.T) {
@@ -59,7 +60,7 @@ func TestClient_GetRules(t *testing.T) {
 	}))
 	t.Cleanup(srv.Close)
 
-	client, err := alerting.NewClient(http.DefaultClient, srv.URL, "some_test_token")
+	client, err := alerting.NewClient(http.DefaultClient, srv.URL, secret.StaticToken("some_test_token"))
 	require.NoError(t, err)
 
 	got, err := client.GetRules(context.Background())
@@ -133,7 +134,7 @@ func TestClient_PreflightAlerts(t *testing.T) {
 	}))
 	t.Cleanup(srv.Close)
 
-	client, err := alerting.NewClient(http.DefaultClient, srv.URL, "some_test_token")
+	client, err := alerting.NewClient(http.DefaultClient, srv.URL, secret.StaticToken("some_test_token"))
 	require.NoError(t, err)
 
 	got, err := client.PreflightAlerts(context.Background(), data)
@@ -164,7 +165,7 @@ func TestClient_PreflightAlertsHandlesBadAlertID(t *testing.T) {
 	}))
 	t.Cleanup(srv.Close)
 
-	client, err := alerting.NewClient(http.DefaultClient, srv.URL, "some_test_token")
+	client, err := alerting.NewClient(http.DefaultClient, srv.URL, secret.StaticToken("some_test_token"))
 	require.NoError(t, err)
 
 	_, err = client.PreflightAlerts(context.Background(), data)
@@ -200,7 +201,7 @@ func TestClient_SendAlerts(t *testing.T) {
 	}))
 	t.Cleanup(srv.Close)
 
-	client, err := alerting.NewClient(http.DefaultClient, srv.URL, "some_test_token")
+	client, err := alerting.NewClient(http.DefaultClient, srv.URL, secret.StaticToken("some_test_token"))
 	require.NoError(t, err)
 
 	err = client.SendAlerts(context.Background(), data)
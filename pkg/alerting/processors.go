@@ -71,7 +71,7 @@ func (p *ThresholdProcessor) Process(rule *Rule) (*Alert, error) {
 	case rule.Service != "":
 		dataPoints = p.dataPoints.FindByService(table, rule.Service, from, to)
 	case rule.EdgeIngress != "":
-		dataPoints = p.dataPoints.FindByEdgeIngress(table, rule.Ingress, from, to)
+		dataPoints = p.dataPoints.FindByEdgeIngress(table, rule.EdgeIngress, from, to)
 	case rule.Ingress != "":
 		dataPoints = p.dataPoints.FindByIngress(table, rule.Ingress, from, to)
 	default:
@@ -80,6 +80,11 @@ func (p *ThresholdProcessor) Process(rule *Rule) (*Alert, error) {
 
 	var points []Point
 	for _, datapoint := range dataPoints {
+		// Gap data points don't account for all the traffic of their period, they could wrongly raise alerts.
+		if datapoint.Gap {
+			continue
+		}
+
 		value, err := getValue(rule.Threshold.Metric, datapoint)
 		if err != nil {
 			return nil, err
@@ -129,6 +134,26 @@ func getValue(metric string, pnt metrics.DataPoint) (float64, error) {
 		return pnt.RequestClientErrPerS, nil
 	case "averageResponseTime":
 		return pnt.AvgResponseTime, nil
+	case "p50ResponseTime":
+		return pnt.ResponseTimeP50, nil
+	case "p95ResponseTime":
+		return pnt.ResponseTimeP95, nil
+	case "p99ResponseTime":
+		return pnt.ResponseTimeP99, nil
+	case "retriesPerSecond":
+		return pnt.RetryPerS, nil
+	case "serversUp":
+		return float64(pnt.ServersUp), nil
+	case "serversDown":
+		return float64(pnt.ServersDown), nil
+	case "requestBytesPerSecond":
+		return pnt.RequestBytesPerS, nil
+	case "responseBytesPerSecond":
+		return pnt.ResponseBytesPerS, nil
+	case "averageOpenConnections":
+		return pnt.AvgOpenConnections, nil
+	case "maxOpenConnections":
+		return float64(pnt.MaxOpenConnections), nil
 	default:
 		return 0, fmt.Errorf("invalid metric type: %s", metric)
 	}
@@ -495,6 +495,62 @@ func TestThresholdProcessor_Process(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "No alert: Rule with service needs 1 occurrence: only a gap data point matches",
+			rule: &Rule{
+				ID:      "rule-1",
+				Service: "service-1@myns",
+				Threshold: &Threshold{
+					Metric:     "requestsPerSecond",
+					Condition:  ThresholdCondition{Above: false, Value: 100},
+					Occurrence: 1,
+					TimeRange:  5 * time.Minute,
+				},
+			},
+			table: "1m",
+			from:  time.Date(2021, 1, 1, 8, 15, 0, 0, time.UTC),
+			to:    time.Date(2021, 1, 1, 8, 20, 0, 0, time.UTC),
+			pointsToReturn: metrics.DataPoints{
+				{Timestamp: now.Add(-4 * time.Minute).Unix(), ReqPerS: 120},
+				{Timestamp: now.Add(-3 * time.Minute).Unix(), Gap: true},
+				{Timestamp: now.Add(-2 * time.Minute).Unix(), ReqPerS: 110},
+			},
+			requireErr: require.NoError,
+		},
+		{
+			desc: "Alert: Rule with edge ingress needs 1 occurrence: rule matches 1 data point",
+			rule: &Rule{
+				ID:          "rule-1",
+				EdgeIngress: "edge-ingress-1",
+				Threshold: &Threshold{
+					Metric:     "requestsPerSecond",
+					Condition:  ThresholdCondition{Above: true, Value: 100},
+					Occurrence: 1,
+					TimeRange:  5 * time.Minute,
+				},
+			},
+			table: "1m",
+			from:  time.Date(2021, 1, 1, 8, 15, 0, 0, time.UTC),
+			to:    time.Date(2021, 1, 1, 8, 20, 0, 0, time.UTC),
+			pointsToReturn: metrics.DataPoints{
+				{Timestamp: now.Add(-4 * time.Minute).Unix(), ReqPerS: 90},
+				{Timestamp: now.Add(-3 * time.Minute).Unix(), ReqPerS: 120},
+			},
+			requireErr: require.NoError,
+			wantAlert: &Alert{
+				RuleID: "rule-1",
+				Points: []Point{
+					{Timestamp: now.Add(-4 * time.Minute).Unix(), Value: 90},
+					{Timestamp: now.Add(-3 * time.Minute).Unix(), Value: 120},
+				},
+				Threshold: &Threshold{
+					Metric:     "requestsPerSecond",
+					Condition:  ThresholdCondition{Above: true, Value: 100},
+					Occurrence: 1,
+					TimeRange:  5 * time.Minute,
+				},
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -510,6 +566,8 @@ func TestThresholdProcessor_Process(t *testing.T) {
 				view.OnFindByIngressAndService(test.table, test.rule.Ingress, test.rule.Service, test.from, test.to).TypedReturns(test.pointsToReturn, nil).Once()
 			case test.rule.Service != "":
 				view.OnFindByService(test.table, test.rule.Service, test.from, test.to).TypedReturns(test.pointsToReturn).Once()
+			case test.rule.EdgeIngress != "":
+				view.OnFindByEdgeIngress(test.table, test.rule.EdgeIngress, test.from, test.to).TypedReturns(test.pointsToReturn).Once()
 			case test.rule.Ingress != "":
 				view.OnFindByIngress(test.table, test.rule.Ingress, test.from, test.to).TypedReturns(test.pointsToReturn).Once()
 			}
@@ -563,6 +621,66 @@ func TestGetValue(t *testing.T) {
 			point:    metrics.DataPoint{AvgResponseTime: 100},
 			expected: expected{value: 100},
 		},
+		{
+			desc:     "with 50th percentile response time metric",
+			metric:   "p50ResponseTime",
+			point:    metrics.DataPoint{ResponseTimeP50: 100},
+			expected: expected{value: 100},
+		},
+		{
+			desc:     "with 95th percentile response time metric",
+			metric:   "p95ResponseTime",
+			point:    metrics.DataPoint{ResponseTimeP95: 100},
+			expected: expected{value: 100},
+		},
+		{
+			desc:     "with 99th percentile response time metric",
+			metric:   "p99ResponseTime",
+			point:    metrics.DataPoint{ResponseTimeP99: 100},
+			expected: expected{value: 100},
+		},
+		{
+			desc:     "with retries per second metric",
+			metric:   "retriesPerSecond",
+			point:    metrics.DataPoint{RetryPerS: 2},
+			expected: expected{value: 2},
+		},
+		{
+			desc:     "with servers up metric",
+			metric:   "serversUp",
+			point:    metrics.DataPoint{ServersUp: 3},
+			expected: expected{value: 3},
+		},
+		{
+			desc:     "with servers down metric",
+			metric:   "serversDown",
+			point:    metrics.DataPoint{ServersDown: 1},
+			expected: expected{value: 1},
+		},
+		{
+			desc:     "with request bytes per second metric",
+			metric:   "requestBytesPerSecond",
+			point:    metrics.DataPoint{RequestBytesPerS: 1024},
+			expected: expected{value: 1024},
+		},
+		{
+			desc:     "with response bytes per second metric",
+			metric:   "responseBytesPerSecond",
+			point:    metrics.DataPoint{ResponseBytesPerS: 2048},
+			expected: expected{value: 2048},
+		},
+		{
+			desc:     "with average open connections metric",
+			metric:   "averageOpenConnections",
+			point:    metrics.DataPoint{AvgOpenConnections: 4.5},
+			expected: expected{value: 4.5},
+		},
+		{
+			desc:     "with max open connections metric",
+			metric:   "maxOpenConnections",
+			point:    metrics.DataPoint{MaxOpenConnections: 12},
+			expected: expected{value: 12},
+		},
 		{
 			desc:   "with unknown metric",
 			metric: "requestsPerPotatoes",
@@ -30,6 +30,7 @@ import (
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/rs/zerolog/log"
 	"github.com/traefik/hub-agent-traefik/pkg/logger"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
 // APIError represents an error returned by the API.
@@ -56,11 +57,11 @@ type Client struct {
 	baseURL    *url.URL
 	httpClient *http.Client
 
-	token string
+	token secret.Token
 }
 
 // NewClient creates a new certificates for the certificates service.
-func NewClient(baseURL, token string) (*Client, error) {
+func NewClient(baseURL string, token secret.Token, transport http.RoundTripper) (*Client, error) {
 	base, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid certificate client url: %w", err)
@@ -68,6 +69,7 @@ func NewClient(baseURL, token string) (*Client, error) {
 
 	rc := retryablehttp.NewClient()
 	rc.RetryMax = 4
+	rc.HTTPClient.Transport = transport
 	rc.Logger = logger.NewRetryableHTTPWrapper(log.Logger.With().Str("component", "certificate-client").Logger())
 	rc.HTTPClient.Timeout = 5 * time.Second
 
@@ -127,7 +129,7 @@ func (c *Client) GetCertificateByDomains(ctx context.Context, domains []string)
 }
 
 func (c Client) do(req *http.Request, result interface{}) error {
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Authorization", "Bearer "+c.token.Value())
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
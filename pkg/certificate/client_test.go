@@ -28,6 +28,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
 func setup(t *testing.T) (*Client, *http.ServeMux) {
@@ -38,7 +39,7 @@ func setup(t *testing.T) (*Client, *http.ServeMux) {
 	srv := httptest.NewServer(mux)
 	t.Cleanup(srv.Close)
 
-	c, err := NewClient(srv.URL, "token")
+	c, err := NewClient(srv.URL, secret.StaticToken("token"), http.DefaultTransport)
 	require.NoError(t, err)
 	c.httpClient = srv.Client()
 
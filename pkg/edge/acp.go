@@ -46,6 +46,14 @@ type ACPJWTConfig struct {
 	ForwardHeaders             map[string]string `json:"forwardHeaders"`
 	TokenQueryKey              string            `json:"tokenQueryKey"`
 	Claims                     string            `json:"claims"`
+
+	// OIDCDiscovery makes JWKsURL the URL of an OpenID provider, whose discovery document gives the JWKs URL.
+	// When JWKsURL is empty, the issuer from the JWT `iss` claim is used.
+	OIDCDiscovery bool `json:"oidcDiscovery"`
+	// TrustedIssuers lists the issuers from which keys can be fetched when the JWKs URL depends on the JWT `iss`
+	// claim, that is when JWKsURL is a path, or is empty with OIDC discovery. When set, tokens from other issuers are
+	// rejected. All issuers are accepted when empty.
+	TrustedIssuers []string `json:"trustedIssuers"`
 }
 
 // ACPBasicAuthConfig configures a basic auth ACP handler.
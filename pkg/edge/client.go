@@ -29,6 +29,7 @@ import (
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/rs/zerolog/log"
 	"github.com/traefik/hub-agent-traefik/pkg/logger"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
 // APIError represents an error returned by the API.
@@ -44,12 +45,12 @@ func (a APIError) Error() string {
 // Client allows interacting with the cluster service.
 type Client struct {
 	baseURL    *url.URL
-	token      string
+	token      secret.Token
 	httpClient *http.Client
 }
 
 // NewClient creates a new client for the cluster service.
-func NewClient(baseURL, token string) (*Client, error) {
+func NewClient(baseURL string, token secret.Token, transport http.RoundTripper) (*Client, error) {
 	u, err := url.ParseRequestURI(baseURL)
 	if err != nil {
 		return nil, err
@@ -57,6 +58,7 @@ func NewClient(baseURL, token string) (*Client, error) {
 
 	rc := retryablehttp.NewClient()
 	rc.RetryMax = 4
+	rc.HTTPClient.Transport = transport
 	rc.Logger = logger.NewRetryableHTTPWrapper(log.Logger.With().Str("component", "edge-client").Logger())
 
 	return &Client{
@@ -109,7 +111,7 @@ func (c *Client) GetACPs(ctx context.Context) ([]ACP, error) {
 }
 
 func (c Client) do(req *http.Request, result interface{}) error {
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Authorization", "Bearer "+c.token.Value())
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -0,0 +1,263 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package edge
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Local definition modes.
+const (
+	// LocalModeMerge merges local definitions with the platform ones, local definitions overriding
+	// platform ones with the same name.
+	LocalModeMerge = "merge"
+	// LocalModeReplace only uses local definitions, the platform is never queried.
+	LocalModeReplace = "replace"
+)
+
+// localDefinitions is the content of a local definition file.
+type localDefinitions struct {
+	Ingresses []Ingress `json:"ingresses"`
+	ACPs      []ACP     `json:"acps"`
+}
+
+// LocalDir loads edge ingresses and ACPs from the YAML files (*.yaml or *.yml) of a directory.
+// Each file holds one or more YAML documents with an `ingresses` and an `acps` list, whose fields
+// are named as in the platform API.
+type LocalDir struct {
+	dir string
+
+	signature string
+}
+
+// NewLocalDir returns a new LocalDir loading definitions from the given directory.
+func NewLocalDir(dir string) *LocalDir {
+	return &LocalDir{dir: dir}
+}
+
+// Load loads and validates the definitions of the directory.
+func (l *LocalDir) Load() ([]Ingress, []ACP, error) {
+	files, err := l.files()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ingresses := make([]Ingress, 0)
+	acps := make([]ACP, 0)
+	ingressFiles := make(map[string]string)
+	acpFiles := make(map[string]string)
+
+	for _, file := range files {
+		defs, err := readLocalDefinitions(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("read %q: %w", file, err)
+		}
+
+		for _, ingress := range defs.Ingresses {
+			if err = validateLocalIngress(ingress); err != nil {
+				return nil, nil, fmt.Errorf("invalid edge ingress %q in %q: %w", ingress.Name, file, err)
+			}
+			if other, ok := ingressFiles[ingress.Name]; ok {
+				return nil, nil, fmt.Errorf("edge ingress %q defined in both %q and %q", ingress.Name, other, file)
+			}
+			ingressFiles[ingress.Name] = file
+
+			if ingress.ID == "" {
+				ingress.ID = ingress.Name
+			}
+			ingresses = append(ingresses, ingress)
+		}
+
+		for _, acp := range defs.ACPs {
+			if err = validateLocalACP(acp); err != nil {
+				return nil, nil, fmt.Errorf("invalid ACP %q in %q: %w", acp.Name, file, err)
+			}
+			if other, ok := acpFiles[acp.Name]; ok {
+				return nil, nil, fmt.Errorf("ACP %q defined in both %q and %q", acp.Name, other, file)
+			}
+			acpFiles[acp.Name] = file
+
+			if acp.ID == "" {
+				acp.ID = acp.Name
+			}
+			acps = append(acps, acp)
+		}
+	}
+
+	return ingresses, acps, nil
+}
+
+// Changed tells whether a definition file has been added, removed or modified since the last call.
+func (l *LocalDir) Changed() (bool, error) {
+	files, err := l.files()
+	if err != nil {
+		return false, err
+	}
+
+	var signature strings.Builder
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, fmt.Errorf("stat %q: %w", file, err)
+		}
+
+		_, _ = fmt.Fprintf(&signature, "%s:%d:%d;", file, info.Size(), info.ModTime().UnixNano())
+	}
+
+	changed := signature.String() != l.signature
+	l.signature = signature.String()
+
+	return changed, nil
+}
+
+func (l *LocalDir) files() ([]string, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read local definitions directory: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		switch filepath.Ext(entry.Name()) {
+		case ".yaml", ".yml":
+			files = append(files, filepath.Join(l.dir, entry.Name()))
+		}
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// readLocalDefinitions reads the YAML documents of the given file. Documents are converted to JSON
+// before being decoded, so definitions share the field names of the platform API.
+func readLocalDefinitions(path string) (localDefinitions, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return localDefinitions{}, err
+	}
+	defer func() { _ = file.Close() }()
+
+	var defs localDefinitions
+
+	dec := yaml.NewDecoder(file)
+	for {
+		var doc interface{}
+		if err = dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				return defs, nil
+			}
+
+			return localDefinitions{}, fmt.Errorf("decode YAML: %w", err)
+		}
+
+		if doc == nil {
+			continue
+		}
+
+		raw, err := json.Marshal(doc)
+		if err != nil {
+			return localDefinitions{}, fmt.Errorf("convert YAML: %w", err)
+		}
+
+		var docDefs localDefinitions
+		if err = json.Unmarshal(raw, &docDefs); err != nil {
+			return localDefinitions{}, fmt.Errorf("decode definitions: %w", err)
+		}
+
+		defs.Ingresses = append(defs.Ingresses, docDefs.Ingresses...)
+		defs.ACPs = append(defs.ACPs, docDefs.ACPs...)
+	}
+}
+
+func validateLocalIngress(ingress Ingress) error {
+	switch {
+	case ingress.Name == "":
+		return errors.New("missing name")
+	case ingress.Domain == "":
+		return errors.New("missing domain")
+	case ingress.Service.Name == "":
+		return errors.New("missing service name")
+	case ingress.Service.Port <= 0:
+		return errors.New("missing service port")
+	case ingress.ACP != nil && ingress.ACP.Name == "":
+		return errors.New("missing ACP name")
+	}
+
+	return nil
+}
+
+func validateLocalACP(acp ACP) error {
+	switch {
+	case acp.Name == "":
+		return errors.New("missing name")
+	case acp.JWT == nil && acp.BasicAuth == nil:
+		return errors.New("missing jwt or basicAuth configuration")
+	case acp.JWT != nil && acp.BasicAuth != nil:
+		return errors.New("jwt and basicAuth configurations are mutually exclusive")
+	}
+
+	return nil
+}
+
+// mergeIngresses returns the given ingresses, local ones overriding the platform ones with the same name.
+func mergeIngresses(platform, local []Ingress) []Ingress {
+	overridden := make(map[string]struct{}, len(local))
+	for _, ingress := range local {
+		overridden[ingress.Name] = struct{}{}
+	}
+
+	merged := make([]Ingress, 0, len(platform)+len(local))
+	for _, ingress := range platform {
+		if _, ok := overridden[ingress.Name]; !ok {
+			merged = append(merged, ingress)
+		}
+	}
+
+	return append(merged, local...)
+}
+
+// mergeACPs returns the given ACPs, local ones overriding the platform ones with the same name.
+func mergeACPs(platform, local []ACP) []ACP {
+	overridden := make(map[string]struct{}, len(local))
+	for _, acp := range local {
+		overridden[acp.Name] = struct{}{}
+	}
+
+	merged := make([]ACP, 0, len(platform)+len(local))
+	for _, acp := range platform {
+		if _, ok := overridden[acp.Name]; !ok {
+			merged = append(merged, acp)
+		}
+	}
+
+	return append(merged, local...)
+}
@@ -0,0 +1,267 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package edge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalDir_Load(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "whoami.yaml"), `
+ingresses:
+  - name: whoami
+    domain: whoami.localhost
+    service:
+      name: whoami
+      network: traefik
+      port: 80
+    acp:
+      name: basic
+---
+acps:
+  - name: basic
+    basicAuth:
+      users:
+        - "user:$apr1$9Cv/OMGj$ZomWQzuQbL.3TRCS81A1g/"
+      forwardUsernameHeader: X-User
+`)
+	writeFile(t, filepath.Join(dir, "jwt.yml"), `
+acps:
+  - name: jwt
+    jwt:
+      signingSecret: secret
+      forwardHeaders:
+        X-Sub: sub
+`)
+	writeFile(t, filepath.Join(dir, "ignored.json"), `{"ingresses": [{"name": "ignored"}]}`)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "ignored.yaml"), 0o755))
+
+	ingresses, acps, err := NewLocalDir(dir).Load()
+	require.NoError(t, err)
+
+	assert.Equal(t, []Ingress{
+		{
+			ID:     "whoami",
+			Name:   "whoami",
+			Domain: "whoami.localhost",
+			Service: Service{
+				Name:    "whoami",
+				Network: "traefik",
+				Port:    80,
+			},
+			ACP: &ACPInfo{Name: "basic"},
+		},
+	}, ingresses)
+
+	assert.Equal(t, []ACP{
+		{
+			ID:   "jwt",
+			Name: "jwt",
+			JWT: &ACPJWTConfig{
+				SigningSecret:  "secret",
+				ForwardHeaders: map[string]string{"X-Sub": "sub"},
+			},
+		},
+		{
+			ID:   "basic",
+			Name: "basic",
+			BasicAuth: &ACPBasicAuthConfig{
+				Users:                 []string{"user:$apr1$9Cv/OMGj$ZomWQzuQbL.3TRCS81A1g/"},
+				ForwardUsernameHeader: "X-User",
+			},
+		},
+	}, acps)
+}
+
+func TestLocalDir_Load_invalid(t *testing.T) {
+	tests := []struct {
+		desc    string
+		files   map[string]string
+		wantErr string
+	}{
+		{
+			desc:    "invalid YAML",
+			files:   map[string]string{"a.yaml": "ingresses: ["},
+			wantErr: "decode YAML",
+		},
+		{
+			desc:    "ingress without domain",
+			files:   map[string]string{"a.yaml": "ingresses: [{name: a, service: {name: a, port: 80}}]"},
+			wantErr: `invalid edge ingress "a"`,
+		},
+		{
+			desc:    "ACP without configuration",
+			files:   map[string]string{"a.yaml": "acps: [{name: a}]"},
+			wantErr: `invalid ACP "a"`,
+		},
+		{
+			desc: "duplicated ingress",
+			files: map[string]string{
+				"a.yaml": "ingresses: [{name: a, domain: a.localhost, service: {name: a, port: 80}}]",
+				"b.yaml": "ingresses: [{name: a, domain: b.localhost, service: {name: b, port: 80}}]",
+			},
+			wantErr: `edge ingress "a" defined in both`,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			for name, content := range test.files {
+				writeFile(t, filepath.Join(dir, name), content)
+			}
+
+			_, _, err := NewLocalDir(dir).Load()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.wantErr)
+		})
+	}
+}
+
+func TestLocalDir_Changed(t *testing.T) {
+	dir := t.TempDir()
+	local := NewLocalDir(dir)
+
+	changed, err := local.Changed()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	path := filepath.Join(dir, "a.yaml")
+	writeFile(t, path, "ingresses: []")
+
+	changed, err = local.Changed()
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	changed, err = local.Changed()
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	writeFile(t, path, "acps: []")
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+
+	changed, err = local.Changed()
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	require.NoError(t, os.Remove(path))
+
+	changed, err = local.Changed()
+	require.NoError(t, err)
+	assert.True(t, changed)
+}
+
+func TestWatcher_reload_localDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "local.yaml"), `
+ingresses:
+  - name: b
+    domain: local.localhost
+    service: {name: local, port: 8080}
+acps:
+  - name: local
+    jwt: {signingSecret: secret}
+`)
+
+	tests := []struct {
+		desc          string
+		mode          string
+		wantIngresses []Ingress
+		wantACPs      []string
+		wantCalls     int
+	}{
+		{
+			desc: "merge",
+			mode: LocalModeMerge,
+			wantIngresses: []Ingress{
+				{ID: "a", Name: "a", Domain: "a.hub.localhost"},
+				{ID: "b", Name: "b", Domain: "local.localhost", Service: Service{Name: "local", Port: 8080}},
+			},
+			wantACPs:  []string{"platform", "local"},
+			wantCalls: 2,
+		},
+		{
+			desc: "replace",
+			mode: LocalModeReplace,
+			wantIngresses: []Ingress{
+				{ID: "b", Name: "b", Domain: "local.localhost", Service: Service{Name: "local", Port: 8080}},
+			},
+			wantACPs: []string{"local"},
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			client, mux := setup(t)
+
+			var calls int
+			mux.HandleFunc("/edge-ingresses", func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				_ = json.NewEncoder(rw).Encode([]Ingress{
+					{ID: "b", Name: "b", Domain: "b.hub.localhost"},
+					{ID: "a", Name: "a", Domain: "a.hub.localhost"},
+				})
+			})
+			mux.HandleFunc("/acps", func(rw http.ResponseWriter, req *http.Request) {
+				calls++
+				_ = json.NewEncoder(rw).Encode([]ACP{{ID: "platform", Name: "platform"}})
+			})
+
+			w := NewWatcher(client, time.Minute)
+			w.SetLocalDir(NewLocalDir(dir), test.mode)
+
+			var gotIngresses []Ingress
+			var gotACPs []string
+			w.AddListener(func(_ context.Context, ingresses []Ingress, acps []ACP) error {
+				gotIngresses = ingresses
+				for _, acp := range acps {
+					gotACPs = append(gotACPs, acp.Name)
+				}
+				return nil
+			})
+
+			require.NoError(t, w.reload(context.Background()))
+
+			assert.Equal(t, test.wantCalls, calls)
+			assert.Equal(t, test.wantIngresses, gotIngresses)
+			assert.Equal(t, test.wantACPs, gotACPs)
+		})
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
@@ -32,19 +32,65 @@ import (
 // Listener listens the changes of the edge related elements.
 type Listener func(context.Context, []Ingress, []ACP) error
 
+// Backend is able to fetch edge ingresses and ACPs from the platform.
+type Backend interface {
+	GetEdgeIngresses(ctx context.Context) ([]Ingress, error)
+	GetACPs(ctx context.Context) ([]ACP, error)
+}
+
+// localPollInterval is the interval at which local definition files are checked for changes.
+const localPollInterval = 5 * time.Second
+
 // Watcher watches hub agent configuration.
 type Watcher struct {
-	client   *Client
+	client   Backend
 	interval time.Duration
 
+	local     *LocalDir
+	localMode string
+
+	intervalCh chan time.Duration
+	refreshCh  chan struct{}
+
+	// Last definitions fetched from the platform, used with local definitions when the platform is unreachable.
+	platformIngresses []Ingress
+	platformACPs      []ACP
+
 	listeners []Listener
 }
 
-// NewWatcher return a new Watcher.
-func NewWatcher(c *Client, interval time.Duration) *Watcher {
+// NewWatcher return a new Watcher. The client can be nil when local definitions replace the platform ones.
+func NewWatcher(c Backend, interval time.Duration) *Watcher {
 	return &Watcher{
-		client:   c,
-		interval: interval,
+		client:     c,
+		interval:   interval,
+		intervalCh: make(chan time.Duration, 1),
+		refreshCh:  make(chan struct{}, 1),
+	}
+}
+
+// SetInterval sets the interval at which edge ingresses and ACPs are fetched. A non-positive interval restores the initial one.
+func (w *Watcher) SetInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = w.interval
+	}
+
+	select {
+	case <-w.intervalCh:
+	default:
+	}
+
+	select {
+	case w.intervalCh <- interval:
+	default:
+	}
+}
+
+// Refresh makes the watcher reload edge ingresses and ACPs, and call its listeners, as soon as possible.
+func (w *Watcher) Refresh() {
+	select {
+	case w.refreshCh <- struct{}{}:
+	default:
 	}
 }
 
@@ -53,11 +99,31 @@ func (w *Watcher) AddListener(listener Listener) {
 	w.listeners = append(w.listeners, listener)
 }
 
+// SetLocalDir makes the watcher load local definitions from the given directory, and combine them with the
+// platform ones according to the given mode (LocalModeMerge or LocalModeReplace).
+func (w *Watcher) SetLocalDir(local *LocalDir, mode string) {
+	w.local = local
+	w.localMode = mode
+}
+
 // Run runs ConfigWatcher.
 func (w *Watcher) Run(ctx context.Context) {
 	t := time.NewTicker(w.interval)
 	defer t.Stop()
 
+	var localCh <-chan time.Time
+	if w.local != nil {
+		// Record the initial state of the local definitions, loaded by the first reload.
+		if _, err := w.local.Changed(); err != nil {
+			log.Error().Err(err).Msg("Unable to check local edge definitions")
+		}
+
+		localTicker := time.NewTicker(localPollInterval)
+		defer localTicker.Stop()
+
+		localCh = localTicker.C
+	}
+
 	if err := w.reload(ctx); err != nil {
 		log.Error().Err(err).Msg("Unable to reload hub-agent-traefik configuration")
 	}
@@ -77,27 +143,60 @@ func (w *Watcher) Run(ctx context.Context) {
 			if err := w.reload(ctx); err != nil {
 				log.Error().Err(err).Msg("Unable to reload hub-agent-traefik configuration")
 			}
+		case interval := <-w.intervalCh:
+			t.Reset(interval)
+		case <-w.refreshCh:
+			if err := w.reload(ctx); err != nil {
+				log.Error().Err(err).Msg("Unable to reload hub-agent-traefik configuration")
+			}
+		case <-localCh:
+			changed, err := w.local.Changed()
+			if err != nil {
+				log.Error().Err(err).Msg("Unable to check local edge definitions")
+				continue
+			}
+			if !changed {
+				continue
+			}
+
+			log.Info().Msg("Local edge definitions changed, reloading")
+			if err = w.reload(ctx); err != nil {
+				log.Error().Err(err).Msg("Unable to reload hub-agent-traefik configuration after a local definition change")
+			}
 		}
 	}
 }
 
-func (w Watcher) reload(ctx context.Context) error {
-	ingresses, err := w.client.GetEdgeIngresses(ctx)
-	if err != nil {
-		return fmt.Errorf("get edge ingresses: %w", err)
+func (w *Watcher) reload(ctx context.Context) error {
+	var platformErr error
+	if w.client != nil && w.localMode != LocalModeReplace {
+		platformErr = w.fetchPlatform(ctx)
+		if platformErr != nil && w.local == nil {
+			return platformErr
+		}
+	}
+
+	ingresses, acps := w.platformIngresses, w.platformACPs
+	if w.local != nil {
+		localIngresses, localACPs, err := w.local.Load()
+		if err != nil {
+			return fmt.Errorf("load local edge definitions: %w", err)
+		}
+
+		ingresses = mergeIngresses(ingresses, localIngresses)
+		acps = mergeACPs(acps, localACPs)
+
+		if platformErr != nil {
+			log.Warn().Err(platformErr).Msg("Unable to fetch edge definitions from the platform, applying local ones with the last known platform ones")
+		}
 	}
 
 	sort.Slice(ingresses, func(i, j int) bool {
 		return ingresses[i].Name < ingresses[j].Name
 	})
 
-	acps, err := w.client.GetACPs(ctx)
-	if err != nil {
-		return fmt.Errorf("get acps: %w", err)
-	}
-
 	for _, listener := range w.listeners {
-		err = listener(ctx, ingresses, acps)
+		err := listener(ctx, ingresses, acps)
 		if err != nil {
 			return fmt.Errorf("apply edge ingresses and acps: %w", err)
 		}
@@ -105,3 +204,19 @@ func (w Watcher) reload(ctx context.Context) error {
 
 	return nil
 }
+
+func (w *Watcher) fetchPlatform(ctx context.Context) error {
+	ingresses, err := w.client.GetEdgeIngresses(ctx)
+	if err != nil {
+		return fmt.Errorf("get edge ingresses: %w", err)
+	}
+
+	acps, err := w.client.GetACPs(ctx)
+	if err != nil {
+		return fmt.Errorf("get acps: %w", err)
+	}
+
+	w.platformIngresses, w.platformACPs = ingresses, acps
+
+	return nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package edge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatcher_Run_refreshAndInterval(t *testing.T) {
+	client, mux := setup(t)
+	mux.HandleFunc("/edge-ingresses", func(rw http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(rw).Encode([]Ingress{{ID: "a", Name: "a"}})
+	})
+	mux.HandleFunc("/acps", func(rw http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(rw).Encode([]ACP{})
+	})
+
+	w := NewWatcher(client, time.Hour)
+
+	reloads := make(chan struct{}, 10)
+	w.AddListener(func(_ context.Context, _ []Ingress, _ []ACP) error {
+		reloads <- struct{}{}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go w.Run(ctx)
+
+	waitReload := func() {
+		t.Helper()
+
+		select {
+		case <-reloads:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "Listener not called")
+		}
+	}
+
+	// Initial load.
+	waitReload()
+
+	w.Refresh()
+	waitReload()
+
+	w.SetInterval(10 * time.Millisecond)
+	waitReload()
+	waitReload()
+
+	// Restore the initial interval.
+	w.SetInterval(0)
+	time.Sleep(50 * time.Millisecond)
+	for len(reloads) > 0 {
+		<-reloads
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, reloads)
+}
@@ -16,15 +16,34 @@ type Pinger interface {
 
 // Heartbeater sends pings to the platform.
 type Heartbeater struct {
-	pinger   Pinger
-	interval time.Duration
+	pinger     Pinger
+	interval   time.Duration
+	intervalCh chan time.Duration
 }
 
 // NewHeartbeater creates a new heartbeater using the given Pinger.
 func NewHeartbeater(p Pinger) *Heartbeater {
 	return &Heartbeater{
-		pinger:   p,
-		interval: pingInterval,
+		pinger:     p,
+		interval:   pingInterval,
+		intervalCh: make(chan time.Duration, 1),
+	}
+}
+
+// SetInterval sets the interval at which the platform is pinged. A non-positive interval restores the initial one.
+func (m *Heartbeater) SetInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = m.interval
+	}
+
+	select {
+	case <-m.intervalCh:
+	default:
+	}
+
+	select {
+	case m.intervalCh <- interval:
+	default:
 	}
 }
 
@@ -39,7 +58,8 @@ func (m *Heartbeater) Run(ctx context.Context) {
 			if err := m.pinger.Ping(ctx); err != nil {
 				log.Error().Err(err).Msg("Unable to ping platform")
 			}
-
+		case interval := <-m.intervalCh:
+			t.Reset(interval)
 		case <-ctx.Done():
 			return
 		}
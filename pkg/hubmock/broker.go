@@ -0,0 +1,244 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package hubmock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/yamux"
+	"github.com/rs/zerolog/log"
+)
+
+// Broker is a websocket tunnel broker. It accepts the sessions opened by agents and opens streams on them.
+type Broker struct {
+	tunnels map[string]struct{}
+
+	sessionsMu sync.Mutex
+	sessions   map[string][]*yamux.Session
+	next       int
+}
+
+func newBroker(tunnels []Tunnel) *Broker {
+	b := &Broker{
+		tunnels:  make(map[string]struct{}),
+		sessions: make(map[string][]*yamux.Session),
+	}
+
+	for _, tun := range tunnels {
+		b.tunnels[tun.TunnelID] = struct{}{}
+	}
+
+	return b
+}
+
+// ServeHTTP accepts a tunnel session on /tunnels/{tunnelID}, and keeps it until it's closed.
+func (b *Broker) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	tunnelID := strings.TrimPrefix(req.URL.Path, "/tunnels/")
+	if _, ok := b.tunnels[tunnelID]; !ok {
+		writeError(rw, http.StatusNotFound, "unknown tunnel")
+		return
+	}
+
+	upgrader := websocket.Upgrader{}
+	conn, err := upgrader.Upgrade(rw, req, nil)
+	if err != nil {
+		log.Error().Err(err).Str("tunnel_id", tunnelID).Msg("Unable to upgrade tunnel connection")
+		return
+	}
+
+	cfg := yamux.DefaultConfig()
+	cfg.LogOutput = io.Discard
+
+	session, err := yamux.Server(&websocketConn{Conn: conn}, cfg)
+	if err != nil {
+		log.Error().Err(err).Str("tunnel_id", tunnelID).Msg("Unable to create tunnel session")
+		_ = conn.Close()
+		return
+	}
+
+	b.addSession(tunnelID, session)
+	defer b.removeSession(tunnelID, session)
+
+	log.Info().Str("tunnel_id", tunnelID).Msg("Tunnel session connected")
+
+	select {
+	case <-session.CloseChan():
+	case <-req.Context().Done():
+		_ = session.Close()
+	}
+
+	log.Info().Str("tunnel_id", tunnelID).Msg("Tunnel session disconnected")
+}
+
+// Sessions returns the number of sessions connected for the given tunnel.
+func (b *Broker) Sessions(tunnelID string) int {
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+
+	return len(b.sessions[tunnelID])
+}
+
+// OpenStream opens a stream to the agent through the given tunnel, balancing streams across its sessions.
+func (b *Broker) OpenStream(tunnelID string) (net.Conn, error) {
+	b.sessionsMu.Lock()
+	sessions := b.sessions[tunnelID]
+	if len(sessions) == 0 {
+		b.sessionsMu.Unlock()
+		return nil, fmt.Errorf("no session connected for tunnel %q", tunnelID)
+	}
+
+	session := sessions[b.next%len(sessions)]
+	b.next++
+	b.sessionsMu.Unlock()
+
+	return session.Open()
+}
+
+// forward forwards the connections accepted on the listen address of the given tunnel through it.
+func (b *Broker) forward(ctx context.Context, tun Tunnel) error {
+	listener, err := net.Listen("tcp", tun.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("listen for tunnel %q: %w", tun.TunnelID, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+
+	log.Info().Str("tunnel_id", tun.TunnelID).Str("addr", tun.ListenAddr).Msg("Forwarding connections through tunnel")
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("accept for tunnel %q: %w", tun.TunnelID, err)
+		}
+
+		go func() {
+			defer func() { _ = conn.Close() }()
+
+			stream, err := b.OpenStream(tun.TunnelID)
+			if err != nil {
+				log.Error().Err(err).Str("tunnel_id", tun.TunnelID).Msg("Unable to open stream")
+				return
+			}
+			defer func() { _ = stream.Close() }()
+
+			pipe(conn, stream)
+		}()
+	}
+}
+
+func (b *Broker) addSession(tunnelID string, session *yamux.Session) {
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+
+	b.sessions[tunnelID] = append(b.sessions[tunnelID], session)
+}
+
+func (b *Broker) removeSession(tunnelID string, session *yamux.Session) {
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+
+	sessions := b.sessions[tunnelID]
+	for i, s := range sessions {
+		if s == session {
+			b.sessions[tunnelID] = append(sessions[:i:i], sessions[i+1:]...)
+			break
+		}
+	}
+
+	if len(b.sessions[tunnelID]) == 0 {
+		delete(b.sessions, tunnelID)
+	}
+}
+
+// pipe copies data both ways between the given connections until one of them is done.
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+
+	cp := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+		done <- struct{}{}
+	}
+
+	go cp(a, b)
+	go cp(b, a)
+
+	<-done
+}
+
+// websocketConn exposes a websocket as a net.Conn, as agents do.
+type websocketConn struct {
+	*websocket.Conn
+
+	reader io.Reader
+}
+
+func (c *websocketConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			_, reader, err := c.Conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			c.reader = reader
+		}
+
+		n, err := c.reader.Read(p)
+		if errors.Is(err, io.EOF) {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+
+			return n, nil
+		}
+
+		return n, err
+	}
+}
+
+func (c *websocketConn) Write(p []byte) (int, error) {
+	if err := c.Conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (c *websocketConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	return c.Conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,98 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package hubmock
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/traefik/hub-agent-traefik/pkg/certificate"
+)
+
+// SelfSignedCertificate generates a self-signed certificate for the given domains or IPs, and returns it
+// along with its key, PEM encoded.
+func SelfSignedCertificate(domains []string) (certPEM, keyPEM []byte, err error) {
+	cert, err := selfSignedCertificate(domains)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cert.Certificate, cert.PrivateKey, nil
+}
+
+// selfSignedCertificate generates a self-signed certificate for the given domains or IPs, valid for a year.
+func selfSignedCertificate(domains []string) (certificate.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return certificate.Certificate{}, fmt.Errorf("generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return certificate.Certificate{}, fmt.Errorf("generate serial number: %w", err)
+	}
+
+	notBefore := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	notAfter := notBefore.Add(365 * 24 * time.Hour)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Hub mock"}},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	for _, domain := range domains {
+		if ip := net.ParseIP(domain); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			continue
+		}
+
+		tmpl.DNSNames = append(tmpl.DNSNames, domain)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return certificate.Certificate{}, fmt.Errorf("create certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return certificate.Certificate{}, fmt.Errorf("marshal key: %w", err)
+	}
+
+	return certificate.Certificate{
+		Domains:     domains,
+		NotBefore:   notBefore,
+		NotAfter:    notAfter,
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
@@ -0,0 +1,81 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package hubmock
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/traefik/hub-agent-traefik/pkg/alerting"
+	"github.com/traefik/hub-agent-traefik/pkg/certificate"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+	"github.com/traefik/hub-agent-traefik/pkg/metrics"
+	"github.com/traefik/hub-agent-traefik/pkg/platform"
+)
+
+// Fixture describes the state served by the mock platform.
+type Fixture struct {
+	// Token is the token agents must authenticate with. Any token is accepted when empty.
+	Token     string `json:"token"`
+	ClusterID string `json:"clusterId"`
+
+	// Config is the agent configuration. When no Git proxy host is set for the topology,
+	// the topology repository is served by the mock itself.
+	Config platform.Config `json:"config"`
+
+	EdgeIngresses []edge.Ingress `json:"edgeIngresses"`
+	ACPs          []edge.ACP     `json:"acps"`
+
+	// WildcardCertificate is the certificate of the workspace. A self-signed one is generated when not set.
+	WildcardCertificate *certificate.Certificate `json:"wildcardCertificate"`
+	// Certificates are served to agents asking for a certificate with the same domains.
+	// Self-signed certificates are generated for other domains.
+	Certificates []certificate.Certificate `json:"certificates"`
+
+	Tunnels []Tunnel `json:"tunnels"`
+
+	AlertRules []alerting.Rule `json:"alertRules"`
+	// PreviousData is the metrics data sent to agents on startup, indexed by table.
+	PreviousData map[string][]metrics.DataPointGroup `json:"previousData"`
+}
+
+// Tunnel describes a tunnel to open on agents.
+type Tunnel struct {
+	TunnelID string `json:"tunnelId"`
+	// Transport is the transport agents must use. Only the websocket transport is served by the mock.
+	Transport string `json:"transport,omitempty"`
+	// ListenAddr is the address on which the mock accepts connections to forward to agents through the tunnel.
+	// No connection is forwarded when empty.
+	ListenAddr string `json:"listenAddr,omitempty"`
+}
+
+// LoadFixture loads a fixture from the given JSON file.
+func LoadFixture(path string) (Fixture, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Fixture{}, fmt.Errorf("read fixture: %w", err)
+	}
+
+	var fixture Fixture
+	if err = json.Unmarshal(raw, &fixture); err != nil {
+		return Fixture{}, fmt.Errorf("decode fixture: %w", err)
+	}
+
+	return fixture, nil
+}
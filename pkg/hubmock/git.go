@@ -0,0 +1,106 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package hubmock
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/cgi"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// gitHandler serves Git repositories over the smart HTTP protocol with git http-backend.
+// Repositories are created, bare and empty, on first use.
+type gitHandler struct {
+	root    string
+	gitPath string
+	backend *cgi.Handler
+
+	initMu sync.Mutex
+}
+
+func newGitHandler(root string) (*gitHandler, error) {
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		return nil, fmt.Errorf("find git: %w", err)
+	}
+
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("resolve Git root: %w", err)
+	}
+
+	return &gitHandler{
+		root:    root,
+		gitPath: gitPath,
+		backend: &cgi.Handler{
+			Path: gitPath,
+			Args: []string{"http-backend"},
+			Env: []string{
+				"GIT_PROJECT_ROOT=" + root,
+				"GIT_HTTP_EXPORT_ALL=1",
+			},
+		},
+	}, nil
+}
+
+// ServeHTTP serves requests to /{org}/{repo}.git/.
+func (h *gitHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	idx := strings.Index(req.URL.Path, ".git/")
+	if idx < 0 {
+		writeError(rw, http.StatusNotFound, "not found")
+		return
+	}
+
+	repo := filepath.Clean(filepath.FromSlash(req.URL.Path[:idx+len(".git")]))
+	if strings.Contains(repo, "..") {
+		writeError(rw, http.StatusBadRequest, "invalid repository")
+		return
+	}
+
+	if err := h.initRepository(filepath.Join(h.root, repo)); err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.backend.ServeHTTP(rw, req)
+}
+
+func (h *gitHandler) initRepository(dir string) error {
+	h.initMu.Lock()
+	defer h.initMu.Unlock()
+
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	if out, err := exec.Command(h.gitPath, "init", "--bare", "--quiet", dir).CombinedOutput(); err != nil {
+		return fmt.Errorf("init repository: %w: %s", err, out)
+	}
+
+	// Anonymous pushes are refused by default.
+	if out, err := exec.Command(h.gitPath, "-C", dir, "config", "http.receivepack", "true").CombinedOutput(); err != nil {
+		return fmt.Errorf("enable push: %w: %s", err, out)
+	}
+
+	return nil
+}
@@ -0,0 +1,534 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package hubmock
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hamba/avro"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/alerting"
+	"github.com/traefik/hub-agent-traefik/pkg/certificate"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+	"github.com/traefik/hub-agent-traefik/pkg/metrics"
+	"github.com/traefik/hub-agent-traefik/pkg/metrics/protocol"
+	"github.com/traefik/hub-agent-traefik/pkg/tunnel"
+	"golang.org/x/sync/errgroup"
+)
+
+// Default topology repository served by the mock.
+const (
+	defaultGitOrgName  = "hub"
+	defaultGitRepoName = "topology"
+)
+
+// Server is a stand-in Hub platform. It serves the state described by a fixture and records what agents send.
+type Server struct {
+	fixture       Fixture
+	metricsSchema avro.Schema
+	serveGit      bool
+
+	mux    *http.ServeMux
+	broker *Broker
+
+	certsMu sync.Mutex
+	certs   map[string]certificate.Certificate
+
+	recordsMu sync.Mutex
+	links     int
+	pings     int
+	metrics   []map[string][]metrics.DataPointGroup
+	alerts    []alerting.Alert
+}
+
+// NewServer creates a mock platform serving the given fixture. When gitRoot is not empty, topology repositories
+// are served from this directory with git http-backend, and created on first use.
+func NewServer(fixture Fixture, gitRoot string) (*Server, error) {
+	metricsSchema, err := avro.Parse(protocol.MetricsV3Schema)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metrics schema: %w", err)
+	}
+
+	s := &Server{
+		fixture:       fixture,
+		metricsSchema: metricsSchema,
+		serveGit:      gitRoot != "",
+		broker:        newBroker(fixture.Tunnels),
+		certs:         make(map[string]certificate.Certificate),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/link", s.handleLink)
+	mux.HandleFunc("/config", s.handleConfig)
+	mux.HandleFunc("/ping", s.handlePing)
+	mux.HandleFunc("/edge-ingresses", s.handleEdgeIngresses)
+	mux.HandleFunc("/acps", s.handleACPs)
+	mux.HandleFunc("/wildcard-certificate", s.handleWildcardCertificate)
+	mux.HandleFunc("/certificate", s.handleCertificate)
+	mux.HandleFunc("/tunnel-endpoints", s.handleTunnelEndpoints)
+	mux.Handle("/tunnels/", s.broker)
+	mux.HandleFunc("/data", s.handleData)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/rules", s.handleRules)
+	mux.HandleFunc("/preflight", s.handlePreflight)
+	mux.HandleFunc("/notify", s.handleNotify)
+
+	if s.serveGit {
+		git, err := newGitHandler(gitRoot)
+		if err != nil {
+			return nil, err
+		}
+		mux.Handle("/", git)
+	}
+
+	s.mux = mux
+
+	return s, nil
+}
+
+// ServeHTTP authenticates agents and serves the platform API.
+func (s *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	log.Debug().Str("method", req.Method).Str("path", req.URL.Path).Msg("Hub mock request")
+
+	if !s.authenticated(req) {
+		// Git only sends credentials once challenged.
+		rw.Header().Set("WWW-Authenticate", `Basic realm="Hub mock"`)
+		writeError(rw, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	s.mux.ServeHTTP(rw, req)
+}
+
+// authenticated tells whether the request holds the fixture token, either as a bearer token
+// or, for Git, as the user of a basic authentication.
+func (s *Server) authenticated(req *http.Request) bool {
+	if s.fixture.Token == "" {
+		return true
+	}
+
+	if req.Header.Get("Authorization") == "Bearer "+s.fixture.Token {
+		return true
+	}
+
+	user, _, ok := req.BasicAuth()
+
+	return ok && user == s.fixture.Token
+}
+
+// Run serves the platform API on the given address, over TLS if tlsConfig is not nil, and forwards connections
+// accepted on the listen address of the tunnels, until the given context is canceled.
+func (s *Server) Run(ctx context.Context, listenAddr string, tlsConfig *tls.Config) error {
+	server := &http.Server{
+		Addr:              listenAddr,
+		Handler:           s,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          stdlog.New(log.Logger.Level(zerolog.DebugLevel), "", 0),
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
+
+	group.Go(func() error {
+		log.Info().Str("addr", listenAddr).Bool("tls", tlsConfig != nil).Msg("Starting Hub mock")
+
+		var err error
+		if tlsConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+
+		if !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("listen and serve: %w", err)
+		}
+
+		return nil
+	})
+
+	group.Go(func() error {
+		<-groupCtx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		//nolint:contextcheck // False positive.
+		return server.Shutdown(shutdownCtx)
+	})
+
+	for _, tun := range s.fixture.Tunnels {
+		if tun.ListenAddr == "" {
+			continue
+		}
+
+		tun := tun
+		group.Go(func() error {
+			return s.broker.forward(groupCtx, tun)
+		})
+	}
+
+	return group.Wait()
+}
+
+// Links returns the number of times an agent has been linked.
+func (s *Server) Links() int {
+	s.recordsMu.Lock()
+	defer s.recordsMu.Unlock()
+
+	return s.links
+}
+
+// Pings returns the number of pings received.
+func (s *Server) Pings() int {
+	s.recordsMu.Lock()
+	defer s.recordsMu.Unlock()
+
+	return s.pings
+}
+
+// Metrics returns the metrics received, one entry per batch.
+func (s *Server) Metrics() []map[string][]metrics.DataPointGroup {
+	s.recordsMu.Lock()
+	defer s.recordsMu.Unlock()
+
+	return append([]map[string][]metrics.DataPointGroup(nil), s.metrics...)
+}
+
+// Alerts returns the alerts received.
+func (s *Server) Alerts() []alerting.Alert {
+	s.recordsMu.Lock()
+	defer s.recordsMu.Unlock()
+
+	return append([]alerting.Alert(nil), s.alerts...)
+}
+
+// Broker returns the tunnel broker of the mock.
+func (s *Server) Broker() *Broker {
+	return s.broker
+}
+
+func (s *Server) handleLink(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethod(rw, req, http.MethodPost) {
+		return
+	}
+
+	s.recordsMu.Lock()
+	s.links++
+	s.recordsMu.Unlock()
+
+	writeJSON(rw, map[string]string{"clusterId": s.fixture.ClusterID})
+}
+
+func (s *Server) handleConfig(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethod(rw, req, http.MethodGet) {
+		return
+	}
+
+	cfg := s.fixture.Config
+	if s.serveGit && cfg.Topology.GitProxyHost == "" {
+		cfg.Topology.GitProxyHost = req.Host
+		if cfg.Topology.GitOrgName == "" {
+			cfg.Topology.GitOrgName = defaultGitOrgName
+		}
+		if cfg.Topology.GitRepoName == "" {
+			cfg.Topology.GitRepoName = defaultGitRepoName
+		}
+	}
+
+	writeJSON(rw, cfg)
+}
+
+func (s *Server) handlePing(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethod(rw, req, http.MethodPost) {
+		return
+	}
+
+	s.recordsMu.Lock()
+	s.pings++
+	s.recordsMu.Unlock()
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleEdgeIngresses(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethod(rw, req, http.MethodGet) {
+		return
+	}
+
+	ingresses := s.fixture.EdgeIngresses
+	if ingresses == nil {
+		ingresses = []edge.Ingress{}
+	}
+
+	writeJSON(rw, ingresses)
+}
+
+func (s *Server) handleACPs(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethod(rw, req, http.MethodGet) {
+		return
+	}
+
+	acps := s.fixture.ACPs
+	if acps == nil {
+		acps = []edge.ACP{}
+	}
+
+	writeJSON(rw, acps)
+}
+
+func (s *Server) handleWildcardCertificate(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethod(rw, req, http.MethodGet) {
+		return
+	}
+
+	if s.fixture.WildcardCertificate != nil {
+		writeJSON(rw, s.fixture.WildcardCertificate)
+		return
+	}
+
+	cert, err := s.generatedCertificate([]string{"*.hub.localhost"})
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(rw, cert)
+}
+
+func (s *Server) handleCertificate(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethod(rw, req, http.MethodGet) {
+		return
+	}
+
+	domains := req.URL.Query()["domains"]
+	if len(domains) == 0 {
+		writeError(rw, http.StatusBadRequest, "missing domains")
+		return
+	}
+
+	for _, cert := range s.fixture.Certificates {
+		if sameDomains(cert.Domains, domains) {
+			writeJSON(rw, cert)
+			return
+		}
+	}
+
+	cert, err := s.generatedCertificate(domains)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(rw, cert)
+}
+
+// generatedCertificate returns a self-signed certificate for the given domains, generated once.
+func (s *Server) generatedCertificate(domains []string) (certificate.Certificate, error) {
+	s.certsMu.Lock()
+	defer s.certsMu.Unlock()
+
+	key := strings.Join(sortedCopy(domains), ",")
+	if cert, ok := s.certs[key]; ok {
+		return cert, nil
+	}
+
+	cert, err := selfSignedCertificate(domains)
+	if err != nil {
+		return certificate.Certificate{}, err
+	}
+	s.certs[key] = cert
+
+	return cert, nil
+}
+
+func (s *Server) handleTunnelEndpoints(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethod(rw, req, http.MethodGet) {
+		return
+	}
+
+	scheme := "ws"
+	if req.TLS != nil {
+		scheme = "wss"
+	}
+
+	endpoints := make([]tunnel.Endpoint, 0, len(s.fixture.Tunnels))
+	for _, tun := range s.fixture.Tunnels {
+		endpoints = append(endpoints, tunnel.Endpoint{
+			TunnelID:       tun.TunnelID,
+			BrokerEndpoint: scheme + "://" + req.Host + "/tunnels",
+			Transport:      tun.Transport,
+		})
+	}
+
+	writeJSON(rw, endpoints)
+}
+
+func (s *Server) handleData(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethod(rw, req, http.MethodGet) {
+		return
+	}
+
+	data := s.fixture.PreviousData
+	if data == nil {
+		data = map[string][]metrics.DataPointGroup{}
+	}
+
+	raw, err := avro.Marshal(s.metricsSchema, data)
+	if err != nil {
+		writeError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	rw.Header().Set("Content-Type", "avro/binary;v3")
+	_, _ = rw.Write(raw)
+}
+
+func (s *Server) handleMetrics(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethod(rw, req, http.MethodPost) {
+		return
+	}
+
+	raw, err := io.ReadAll(req.Body)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var data map[string][]metrics.DataPointGroup
+	if err = avro.Unmarshal(s.metricsSchema, raw, &data); err != nil {
+		writeError(rw, http.StatusBadRequest, fmt.Sprintf("decode metrics: %v", err))
+		return
+	}
+
+	s.recordsMu.Lock()
+	s.metrics = append(s.metrics, data)
+	s.recordsMu.Unlock()
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) handleRules(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethod(rw, req, http.MethodGet) {
+		return
+	}
+
+	rules := s.fixture.AlertRules
+	if rules == nil {
+		rules = []alerting.Rule{}
+	}
+
+	writeJSON(rw, rules)
+}
+
+// handlePreflight allows every alert to be sent.
+func (s *Server) handlePreflight(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethod(rw, req, http.MethodPost) {
+		return
+	}
+
+	var descriptors []struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&descriptors); err != nil {
+		writeError(rw, http.StatusBadRequest, fmt.Sprintf("decode alert descriptors: %v", err))
+		return
+	}
+
+	ids := make([]int, 0, len(descriptors))
+	for _, descriptor := range descriptors {
+		ids = append(ids, descriptor.ID)
+	}
+
+	writeJSON(rw, ids)
+}
+
+func (s *Server) handleNotify(rw http.ResponseWriter, req *http.Request) {
+	if !allowMethod(rw, req, http.MethodPost) {
+		return
+	}
+
+	var alerts []alerting.Alert
+	if err := json.NewDecoder(req.Body).Decode(&alerts); err != nil {
+		writeError(rw, http.StatusBadRequest, fmt.Sprintf("decode alerts: %v", err))
+		return
+	}
+
+	s.recordsMu.Lock()
+	s.alerts = append(s.alerts, alerts...)
+	s.recordsMu.Unlock()
+
+	rw.WriteHeader(http.StatusOK)
+}
+
+func allowMethod(rw http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method != method {
+		writeError(rw, http.StatusMethodNotAllowed, "method not allowed")
+		return false
+	}
+
+	return true
+}
+
+func writeJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		log.Error().Err(err).Msg("Unable to write response")
+	}
+}
+
+func writeError(rw http.ResponseWriter, code int, msg string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+
+	_ = json.NewEncoder(rw).Encode(map[string]string{"error": msg})
+}
+
+func sameDomains(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a, b = sortedCopy(a), sortedCopy(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sortedCopy(s []string) []string {
+	c := append([]string(nil), s...)
+	sort.Strings(c)
+
+	return c
+}
@@ -0,0 +1,322 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package hubmock
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/alerting"
+	"github.com/traefik/hub-agent-traefik/pkg/certificate"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+	"github.com/traefik/hub-agent-traefik/pkg/metrics"
+	"github.com/traefik/hub-agent-traefik/pkg/platform"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
+	"github.com/traefik/hub-agent-traefik/pkg/tunnel"
+)
+
+func TestServer_platform(t *testing.T) {
+	srv := startServer(t, "")
+
+	client, err := platform.NewClient(srv.URL, secret.StaticToken("secret"), http.DefaultTransport)
+	require.NoError(t, err)
+
+	clusterID, err := client.Link(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "cluster-1", clusterID)
+
+	cfg, err := client.GetConfig(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, cfg.Metrics.Interval)
+	assert.Equal(t, 10, cfg.AccessControl.MaxSecuredRoutes)
+	assert.Empty(t, cfg.Topology.GitProxyHost)
+
+	require.NoError(t, client.Ping(context.Background()))
+	require.NoError(t, client.Ping(context.Background()))
+
+	mock := srv.Config.Handler.(*Server)
+	assert.Equal(t, 1, mock.Links())
+	assert.Equal(t, 2, mock.Pings())
+}
+
+func TestServer_invalidToken(t *testing.T) {
+	srv := startServer(t, "")
+
+	client, err := platform.NewClient(srv.URL, secret.StaticToken("invalid"), http.DefaultTransport)
+	require.NoError(t, err)
+
+	_, err = client.Link(context.Background())
+
+	var apiErr platform.APIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusUnauthorized, apiErr.StatusCode)
+}
+
+func TestServer_edge(t *testing.T) {
+	srv := startServer(t, "")
+
+	client, err := edge.NewClient(srv.URL, secret.StaticToken("secret"), http.DefaultTransport)
+	require.NoError(t, err)
+
+	ingresses, err := client.GetEdgeIngresses(context.Background())
+	require.NoError(t, err)
+	require.Len(t, ingresses, 1)
+	assert.Equal(t, "whoami.hub.localhost", ingresses[0].Domain)
+	assert.Equal(t, &edge.ACPInfo{Name: "basic"}, ingresses[0].ACP)
+
+	acps, err := client.GetACPs(context.Background())
+	require.NoError(t, err)
+	require.Len(t, acps, 1)
+	assert.Equal(t, "basic", acps[0].Name)
+	require.NotNil(t, acps[0].BasicAuth)
+}
+
+func TestServer_certificates(t *testing.T) {
+	srv := startServer(t, "")
+
+	client, err := certificate.NewClient(srv.URL, secret.StaticToken("secret"), http.DefaultTransport)
+	require.NoError(t, err)
+
+	cert, err := client.GetCertificateByDomains(context.Background(), []string{"custom.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("cert"), cert.Certificate)
+
+	cert, err = client.GetCertificateByDomains(context.Background(), []string{"other.example.com", "www.other.example.com"})
+	require.NoError(t, err)
+	assertCertificate(t, cert, "www.other.example.com")
+
+	// Generated certificates are stable.
+	again, err := client.GetCertificateByDomains(context.Background(), []string{"www.other.example.com", "other.example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, cert.Certificate, again.Certificate)
+
+	wildcard, err := client.GetWildcardCertificate(context.Background())
+	require.NoError(t, err)
+	assertCertificate(t, wildcard, "whoami.hub.localhost")
+}
+
+func TestServer_alerting(t *testing.T) {
+	srv := startServer(t, "")
+
+	client, err := alerting.NewClient(http.DefaultClient, srv.URL, secret.StaticToken("secret"))
+	require.NoError(t, err)
+
+	rules, err := client.GetRules(context.Background())
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, 10*time.Minute, rules[0].Threshold.TimeRange)
+
+	alerts := []alerting.Alert{
+		{RuleID: "rule-1", Ingress: "whoami@docker", Points: []alerting.Point{{Timestamp: 1, Value: 150}}},
+	}
+
+	allowed, err := client.PreflightAlerts(context.Background(), alerts)
+	require.NoError(t, err)
+	assert.Equal(t, alerts, allowed)
+
+	require.NoError(t, client.SendAlerts(context.Background(), allowed))
+
+	mock := srv.Config.Handler.(*Server)
+	assert.Equal(t, alerts, mock.Alerts())
+}
+
+func TestServer_metrics(t *testing.T) {
+	srv := startServer(t, "")
+
+	client, err := metrics.NewClient(http.DefaultClient, srv.URL, secret.StaticToken("secret"))
+	require.NoError(t, err)
+
+	data, err := client.GetPreviousData(context.Background(), true)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+
+	sent := map[string][]metrics.DataPointGroup{
+		"1m": {
+			{
+				Ingress: "whoami@docker",
+				DataPoints: []metrics.DataPoint{
+					{Timestamp: 60, ReqPerS: 2, Requests: 120},
+				},
+			},
+		},
+	}
+	require.NoError(t, client.Send(context.Background(), sent))
+
+	mock := srv.Config.Handler.(*Server)
+	require.Len(t, mock.Metrics(), 1)
+	assert.Equal(t, sent["1m"][0].Ingress, mock.Metrics()[0]["1m"][0].Ingress)
+	assert.Equal(t, sent["1m"][0].DataPoints, mock.Metrics()[0]["1m"][0].DataPoints)
+}
+
+func TestServer_tunnels(t *testing.T) {
+	srv := startServer(t, "")
+	mock := srv.Config.Handler.(*Server)
+
+	// Traefik echoes what it receives.
+	traefikMock, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = traefikMock.Close() })
+
+	go func() {
+		for {
+			conn, aErr := traefikMock.Accept()
+			if aErr != nil {
+				return
+			}
+
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	client, err := tunnel.NewClient(srv.URL, secret.StaticToken("secret"), http.DefaultTransport)
+	require.NoError(t, err)
+
+	endpoints, err := client.ListClusterTunnelEndpoints(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []tunnel.Endpoint{
+		{TunnelID: "tunnel-1", BrokerEndpoint: "ws://" + strings.TrimPrefix(srv.URL, "http://") + "/tunnels"},
+	}, endpoints)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := tunnel.NewManager(client, traefikMock.Addr().String(), secret.StaticToken("secret"), time.Minute, tunnel.DefaultConfig())
+	go manager.Run(ctx)
+
+	require.Eventually(t, func() bool {
+		return mock.Broker().Sessions("tunnel-1") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stream, err := mock.Broker().OpenStream("tunnel-1")
+	require.NoError(t, err)
+	defer func() { _ = stream.Close() }()
+
+	_, err = stream.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	b := make([]byte, 5)
+	_, err = io.ReadFull(stream, b)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	_, err = mock.Broker().OpenStream("unknown")
+	assert.Error(t, err)
+}
+
+func TestServer_git(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	gitRoot := t.TempDir()
+	srv := startServer(t, gitRoot)
+
+	client, err := platform.NewClient(srv.URL, secret.StaticToken("secret"), http.DefaultTransport)
+	require.NoError(t, err)
+
+	cfg, err := client.GetConfig(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, platform.TopologyConfig{
+		GitProxyHost: strings.TrimPrefix(srv.URL, "http://"),
+		GitOrgName:   "hub",
+		GitRepoName:  "topology",
+	}, cfg.Topology)
+
+	workDir := t.TempDir()
+	repoURL := "http://secret:@" + cfg.Topology.GitProxyHost + "/hub/topology.git"
+
+	runGit(t, workDir, "clone", "--quiet", repoURL, "topology")
+
+	repoDir := filepath.Join(workDir, "topology")
+	require.NoError(t, os.WriteFile(filepath.Join(repoDir, "topology.json"), []byte("{}"), 0o600))
+
+	runGit(t, repoDir, "add", "topology.json")
+	runGit(t, repoDir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "topology")
+	runGit(t, repoDir, "push", "--quiet", "--all", "--set-upstream")
+
+	out := runGit(t, filepath.Join(gitRoot, "hub", "topology.git"), "log", "--all", "--format=%s")
+	assert.Equal(t, "topology", strings.TrimSpace(out))
+}
+
+func TestLoadFixture(t *testing.T) {
+	fixture, err := LoadFixture(filepath.Join("testdata", "fixture.json"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "cluster-1", fixture.ClusterID)
+	assert.Equal(t, []Tunnel{{TunnelID: "tunnel-1"}}, fixture.Tunnels)
+
+	_, err = LoadFixture(filepath.Join("testdata", "missing.json"))
+	assert.Error(t, err)
+}
+
+func startServer(t *testing.T, gitRoot string) *httptest.Server {
+	t.Helper()
+
+	fixture, err := LoadFixture(filepath.Join("testdata", "fixture.json"))
+	require.NoError(t, err)
+
+	mock, err := NewServer(fixture, gitRoot)
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(mock)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func assertCertificate(t *testing.T, cert certificate.Certificate, domain string) {
+	t.Helper()
+
+	block, _ := pem.Decode(cert.Certificate)
+	require.NotNil(t, block)
+
+	x509Cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	assert.NoError(t, x509Cert.VerifyHostname(domain))
+	assert.True(t, cert.NotAfter.After(time.Now()))
+}
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	return string(out)
+}
@@ -17,6 +17,11 @@ along with this program. If not, see <https://www.gnu.org/licenses/>.
 
 package metrics
 
+import (
+	"math"
+	"sort"
+)
+
 // DataPoints contains a slice of data points.
 type DataPoints []DataPoint
 
@@ -34,24 +39,55 @@ func (p DataPoints) Get(ts int64) (int, DataPoint) {
 func (p DataPoints) Aggregate() DataPoint {
 	newPnt := DataPoint{}
 
+	// The average number of open connections is weighted by the duration of each point.
+	var openConnsSum float64
+	var gauged bool
+
 	for _, pnt := range p {
 		newPnt.Seconds += pnt.Seconds
 		newPnt.Requests += pnt.Requests
 		newPnt.RequestErrs += pnt.RequestErrs
 		newPnt.RequestClientErrs += pnt.RequestClientErrs
+		newPnt.Gap = newPnt.Gap || pnt.Gap
+		newPnt.Retries += pnt.Retries
+		newPnt.RequestBytes += pnt.RequestBytes
+		newPnt.ResponseBytes += pnt.ResponseBytes
+		openConnsSum += pnt.AvgOpenConnections * float64(pnt.Seconds)
+		if pnt.MaxOpenConnections > newPnt.MaxOpenConnections {
+			newPnt.MaxOpenConnections = pnt.MaxOpenConnections
+		}
 		newPnt.ResponseTimeSum += pnt.ResponseTimeSum
 		newPnt.ResponseTimeCount += pnt.ResponseTimeCount
+		newPnt.ResponseTimeBuckets = newPnt.ResponseTimeBuckets.Add(pnt.ResponseTimeBuckets)
+
+		// Server gauges keep the worst state observed over the aggregated period. Gap markers carry no observation.
+		if pnt.isGapMarker() {
+			continue
+		}
+		if !gauged || pnt.ServersUp < newPnt.ServersUp {
+			newPnt.ServersUp = pnt.ServersUp
+		}
+		if pnt.ServersDown > newPnt.ServersDown {
+			newPnt.ServersDown = pnt.ServersDown
+		}
+		gauged = true
 	}
 
 	if newPnt.Seconds > 0 {
 		newPnt.ReqPerS = float64(newPnt.Requests) / float64(newPnt.Seconds)
 		newPnt.RequestErrPerS = float64(newPnt.RequestErrs) / float64(newPnt.Seconds)
 		newPnt.RequestClientErrPerS = float64(newPnt.RequestClientErrs) / float64(newPnt.Seconds)
+		newPnt.RetryPerS = float64(newPnt.Retries) / float64(newPnt.Seconds)
+		newPnt.RequestBytesPerS = float64(newPnt.RequestBytes) / float64(newPnt.Seconds)
+		newPnt.ResponseBytesPerS = float64(newPnt.ResponseBytes) / float64(newPnt.Seconds)
+		newPnt.AvgOpenConnections = openConnsSum / float64(newPnt.Seconds)
 	}
 
 	if newPnt.ResponseTimeCount > 0 {
 		newPnt.AvgResponseTime = newPnt.ResponseTimeSum / float64(newPnt.ResponseTimeCount)
 	}
+	newPnt.setResponseTimeQuantiles()
+
 	if newPnt.Requests > 0 {
 		newPnt.RequestErrPercent = float64(newPnt.RequestErrs) / float64(newPnt.Requests)
 		newPnt.RequestClientErrPercent = float64(newPnt.RequestClientErrs) / float64(newPnt.Requests)
@@ -60,6 +96,12 @@ func (p DataPoints) Aggregate() DataPoint {
 	return newPnt
 }
 
+// isGapMarker reports whether the data point only marks an interval whose traffic is unknown, without any
+// observation.
+func (p DataPoint) isGapMarker() bool {
+	return p.Gap && p.Requests == 0 && p.ServersUp == 0 && p.ServersDown == 0
+}
+
 // DataPointGroup contains a unique group of data points (primary keys).
 type DataPointGroup struct {
 	EdgeIngress string      `avro:"edge_ingress"`
@@ -70,21 +112,150 @@ type DataPointGroup struct {
 
 // DataPoint contains fully aggregated metrics.
 type DataPoint struct {
-	Timestamp int64 `avro:"timestamp"`
+	Timestamp int64 `avro:"timestamp" json:"timestamp"`
+	// Gap reports that some of the traffic of the period covered by the data point is unknown, e.g. because Traefik
+	// restarted. A gap data point without any request is a marker of a period for which nothing is known.
+	Gap bool `avro:"gap" json:"gap"`
+
+	ReqPerS                 float64 `avro:"req_per_s" json:"reqPerS"`
+	RequestErrPerS          float64 `avro:"request_error_per_s" json:"requestErrPerS"`
+	RequestErrPercent       float64 `avro:"request_error_per" json:"requestErrPercent"`
+	RequestClientErrPerS    float64 `avro:"request_client_error_per_s" json:"requestClientErrPerS"`
+	RequestClientErrPercent float64 `avro:"request_client_error_per" json:"requestClientErrPercent"`
+	RetryPerS               float64 `avro:"retry_per_s" json:"retryPerS"`
+	RequestBytesPerS        float64 `avro:"request_bytes_per_s" json:"requestBytesPerS"`
+	ResponseBytesPerS       float64 `avro:"response_bytes_per_s" json:"responseBytesPerS"`
+	AvgOpenConnections      float64 `avro:"avg_open_connections" json:"avgOpenConnections"`
+	AvgResponseTime         float64 `avro:"avg_response_time" json:"avgResponseTime"`
+	ResponseTimeP50         float64 `avro:"response_time_p50" json:"responseTimeP50"`
+	ResponseTimeP95         float64 `avro:"response_time_p95" json:"responseTimeP95"`
+	ResponseTimeP99         float64 `avro:"response_time_p99" json:"responseTimeP99"`
+
+	Seconds           int64   `avro:"seconds" json:"seconds"`
+	Requests          int64   `avro:"requests" json:"requests"`
+	RequestErrs       int64   `avro:"request_errors" json:"requestErrs"`
+	RequestClientErrs int64   `avro:"request_client_errors" json:"requestClientErrs"`
+	Retries           int64   `avro:"retries" json:"retries"`
+	RequestBytes      int64   `avro:"request_bytes" json:"requestBytes"`
+	ResponseBytes     int64   `avro:"response_bytes" json:"responseBytes"`
+	ResponseTimeSum   float64 `avro:"response_time_sum" json:"responseTimeSum"`
+	ResponseTimeCount int64   `avro:"response_time_count" json:"responseTimeCount"`
+	// ServersUp and ServersDown are the number of servers of a service reported up and down by Traefik.
+	ServersUp   int64 `avro:"servers_up" json:"serversUp"`
+	ServersDown int64 `avro:"servers_down" json:"serversDown"`
+	// MaxOpenConnections is the highest number of open connections observed.
+	MaxOpenConnections int64 `avro:"max_open_connections" json:"maxOpenConnections"`
+	// ResponseTimeBuckets holds the response time distribution, from which quantiles are estimated.
+	ResponseTimeBuckets Buckets `avro:"response_time_buckets" json:"-"`
+}
+
+// setResponseTimeQuantiles estimates the response time quantiles from the response time buckets.
+func (p *DataPoint) setResponseTimeQuantiles() {
+	p.ResponseTimeP50 = p.ResponseTimeBuckets.Quantile(0.5, p.ResponseTimeCount)
+	p.ResponseTimeP95 = p.ResponseTimeBuckets.Quantile(0.95, p.ResponseTimeCount)
+	p.ResponseTimeP99 = p.ResponseTimeBuckets.Quantile(0.99, p.ResponseTimeCount)
+}
+
+// Bucket is a cumulative histogram bucket: it counts the observations lower than or equal to its upper bound.
+type Bucket struct {
+	UpperBound float64 `avro:"upper_bound"`
+	Count      int64   `avro:"count"`
+}
+
+// Buckets are cumulative histogram buckets, sorted by upper bound.
+type Buckets []Bucket
+
+// countAt returns the number of observations known to be lower than or equal to the given bound.
+func (b Buckets) countAt(bound float64) int64 {
+	i := sort.Search(len(b), func(i int) bool {
+		return b[i].UpperBound > bound
+	})
+	if i == 0 {
+		return 0
+	}
+
+	return b[i-1].Count
+}
+
+// Add returns the buckets of the union of the observations of b and o. Histograms with different bucket layouts
+// are merged on the union of their upper bounds.
+func (b Buckets) Add(o Buckets) Buckets {
+	if len(o) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return append(Buckets(nil), o...)
+	}
+
+	bounds := make(map[float64]struct{}, len(b)+len(o))
+	for _, bkt := range b {
+		bounds[bkt.UpperBound] = struct{}{}
+	}
+	for _, bkt := range o {
+		bounds[bkt.UpperBound] = struct{}{}
+	}
+
+	sum := make(Buckets, 0, len(bounds))
+	for bound := range bounds {
+		sum = append(sum, Bucket{UpperBound: bound, Count: b.countAt(bound) + o.countAt(bound)})
+	}
+
+	sort.Slice(sum, func(i, j int) bool {
+		return sum[i].UpperBound < sum[j].UpperBound
+	})
+
+	return sum
+}
+
+// Sub returns the buckets of the observations of b which are not in o.
+func (b Buckets) Sub(o Buckets) Buckets {
+	if len(b) == 0 {
+		return nil
+	}
 
-	ReqPerS                 float64 `avro:"req_per_s"`
-	RequestErrPerS          float64 `avro:"request_error_per_s"`
-	RequestErrPercent       float64 `avro:"request_error_per"`
-	RequestClientErrPerS    float64 `avro:"request_client_error_per_s"`
-	RequestClientErrPercent float64 `avro:"request_client_error_per"`
-	AvgResponseTime         float64 `avro:"avg_response_time"`
+	diff := make(Buckets, len(b))
+	for i, bkt := range b {
+		count := bkt.Count - o.countAt(bkt.UpperBound)
+		if count < 0 {
+			count = 0
+		}
 
-	Seconds           int64   `avro:"seconds"`
-	Requests          int64   `avro:"requests"`
-	RequestErrs       int64   `avro:"request_errors"`
-	RequestClientErrs int64   `avro:"request_client_errors"`
-	ResponseTimeSum   float64 `avro:"response_time_sum"`
-	ResponseTimeCount int64   `avro:"response_time_count"`
+		diff[i] = Bucket{UpperBound: bkt.UpperBound, Count: count}
+	}
+
+	return diff
+}
+
+// Quantile estimates the q-quantile (0 <= q <= 1) of the given number of observations, interpolating linearly
+// within the bucket it falls into, the same way Prometheus' histogram_quantile does. It returns 0 when the quantile
+// can't be estimated.
+func (b Buckets) Quantile(q float64, count int64) float64 {
+	if len(b) == 0 || count <= 0 {
+		return 0
+	}
+
+	rank := q * float64(count)
+
+	var lowerBound float64
+	var lowerCount int64
+	for _, bkt := range b {
+		if float64(bkt.Count) >= rank {
+			// Nothing is known about the observations above the highest finite bound.
+			if math.IsInf(bkt.UpperBound, 1) {
+				return lowerBound
+			}
+			if bkt.Count == lowerCount {
+				return bkt.UpperBound
+			}
+
+			return lowerBound + (bkt.UpperBound-lowerBound)*(rank-float64(lowerCount))/float64(bkt.Count-lowerCount)
+		}
+
+		lowerBound, lowerCount = bkt.UpperBound, bkt.Count
+	}
+
+	// The quantile falls in the implicit +Inf bucket.
+	return lowerBound
 }
 
 // SetKey contains the primary key of a metric set.
@@ -99,23 +270,13 @@ type MetricSet struct {
 	Requests            int64
 	RequestErrors       int64
 	RequestClientErrors int64
+	Retries             int64
+	RequestBytes        int64
+	ResponseBytes       int64
 	RequestDuration     ServiceHistogram
-}
-
-// RelativeTo returns a service metric relative to o.
-func (s MetricSet) RelativeTo(o MetricSet) MetricSet {
-	if s.Requests < o.Requests {
-		return s
-	}
-
-	s.Requests -= o.Requests
-	s.RequestErrors -= o.RequestErrors
-	s.RequestClientErrors -= o.RequestClientErrors
-	if !o.RequestDuration.Relative {
-		s.RequestDuration.Sum -= o.RequestDuration.Sum
-		s.RequestDuration.Count -= o.RequestDuration.Count
-	}
-	return s
+	ServersUp           int64
+	ServersDown         int64
+	OpenConnections     int64
 }
 
 // ToDataPoint returns a data point calculated from s.
@@ -129,19 +290,33 @@ func (s MetricSet) ToDataPoint(secs int64) DataPoint {
 		clientErrPercent = float64(s.RequestClientErrors) / float64(s.Requests)
 	}
 
-	return DataPoint{
+	pnt := DataPoint{
 		ReqPerS:                 float64(s.Requests) / float64(secs),
 		RequestErrPerS:          float64(s.RequestErrors) / float64(secs),
 		RequestErrPercent:       errPercent,
 		RequestClientErrPerS:    float64(s.RequestClientErrors) / float64(secs),
 		RequestClientErrPercent: clientErrPercent,
+		RetryPerS:               float64(s.Retries) / float64(secs),
+		RequestBytesPerS:        float64(s.RequestBytes) / float64(secs),
+		ResponseBytesPerS:       float64(s.ResponseBytes) / float64(secs),
+		AvgOpenConnections:      float64(s.OpenConnections),
 		AvgResponseTime:         responseTime,
 		Requests:                s.Requests,
 		RequestErrs:             s.RequestErrors,
 		RequestClientErrs:       s.RequestClientErrors,
+		Retries:                 s.Retries,
+		RequestBytes:            s.RequestBytes,
+		ResponseBytes:           s.ResponseBytes,
 		ResponseTimeSum:         s.RequestDuration.Sum,
 		ResponseTimeCount:       s.RequestDuration.Count,
+		ResponseTimeBuckets:     s.RequestDuration.Buckets,
+		ServersUp:               s.ServersUp,
+		ServersDown:             s.ServersDown,
+		MaxOpenConnections:      s.OpenConnections,
 	}
+	pnt.setResponseTimeQuantiles()
+
+	return pnt
 }
 
 // ServiceHistogram contains histogram metrics.
@@ -149,11 +324,15 @@ type ServiceHistogram struct {
 	Relative bool
 	Sum      float64
 	Count    int64
+	Buckets  Buckets
 }
 
-// Aggregate aggregates metrics into a service metric set.
+// Aggregate aggregates metrics into a service metric set. Metrics scraped from several Traefik instances are summed,
+// except for the server up gauges: all instances report the same servers, which are counted once, as up if any
+// instance sees them up.
 func Aggregate(m []Metric) map[SetKey]MetricSet {
 	svcs := map[SetKey]MetricSet{}
+	servers := map[SetKey]map[string]bool{}
 
 	for _, metric := range m {
 		key := SetKey{EdgeIngress: metric.EdgeIngressName(), Ingress: metric.IngressName(), Service: metric.ServiceName()}
@@ -168,6 +347,34 @@ func Aggregate(m []Metric) map[SetKey]MetricSet {
 				svc.RequestErrors += int64(val.Value)
 			case MetricRequestClientErrors:
 				svc.RequestClientErrors += int64(val.Value)
+			case MetricRetries:
+				svc.Retries += int64(val.Value)
+			case MetricRequestBytes:
+				svc.RequestBytes += int64(val.Value)
+			case MetricResponseBytes:
+				svc.ResponseBytes += int64(val.Value)
+			default:
+				continue
+			}
+
+		case *Gauge:
+			switch val.Name {
+			case MetricServerUp:
+				if val.Series != "" {
+					if servers[key] == nil {
+						servers[key] = map[string]bool{}
+					}
+					servers[key][val.Series] = servers[key][val.Series] || val.Value > 0
+					break
+				}
+
+				if val.Value > 0 {
+					svc.ServersUp++
+				} else {
+					svc.ServersDown++
+				}
+			case MetricOpenConnections:
+				svc.OpenConnections += int64(val.Value)
 			default:
 				continue
 			}
@@ -180,6 +387,7 @@ func Aggregate(m []Metric) map[SetKey]MetricSet {
 			dur := svc.RequestDuration
 			dur.Sum += val.Sum
 			dur.Count += int64(val.Count)
+			dur.Buckets = dur.Buckets.Add(val.Buckets)
 			dur.Relative = val.Relative
 			svc.RequestDuration = dur
 		}
@@ -187,5 +395,17 @@ func Aggregate(m []Metric) map[SetKey]MetricSet {
 		svcs[key] = svc
 	}
 
+	for key, series := range servers {
+		svc := svcs[key]
+		for _, up := range series {
+			if up {
+				svc.ServersUp++
+			} else {
+				svc.ServersDown++
+			}
+		}
+		svcs[key] = svc
+	}
+
 	return svcs
 }
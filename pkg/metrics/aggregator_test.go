@@ -18,6 +18,7 @@ along with this program. If not, see <https://www.gnu.org/licenses/>.
 package metrics_test
 
 import (
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -169,3 +170,189 @@ func TestAggregator_Aggregate(t *testing.T) {
 		},
 	})
 }
+
+func TestAggregator_AggregateServiceGauges(t *testing.T) {
+	ms := []metrics.Metric{
+		&metrics.Counter{Name: metrics.MetricRequests, Service: "whoami@docker", Value: 12},
+		&metrics.Counter{Name: metrics.MetricRetries, Service: "whoami@docker", Value: 3},
+		&metrics.Gauge{Name: metrics.MetricServerUp, Service: "whoami@docker", Value: 1},
+		&metrics.Gauge{Name: metrics.MetricServerUp, Service: "whoami@docker", Value: 1},
+		&metrics.Gauge{Name: metrics.MetricServerUp, Service: "whoami@docker", Value: 0},
+		&metrics.Gauge{Name: metrics.MetricServerUp, Service: "idle@docker", Value: 0},
+	}
+
+	svcs := metrics.Aggregate(ms)
+
+	require.Len(t, svcs, 2)
+	assert.Equal(t, metrics.MetricSet{Requests: 12, Retries: 3, ServersUp: 2, ServersDown: 1}, svcs[metrics.SetKey{Service: "whoami@docker"}])
+	assert.Equal(t, metrics.MetricSet{ServersDown: 1}, svcs[metrics.SetKey{Service: "idle@docker"}])
+}
+
+func TestAggregator_AggregateServerUpFromSeveralInstances(t *testing.T) {
+	// Both instances report the same servers and open connections of their own.
+	ms := []metrics.Metric{
+		&metrics.Gauge{Name: metrics.MetricServerUp, Service: "whoami@docker", Series: `url="http://10.0.0.2"`, Value: 1},
+		&metrics.Gauge{Name: metrics.MetricServerUp, Service: "whoami@docker", Series: `url="http://10.0.0.3"`, Value: 0},
+		&metrics.Gauge{Name: metrics.MetricOpenConnections, Service: "whoami@docker", Series: `protocol="http"`, Value: 2},
+		&metrics.Gauge{Name: metrics.MetricServerUp, Service: "whoami@docker", Series: `url="http://10.0.0.2"`, Value: 0},
+		&metrics.Gauge{Name: metrics.MetricServerUp, Service: "whoami@docker", Series: `url="http://10.0.0.3"`, Value: 0},
+		&metrics.Gauge{Name: metrics.MetricOpenConnections, Service: "whoami@docker", Series: `protocol="http"`, Value: 3},
+	}
+
+	svcs := metrics.Aggregate(ms)
+
+	require.Len(t, svcs, 1)
+	assert.Equal(t, metrics.MetricSet{ServersUp: 1, ServersDown: 1, OpenConnections: 5}, svcs[metrics.SetKey{Service: "whoami@docker"}])
+}
+
+func TestDataPoints_AggregateServiceGauges(t *testing.T) {
+	pnts := metrics.DataPoints{
+		{Seconds: 60, Retries: 6, ServersUp: 3},
+		{Seconds: 60, Retries: 0, ServersUp: 1, ServersDown: 2},
+		{Seconds: 60, Retries: 3, ServersUp: 2, ServersDown: 1},
+	}
+
+	got := pnts.Aggregate()
+
+	assert.Equal(t, int64(9), got.Retries)
+	assert.Equal(t, 0.05, got.RetryPerS)
+	assert.Equal(t, int64(1), got.ServersUp)
+	assert.Equal(t, int64(2), got.ServersDown)
+}
+
+func TestDataPoints_AggregateServiceGaugesSkipsGapMarkers(t *testing.T) {
+	pnts := metrics.DataPoints{
+		{Seconds: 60, Gap: true},
+		{Seconds: 60, Requests: 6, ServersUp: 3},
+		{Seconds: 60, Gap: true},
+		{Seconds: 60, Requests: 3, ServersUp: 2, ServersDown: 1},
+	}
+
+	got := pnts.Aggregate()
+
+	assert.True(t, got.Gap)
+	assert.Equal(t, int64(9), got.Requests)
+	assert.Equal(t, int64(2), got.ServersUp)
+	assert.Equal(t, int64(1), got.ServersDown)
+
+	// Without any observation, the gauges are unknown.
+	got = metrics.DataPoints{{Seconds: 60, Gap: true}}.Aggregate()
+	assert.Equal(t, int64(0), got.ServersUp)
+}
+
+func TestAggregator_AggregateBytesAndOpenConnections(t *testing.T) {
+	ms := []metrics.Metric{
+		&metrics.Counter{Name: metrics.MetricRequestBytes, Ingress: "web", Value: 100},
+		&metrics.Counter{Name: metrics.MetricRequestBytes, Ingress: "web", Value: 200},
+		&metrics.Counter{Name: metrics.MetricResponseBytes, Ingress: "web", Value: 1000},
+		&metrics.Gauge{Name: metrics.MetricOpenConnections, Ingress: "web", Value: 2},
+		&metrics.Gauge{Name: metrics.MetricOpenConnections, Ingress: "web", Value: 3},
+	}
+
+	svcs := metrics.Aggregate(ms)
+
+	set := svcs[metrics.SetKey{Ingress: "web"}]
+	assert.Equal(t, metrics.MetricSet{RequestBytes: 300, ResponseBytes: 1000, OpenConnections: 5}, set)
+
+	pnt := set.ToDataPoint(60)
+	assert.Equal(t, float64(5), pnt.RequestBytesPerS)
+	assert.InDelta(t, 16.6667, pnt.ResponseBytesPerS, 0.0001)
+	assert.Equal(t, float64(5), pnt.AvgOpenConnections)
+	assert.Equal(t, int64(5), pnt.MaxOpenConnections)
+}
+
+func TestDataPoints_AggregateBytesAndOpenConnections(t *testing.T) {
+	pnts := metrics.DataPoints{
+		{Seconds: 60, RequestBytes: 600, ResponseBytes: 6000, AvgOpenConnections: 2, MaxOpenConnections: 2},
+		{Seconds: 60, RequestBytes: 0, ResponseBytes: 0, AvgOpenConnections: 10, MaxOpenConnections: 10},
+		{Seconds: 120, RequestBytes: 1200, ResponseBytes: 12000, AvgOpenConnections: 4, MaxOpenConnections: 7},
+	}
+
+	got := pnts.Aggregate()
+
+	assert.Equal(t, int64(1800), got.RequestBytes)
+	assert.Equal(t, int64(18000), got.ResponseBytes)
+	assert.Equal(t, float64(7.5), got.RequestBytesPerS)
+	assert.Equal(t, float64(75), got.ResponseBytesPerS)
+	assert.Equal(t, float64(5), got.AvgOpenConnections)
+	assert.Equal(t, int64(10), got.MaxOpenConnections)
+}
+
+func TestBuckets_Quantile(t *testing.T) {
+	buckets := metrics.Buckets{
+		{UpperBound: 0.1, Count: 50},
+		{UpperBound: 0.3, Count: 90},
+		{UpperBound: 1.2, Count: 98},
+		{UpperBound: math.Inf(1), Count: 100},
+	}
+
+	assert.InDelta(t, 0.1, buckets.Quantile(0.5, 100), 1e-9)
+	assert.InDelta(t, 0.05, buckets.Quantile(0.25, 100), 1e-9)
+	assert.InDelta(t, 0.8625, buckets.Quantile(0.95, 100), 1e-9)
+	// The 99th percentile falls in the +Inf bucket, the highest finite bound is returned.
+	assert.InDelta(t, 1.2, buckets.Quantile(0.99, 100), 1e-9)
+
+	assert.Zero(t, buckets.Quantile(0.5, 0))
+	assert.Zero(t, metrics.Buckets(nil).Quantile(0.5, 100))
+}
+
+func TestBuckets_AddSub(t *testing.T) {
+	a := metrics.Buckets{
+		{UpperBound: 0.1, Count: 1},
+		{UpperBound: 0.3, Count: 3},
+		{UpperBound: math.Inf(1), Count: 4},
+	}
+	b := metrics.Buckets{
+		{UpperBound: 0.1, Count: 2},
+		{UpperBound: 0.5, Count: 5},
+		{UpperBound: math.Inf(1), Count: 6},
+	}
+
+	sum := a.Add(b)
+	assert.Equal(t, metrics.Buckets{
+		{UpperBound: 0.1, Count: 3},
+		{UpperBound: 0.3, Count: 5},
+		{UpperBound: 0.5, Count: 8},
+		{UpperBound: math.Inf(1), Count: 10},
+	}, sum)
+
+	assert.Equal(t, metrics.Buckets{
+		{UpperBound: 0.1, Count: 1},
+		{UpperBound: 0.3, Count: 3},
+		{UpperBound: 0.5, Count: 3},
+		{UpperBound: math.Inf(1), Count: 4},
+	}, sum.Sub(b))
+
+	assert.Equal(t, a, metrics.Buckets(nil).Add(a))
+}
+
+func TestDataPoints_AggregateQuantiles(t *testing.T) {
+	pnts := metrics.DataPoints{
+		{
+			Seconds:           60,
+			ResponseTimeCount: 10,
+			ResponseTimeBuckets: metrics.Buckets{
+				{UpperBound: 0.1, Count: 10},
+				{UpperBound: 0.3, Count: 10},
+			},
+		},
+		{
+			Seconds:           60,
+			ResponseTimeCount: 10,
+			ResponseTimeBuckets: metrics.Buckets{
+				{UpperBound: 0.1, Count: 0},
+				{UpperBound: 0.3, Count: 10},
+			},
+		},
+	}
+
+	got := pnts.Aggregate()
+
+	assert.Equal(t, metrics.Buckets{
+		{UpperBound: 0.1, Count: 10},
+		{UpperBound: 0.3, Count: 20},
+	}, got.ResponseTimeBuckets)
+	assert.InDelta(t, 0.1, got.ResponseTimeP50, 1e-9)
+	assert.InDelta(t, 0.28, got.ResponseTimeP95, 1e-9)
+	assert.InDelta(t, 0.296, got.ResponseTimeP99, 1e-9)
+}
@@ -28,8 +28,19 @@ import (
 
 	"github.com/hamba/avro"
 	"github.com/traefik/hub-agent-traefik/pkg/metrics/protocol"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
+// APIError represents an error returned by the metrics service.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (a APIError) Error() string {
+	return fmt.Sprintf("%d: %s", a.StatusCode, a.Message)
+}
+
 // Client for the token service.
 type Client struct {
 	baseURL    *url.URL
@@ -37,17 +48,17 @@ type Client struct {
 
 	metricsSchema avro.Schema
 
-	token string
+	token secret.Token
 }
 
 // NewClient creates a token service client.
-func NewClient(client *http.Client, baseURL, token string) (*Client, error) {
+func NewClient(client *http.Client, baseURL string, token secret.Token) (*Client, error) {
 	base, err := url.Parse(baseURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid metrics client url: %w", err)
 	}
 
-	metricsSchema, err := avro.Parse(protocol.MetricsV2Schema)
+	metricsSchema, err := avro.Parse(protocol.MetricsV3Schema)
 	if err != nil {
 		return nil, fmt.Errorf("invalid metrics schema: %w", err)
 	}
@@ -101,9 +112,9 @@ func (c *Client) Send(ctx context.Context, data map[string][]DataPointGroup) err
 }
 
 func (c Client) do(req *http.Request, result interface{}) error {
-	req.Header.Set("Authorization", "Bearer "+c.token)
-	req.Header.Set("Accept", "avro/binary;v2")
-	req.Header.Set("Content-Type", "avro/binary;v2")
+	req.Header.Set("Authorization", "Bearer "+c.token.Value())
+	req.Header.Set("Accept", "avro/binary;v3")
+	req.Header.Set("Content-Type", "avro/binary;v3")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -114,7 +125,7 @@ func (c Client) do(req *http.Request, result interface{}) error {
 	if resp.StatusCode/100 != 2 {
 		all, _ := io.ReadAll(resp.Body)
 
-		return fmt.Errorf("%d: %s", resp.StatusCode, string(all))
+		return APIError{StatusCode: resp.StatusCode, Message: string(all)}
 	}
 
 	if result != nil {
@@ -28,16 +28,17 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/traefik/hub-agent-traefik/pkg/metrics"
 	"github.com/traefik/hub-agent-traefik/pkg/metrics/protocol"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
 func TestClient_GetPreviousData(t *testing.T) {
-	schema, err := avro.Parse(protocol.MetricsV2Schema)
+	schema, err := avro.Parse(protocol.MetricsV3Schema)
 	require.NoError(t, err)
 
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "/data", r.URL.Path)
 		assert.Equal(t, "Bearer some_test_token", r.Header.Get("Authorization"))
-		assert.Equal(t, "avro/binary;v2", r.Header.Get("Accept"))
+		assert.Equal(t, "avro/binary;v3", r.Header.Get("Accept"))
 
 		data := map[string][]metrics.DataPointGroup{
 			"1m": {
@@ -59,7 +60,7 @@ func TestClient_GetPreviousData(t *testing.T) {
 		srv.Close()
 	})
 
-	client, err := metrics.NewClient(http.DefaultClient, srv.URL, "some_test_token")
+	client, err := metrics.NewClient(http.DefaultClient, srv.URL, secret.StaticToken("some_test_token"))
 	require.NoError(t, err)
 
 	got, err := client.GetPreviousData(context.Background(), true)
@@ -89,7 +90,7 @@ func TestClient_GetPreviousDataHandlesHTTPError(t *testing.T) {
 		srv.Close()
 	})
 
-	client, err := metrics.NewClient(http.DefaultClient, srv.URL, "some_test_token")
+	client, err := metrics.NewClient(http.DefaultClient, srv.URL, secret.StaticToken("some_test_token"))
 	require.NoError(t, err)
 
 	_, err = client.GetPreviousData(context.Background(), true)
@@ -98,7 +99,7 @@ func TestClient_GetPreviousDataHandlesHTTPError(t *testing.T) {
 }
 
 func TestClient_Send(t *testing.T) {
-	schema, err := avro.Parse(protocol.MetricsV2Schema)
+	schema, err := avro.Parse(protocol.MetricsV3Schema)
 	require.NoError(t, err)
 
 	data := map[string][]metrics.DataPointGroup{
@@ -118,7 +119,7 @@ func TestClient_Send(t *testing.T) {
 	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, "/metrics", r.URL.Path)
 		assert.Equal(t, "Bearer some_test_token", r.Header.Get("Authorization"))
-		assert.Equal(t, "avro/binary;v2", r.Header.Get("Content-Type"))
+		assert.Equal(t, "avro/binary;v3", r.Header.Get("Content-Type"))
 
 		got := map[string][]metrics.DataPointGroup{}
 		err = avro.NewDecoderForSchema(schema, r.Body).Decode(&got)
@@ -131,7 +132,7 @@ func TestClient_Send(t *testing.T) {
 		srv.Close()
 	})
 
-	client, err := metrics.NewClient(http.DefaultClient, srv.URL, "some_test_token")
+	client, err := metrics.NewClient(http.DefaultClient, srv.URL, secret.StaticToken("some_test_token"))
 	require.NoError(t, err)
 
 	err = client.Send(context.Background(), data)
@@ -161,7 +162,7 @@ func TestClient_SendHandlesHTTPError(t *testing.T) {
 		srv.Close()
 	})
 
-	client, err := metrics.NewClient(http.DefaultClient, srv.URL, "some_test_token")
+	client, err := metrics.NewClient(http.DefaultClient, srv.URL, secret.StaticToken("some_test_token"))
 	require.NoError(t, err)
 
 	err = client.Send(context.Background(), data)
@@ -0,0 +1,222 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// exportQueueSize is the number of scrapes an exporter can lag behind before their data points are dropped.
+	exportQueueSize = 10
+	exportTimeout   = 30 * time.Second
+)
+
+// Exporter exports data points to a third party system.
+type Exporter interface {
+	// Export exports the data points of a scrape, one per group.
+	Export(ctx context.Context, grps []DataPointGroup) error
+}
+
+// exportedMetric is a data point value exported as a gauge.
+type exportedMetric struct {
+	Name  string
+	Help  string
+	Unit  string
+	Value func(pnt DataPoint) float64
+}
+
+// exportedMetrics are the data point values exported, in the order they are exported.
+var exportedMetrics = []exportedMetric{
+	{
+		Name:  "traefik_hub_requests_per_second",
+		Help:  "Number of requests per second.",
+		Unit:  "1/s",
+		Value: func(pnt DataPoint) float64 { return pnt.ReqPerS },
+	},
+	{
+		Name:  "traefik_hub_request_errors_per_second",
+		Help:  "Number of requests per second answered with a server error.",
+		Unit:  "1/s",
+		Value: func(pnt DataPoint) float64 { return pnt.RequestErrPerS },
+	},
+	{
+		Name:  "traefik_hub_request_client_errors_per_second",
+		Help:  "Number of requests per second answered with a client error.",
+		Unit:  "1/s",
+		Value: func(pnt DataPoint) float64 { return pnt.RequestClientErrPerS },
+	},
+	{
+		Name:  "traefik_hub_retries_per_second",
+		Help:  "Number of request retries per second.",
+		Unit:  "1/s",
+		Value: func(pnt DataPoint) float64 { return pnt.RetryPerS },
+	},
+	{
+		Name:  "traefik_hub_request_bytes_per_second",
+		Help:  "Number of request bytes received per second.",
+		Unit:  "By/s",
+		Value: func(pnt DataPoint) float64 { return pnt.RequestBytesPerS },
+	},
+	{
+		Name:  "traefik_hub_response_bytes_per_second",
+		Help:  "Number of response bytes sent per second.",
+		Unit:  "By/s",
+		Value: func(pnt DataPoint) float64 { return pnt.ResponseBytesPerS },
+	},
+	{
+		Name:  "traefik_hub_open_connections",
+		Help:  "Average number of open connections.",
+		Unit:  "1",
+		Value: func(pnt DataPoint) float64 { return pnt.AvgOpenConnections },
+	},
+	{
+		Name:  "traefik_hub_response_time_average_seconds",
+		Help:  "Average response time.",
+		Unit:  "s",
+		Value: func(pnt DataPoint) float64 { return pnt.AvgResponseTime },
+	},
+	{
+		Name:  "traefik_hub_response_time_p50_seconds",
+		Help:  "Estimated median response time.",
+		Unit:  "s",
+		Value: func(pnt DataPoint) float64 { return pnt.ResponseTimeP50 },
+	},
+	{
+		Name:  "traefik_hub_response_time_p95_seconds",
+		Help:  "Estimated 95th percentile of the response time.",
+		Unit:  "s",
+		Value: func(pnt DataPoint) float64 { return pnt.ResponseTimeP95 },
+	},
+	{
+		Name:  "traefik_hub_response_time_p99_seconds",
+		Help:  "Estimated 99th percentile of the response time.",
+		Unit:  "s",
+		Value: func(pnt DataPoint) float64 { return pnt.ResponseTimeP99 },
+	},
+	{
+		Name:  "traefik_hub_servers_up",
+		Help:  "Number of servers of the service reported up.",
+		Unit:  "1",
+		Value: func(pnt DataPoint) float64 { return float64(pnt.ServersUp) },
+	},
+	{
+		Name:  "traefik_hub_servers_down",
+		Help:  "Number of servers of the service reported down.",
+		Unit:  "1",
+		Value: func(pnt DataPoint) float64 { return float64(pnt.ServersDown) },
+	},
+}
+
+// exportLabel is a label identifying the row of an exported data point.
+type exportLabel struct {
+	Name  string
+	Value string
+}
+
+// exportLabels returns the labels of a group, sorted by name. Empty labels are omitted.
+func exportLabels(grp DataPointGroup) []exportLabel {
+	var lbls []exportLabel
+	for _, lbl := range []exportLabel{
+		{Name: "edge_ingress", Value: grp.EdgeIngress},
+		{Name: "ingress", Value: grp.Ingress},
+		{Name: "service", Value: grp.Service},
+	} {
+		if lbl.Value != "" {
+			lbls = append(lbls, lbl)
+		}
+	}
+
+	return lbls
+}
+
+// exportGroups returns the groups of data points to export, sorted by key. Gap markers are not exported, as nothing
+// is known about the traffic of their period.
+func exportGroups(pnts map[SetKey]DataPoint) []DataPointGroup {
+	grps := make([]DataPointGroup, 0, len(pnts))
+	for key, pnt := range pnts {
+		if pnt.Gap && pnt.Requests == 0 {
+			continue
+		}
+
+		grps = append(grps, DataPointGroup{
+			EdgeIngress: key.EdgeIngress,
+			Ingress:     key.Ingress,
+			Service:     key.Service,
+			DataPoints:  DataPoints{pnt},
+		})
+	}
+
+	sort.Slice(grps, func(i, j int) bool {
+		if grps[i].EdgeIngress != grps[j].EdgeIngress {
+			return grps[i].EdgeIngress < grps[j].EdgeIngress
+		}
+		if grps[i].Ingress != grps[j].Ingress {
+			return grps[i].Ingress < grps[j].Ingress
+		}
+		return grps[i].Service < grps[j].Service
+	})
+
+	return grps
+}
+
+// exportQueue feeds an exporter from its own goroutine, so that a slow or unreachable exporter delays neither the
+// scrapes nor the other exporters.
+type exportQueue struct {
+	name     string
+	exporter Exporter
+	grps     chan []DataPointGroup
+}
+
+func newExportQueue(name string, exporter Exporter) *exportQueue {
+	return &exportQueue{
+		name:     name,
+		exporter: exporter,
+		grps:     make(chan []DataPointGroup, exportQueueSize),
+	}
+}
+
+// push queues data points for export. They are dropped when the queue is full.
+func (q *exportQueue) push(grps []DataPointGroup) {
+	select {
+	case q.grps <- grps:
+	default:
+		log.Warn().Str("exporter", q.name).Int("groups", len(grps)).Msg("Metrics exporter lagging behind, data points dropped")
+	}
+}
+
+func (q *exportQueue) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case grps := <-q.grps:
+			exportCtx, cancel := context.WithTimeout(ctx, exportTimeout)
+			err := q.exporter.Export(exportCtx, grps)
+			cancel()
+
+			if err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Str("exporter", q.name).Msg("Unable to export metrics")
+			}
+		}
+	}
+}
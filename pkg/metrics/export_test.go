@@ -0,0 +1,53 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testExportGroups() []DataPointGroup {
+	return []DataPointGroup{
+		{
+			EdgeIngress: "whoami",
+			DataPoints:  DataPoints{{Timestamp: 120, Seconds: 60, ReqPerS: 2.5, ResponseTimeP99: 0.3}},
+		},
+		{
+			Ingress:    "web",
+			Service:    "whoami@docker",
+			DataPoints: DataPoints{{Timestamp: 120, Seconds: 60, ReqPerS: 1, ServersUp: 2}},
+		},
+	}
+}
+
+func TestExportGroups(t *testing.T) {
+	got := exportGroups(map[SetKey]DataPoint{
+		{Service: "b"}:     {Timestamp: 60, Requests: 1},
+		{Service: "a"}:     {Timestamp: 60, Requests: 2},
+		{Service: "gap"}:   {Timestamp: 60, Gap: true},
+		{Service: "reset"}: {Timestamp: 60, Gap: true, Requests: 3},
+	})
+
+	assert.Equal(t, []DataPointGroup{
+		{Service: "a", DataPoints: DataPoints{{Timestamp: 60, Requests: 2}}},
+		{Service: "b", DataPoints: DataPoints{{Timestamp: 60, Requests: 1}}},
+		{Service: "reset", DataPoints: DataPoints{{Timestamp: 60, Gap: true, Requests: 3}}},
+	}, got)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+const journalFile = "metrics.journal"
+
+type journalOp int
+
+const (
+	// opSet replaces the points and the water mark of a row, removing the row when it has no points.
+	opSet journalOp = iota
+	// opAppend appends points to a row.
+	opAppend
+	// opMarks sets the water marks of rows of a table.
+	opMarks
+	// opTrim removes the first points of a row, lowering its water mark accordingly, and the row itself once empty.
+	opTrim
+)
+
+// journalRecord is a change of the store. Replaying the records of the journal in order rebuilds the store.
+type journalRecord struct {
+	Op     journalOp
+	Table  string
+	Key    tableKey
+	Points DataPoints
+	Mark   int
+	Marks  WaterMarks
+	Count  int
+}
+
+// journal is an append-only log of the changes of the store, compacted from time to time by rewriting it with only
+// the records needed to rebuild the store.
+type journal struct {
+	path string
+
+	file *os.File
+	enc  *gob.Encoder
+	// records is the number of records written since the last compaction.
+	records int
+}
+
+// readJournal calls fn with each record of the journal at the given path, if any. A partially written record, left
+// by a crash, ends the journal.
+func readJournal(path string, fn func(journalRecord)) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open metrics journal: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	dec := gob.NewDecoder(file)
+	for {
+		var rec journalRecord
+		err = dec.Decode(&rec)
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			log.Warn().Err(err).Str("path", path).Msg("Ignoring the end of the corrupted metrics journal")
+			return nil
+		}
+
+		fn(rec)
+	}
+}
+
+// compact replaces the journal with the given records, atomically.
+func (j *journal) compact(recs []journalRecord) error {
+	if j.file != nil {
+		_ = j.file.Close()
+		j.file, j.enc = nil, nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), journalFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary metrics journal: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	enc := gob.NewEncoder(tmp)
+	for _, rec := range recs {
+		if err = enc.Encode(rec); err != nil {
+			_ = tmp.Close()
+			return fmt.Errorf("write temporary metrics journal: %w", err)
+		}
+	}
+
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("sync temporary metrics journal: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), j.path); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("rename temporary metrics journal: %w", err)
+	}
+
+	// The encoder keeps track of the types it sent, so it must keep writing to the file it started.
+	j.file, j.enc = tmp, enc
+	j.records = 0
+
+	return nil
+}
+
+// write appends the given records to the journal.
+func (j *journal) write(recs ...journalRecord) error {
+	if j.enc == nil {
+		return errors.New("metrics journal not open")
+	}
+
+	for _, rec := range recs {
+		if err := j.enc.Encode(rec); err != nil {
+			return fmt.Errorf("write metrics journal: %w", err)
+		}
+	}
+	j.records += len(recs)
+
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("sync metrics journal: %w", err)
+	}
+
+	return nil
+}
+
+// close closes the journal.
+func (j *journal) close() error {
+	if j.file == nil {
+		return nil
+	}
+
+	err := j.file.Close()
+	j.file, j.enc = nil, nil
+
+	return err
+}
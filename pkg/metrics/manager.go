@@ -19,14 +19,65 @@ package metrics
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"net/http"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"github.com/rs/zerolog/log"
 )
 
-const scrapeInterval = time.Minute
+const (
+	scrapeInterval = time.Minute
+
+	// defaultBatchSize is the default maximum number of data points sent in a single request.
+	defaultBatchSize = 5000
+	// maxSendRetryInterval is the maximum time to wait before retrying a failed send.
+	maxSendRetryInterval = 10 * time.Minute
+)
+
+// Status describes the current state of the metrics manager.
+type Status struct {
+	Scraper ScraperStatus           `json:"scraper"`
+	Sender  SenderStatus            `json:"sender"`
+	Buffer  map[string]BufferStatus `json:"buffer"`
+}
+
+// ScraperStatus describes the health of the metrics scraper.
+type ScraperStatus struct {
+	// Healthy is true when the last scrape succeeded for at least one target.
+	Healthy     bool      `json:"healthy"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastError   string    `json:"lastError,omitempty"`
+	// ConsecutiveFailures is the number of scrapes which failed since the last successful one.
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	// MissedIntervals is the number of scrape intervals recorded as gaps because of failed or skipped scrapes since
+	// the agent started.
+	MissedIntervals int `json:"missedIntervals"`
+	// Targets holds the health of each scraped Traefik instance during the last scrape.
+	Targets []TargetStatus `json:"targets,omitempty"`
+}
+
+// TargetStatus describes the health of a scraped Traefik instance.
+type TargetStatus struct {
+	Name      string `json:"name"`
+	Healthy   bool   `json:"healthy"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// SenderStatus describes the health of the metrics sender.
+type SenderStatus struct {
+	// Healthy is true when the last send succeeded.
+	Healthy     bool      `json:"healthy"`
+	LastSuccess time.Time `json:"lastSuccess"`
+	LastError   string    `json:"lastError,omitempty"`
+	// ConsecutiveFailures is the number of sends which failed since the last successful one.
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+}
 
 // Manager orchestrates metrics scraping and sending.
 type Manager struct {
@@ -37,6 +88,15 @@ type Manager struct {
 	sendMu     sync.Mutex
 	sendIntvl  time.Duration
 	sendTables []string
+	batchSize  int
+
+	statusMu      sync.Mutex
+	scraperStatus ScraperStatus
+	senderStatus  SenderStatus
+
+	exporters []*exportQueue
+
+	nowFunc func() time.Time
 }
 
 // NewManager returns a manager.
@@ -47,9 +107,19 @@ func NewManager(client *Client, store *Store, scraper *Scraper) *Manager {
 		scraper:    scraper,
 		sendIntvl:  time.Minute,
 		sendTables: []string{"1m", "10m", "1h", "1d"},
+		batchSize:  defaultBatchSize,
+		nowFunc:    time.Now,
 	}
 }
 
+// SetBatchSize sets the maximum number of data points sent in a single request, 0 meaning unlimited.
+func (m *Manager) SetBatchSize(size int) {
+	m.sendMu.Lock()
+	defer m.sendMu.Unlock()
+
+	m.batchSize = size
+}
+
 // SetConfig updates the configuration of the metrics manager.
 func (m *Manager) SetConfig(sendInterval time.Duration, sendTables []string) {
 	m.sendMu.Lock()
@@ -59,20 +129,33 @@ func (m *Manager) SetConfig(sendInterval time.Duration, sendTables []string) {
 	m.sendTables = sendTables
 }
 
+// AddExporter adds an exporter to which the data points of each scrape are exported, independently of their sending
+// to the platform. It must be called before running the manager.
+func (m *Manager) AddExporter(name string, exporter Exporter) {
+	m.exporters = append(m.exporters, newExportQueue(name, exporter))
+}
+
 // Run runs the metrics manager. This is a blocking method.
 func (m *Manager) Run(ctx context.Context, hubProviderEntrypoint string) error {
-	prevData, err := m.client.GetPreviousData(ctx, true)
-	if err != nil {
-		return err
-	}
+	// A store restored from disk holds the data points already sent, as well as those which haven't been yet.
+	if !m.store.Restored() {
+		prevData, err := m.client.GetPreviousData(ctx, true)
+		if err != nil {
+			return err
+		}
 
-	for tbl, data := range prevData {
-		if err = m.store.Populate(tbl, data); err != nil {
-			return fmt.Errorf("unable to populate table: %w", err)
+		for tbl, data := range prevData {
+			if err = m.store.Populate(tbl, data); err != nil {
+				return fmt.Errorf("unable to populate table: %w", err)
+			}
 		}
 	}
 
-	go m.startScraper(ctx)
+	for _, exporter := range m.exporters {
+		go exporter.run(ctx)
+	}
+
+	go m.runScraper(ctx)
 	go m.runSender(ctx)
 
 	<-ctx.Done()
@@ -81,16 +164,37 @@ func (m *Manager) Run(ctx context.Context, hubProviderEntrypoint string) error {
 }
 
 func (m *Manager) runSender(ctx context.Context) {
+	// Failed sends are retried with an exponential and randomized backoff, so that agents don't all upload the data
+	// they buffered at the same time once the platform is reachable again.
+	exp := backoff.NewExponentialBackOff()
+	exp.InitialInterval = 10 * time.Second
+	exp.MaxInterval = maxSendRetryInterval
+	exp.MaxElapsedTime = 0
+
+	wait := m.getSendInterval()
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-time.After(wait):
+		}
 
-		case <-time.After(m.getSendInterval()):
-			if err := m.send(ctx, m.getSendTables()); err != nil {
-				log.Error().Err(err).Msg("Unable to send metrics")
+		if err := m.send(ctx, m.getSendTables()); err != nil {
+			if ctx.Err() != nil {
+				return
 			}
+
+			m.setSendFailed(err)
+
+			wait = exp.NextBackOff()
+			log.Error().Err(err).Int("attempt", m.Status().Sender.ConsecutiveFailures).Dur("retry_in", wait).Msg("Unable to send metrics")
+			continue
 		}
+
+		m.setSendSucceeded()
+
+		exp.Reset()
+		wait = m.getSendInterval()
 	}
 }
 
@@ -108,81 +212,481 @@ func (m *Manager) getSendTables() []string {
 	return m.sendTables
 }
 
+func (m *Manager) getBatchSize() int {
+	m.sendMu.Lock()
+	defer m.sendMu.Unlock()
+
+	return m.batchSize
+}
+
+// sendRow is a part of a row of a table to send.
+type sendRow struct {
+	table string
+	key   tableKey
+	pnts  DataPoints
+	// mark is the water mark of the row once its points are sent.
+	mark int
+}
+
+// send sends the data points not sent yet, in batches. The progress is committed after each batch, so that the
+// batches sent before a failure are not sent again.
 func (m *Manager) send(ctx context.Context, tbls []string) error {
 	m.store.RollUp()
+	// The store is cleaned up even when sending fails, so that its tables don't grow past their maximum size.
+	defer m.store.Cleanup()
 
-	toSend := make(map[string][]DataPointGroup)
-	tblMarks := make(map[string]WaterMarks)
+	var rows []sendRow
 	for _, name := range tbls {
 		tbl := name
 
-		tblMarks[tbl] = m.store.ForEachUnmarked(tbl, func(edgeIngr, _, _ string, pnts DataPoints) {
-			toSend[tbl] = append(toSend[tbl], DataPointGroup{
-				EdgeIngress: edgeIngr,
-				DataPoints:  pnts,
+		var tblRows []sendRow
+		marks := m.store.ForEachUnmarked(tbl, func(edgeIngr, ingr, svc string, pnts DataPoints) {
+			tblRows = append(tblRows, sendRow{
+				table: tbl,
+				key:   tableKey{EdgeIngress: edgeIngr, Ingress: ingr, Service: svc},
+				pnts:  pnts,
 			})
 		})
+
+		for _, row := range tblRows {
+			row.mark = marks[row.key]
+			rows = append(rows, row)
+		}
 	}
 
-	if len(toSend) == 0 {
-		return nil
+	for _, batch := range batchRows(rows, m.getBatchSize()) {
+		if err := m.sendBatch(ctx, batch); err != nil {
+			return err
+		}
 	}
 
-	if err := m.client.Send(ctx, toSend); err != nil {
+	return nil
+}
+
+// sendBatch sends a batch of rows and commits their water marks. A batch rejected as too large is split in two.
+func (m *Manager) sendBatch(ctx context.Context, rows []sendRow) error {
+	toSend := make(map[string][]DataPointGroup)
+	var count int
+	for _, row := range rows {
+		toSend[row.table] = append(toSend[row.table], DataPointGroup{
+			EdgeIngress: row.key.EdgeIngress,
+			Ingress:     row.key.Ingress,
+			Service:     row.key.Service,
+			DataPoints:  row.pnts,
+		})
+		count += len(row.pnts)
+	}
+
+	err := m.client.Send(ctx, toSend)
+
+	var apiErr APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusRequestEntityTooLarge && count > 1 {
+		log.Debug().Int("points", count).Msg("Metrics batch too large, splitting it")
+
+		for _, batch := range batchRows(rows, (count+1)/2) {
+			if err = m.sendBatch(ctx, batch); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+	if err != nil {
 		return err
 	}
 
-	for tbl, marks := range tblMarks {
-		m.store.CommitMarks(tbl, marks)
+	marks := make(map[string]WaterMarks)
+	for _, row := range rows {
+		if marks[row.table] == nil {
+			marks[row.table] = make(WaterMarks)
+		}
+		marks[row.table][row.key] = row.mark
+	}
+
+	for tbl, tblMarks := range marks {
+		m.store.CommitMarks(tbl, tblMarks)
 	}
-	m.store.Cleanup()
 
 	return nil
 }
 
-func (m *Manager) startScraper(ctx context.Context) {
-	mtrcs, err := m.scraper.Scrape(ctx)
-	if err != nil {
-		log.Error().Err(err).Msg("Unable to scrape metrics")
-		return
+// batchRows splits the given rows into batches of at most size data points, splitting rows when needed. A size of 0
+// means unlimited.
+func batchRows(rows []sendRow, size int) [][]sendRow {
+	if len(rows) == 0 {
+		return nil
+	}
+	if size <= 0 {
+		return [][]sendRow{rows}
 	}
 
-	ref := Aggregate(mtrcs)
+	var (
+		batches [][]sendRow
+		batch   []sendRow
+		count   int
+	)
+	for _, row := range rows {
+		pnts := row.pnts
+		for len(pnts) > 0 {
+			if count == size {
+				batches = append(batches, batch)
+				batch, count = nil, 0
+			}
 
-	tick := time.NewTicker(scrapeInterval)
-	defer tick.Stop()
+			n := size - count
+			if n > len(pnts) {
+				n = len(pnts)
+			}
 
-	scrapeSec := int64(scrapeInterval.Seconds())
+			batch = append(batch, sendRow{
+				table: row.table,
+				key:   row.key,
+				pnts:  pnts[:n],
+				mark:  row.mark - len(pnts) + n,
+			})
+			count += n
+			pnts = pnts[n:]
+		}
+	}
+
+	return append(batches, batch)
+}
+
+func (m *Manager) runScraper(ctx context.Context) {
+	state := newScrapeState()
+
+	// Failed scrapes are retried sooner than the next scrape, so that a new reference is established as soon as
+	// Traefik is reachable again.
+	exp := backoff.NewExponentialBackOff()
+	exp.InitialInterval = time.Second
+	exp.MaxInterval = scrapeInterval
+	exp.MaxElapsedTime = 0
+
+	var wait time.Duration
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-time.After(wait):
+		}
 
-		case <-tick.C:
-			mtrcs, err = m.scraper.Scrape(ctx)
-			if err != nil {
-				log.Error().Err(err).Msg("Unable to scrape metrics")
+		if err := m.scrape(ctx, state, m.nowFunc()); err != nil {
+			if ctx.Err() != nil {
 				return
 			}
 
-			mtrcSet := Aggregate(mtrcs)
+			wait = exp.NextBackOff()
+			log.Error().Err(err).Int("attempt", m.Status().Scraper.ConsecutiveFailures).Dur("retry_in", wait).Msg("Unable to scrape metrics")
+			continue
+		}
+
+		exp.Reset()
+		wait = nextScrapeIn(state.lastTS, m.nowFunc())
+	}
+}
+
+// nextScrapeIn returns how long to wait before the scrape following the one made at lastTS. Scrapes are scheduled on
+// the interval boundaries rather than an interval after the previous scrape, so that the time spent scraping doesn't
+// make the scraper drift and skip an interval.
+func nextScrapeIn(lastTS int64, now time.Time) time.Duration {
+	wait := time.Unix(lastTS, 0).Add(scrapeInterval).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+
+	return wait
+}
+
+// scrapeState holds what the scraper knows from the previous scrapes.
+type scrapeState struct {
+	// trackers holds the reference scrape of each target.
+	trackers map[string]*seriesTracker
+	// targetSets holds the sets each target returned in its last successful scrape, which are recorded as gaps while
+	// the target can't be scraped.
+	targetSets map[string][]SetKey
+
+	// lastTS is the timestamp of the last successful scrape, from which the missed intervals are recorded once
+	// scraping succeeds again.
+	lastTS int64
+	// lastScrape is the time of the last successful scrape, which is the reference of the targets being counted.
+	lastScrape time.Time
+}
+
+func newScrapeState() *scrapeState {
+	return &scrapeState{
+		trackers:   make(map[string]*seriesTracker),
+		targetSets: make(map[string][]SetKey),
+	}
+}
+
+// started reports whether at least one of the given targets has a reference scrape.
+func (s *scrapeState) started(targets map[string][]Metric) bool {
+	for name := range targets {
+		if tracker, ok := s.trackers[name]; ok && tracker.Started() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lastSets returns the sets all targets returned in their last successful scrape.
+func (s *scrapeState) lastSets() []SetKey {
+	seen := make(map[SetKey]struct{})
+	var sets []SetKey
+	for _, targetSets := range s.targetSets {
+		for _, key := range targetSets {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			sets = append(sets, key)
+		}
+	}
+
+	return sets
+}
+
+// scrape scrapes the metrics of all targets and inserts the data points of the interval containing the given time,
+// summing the traffic of all targets. Each target has its own reference scrape: after a failed scrape, the
+// next successful one of the target only establishes a new reference. The traffic of a target being unknown for an
+// interval, the sets it served are recorded as gaps, while the other targets keep being counted. When no target
+// could be scraped, the scrape fails and the intervals until the next successful one are recorded as gaps.
+func (m *Manager) scrape(ctx context.Context, state *scrapeState, now time.Time) error {
+	ts := now.UTC().Truncate(scrapeInterval).Unix()
+	res, err := m.scraper.Scrape(ctx)
+
+	// Forget the targets which have been removed.
+	for name := range state.trackers {
+		_, scraped := res.Metrics[name]
+		_, failed := res.Errors[name]
+		if !scraped && !failed {
+			delete(state.trackers, name)
+			delete(state.targetSets, name)
+		}
+	}
+
+	// Nothing is known about the traffic of failed targets until their next successful scrape, it can't be compared
+	// to their reference.
+	for name, targetErr := range res.Errors {
+		if tracker, ok := state.trackers[name]; ok {
+			tracker.Reset()
+		}
+
+		if err == nil {
+			log.Warn().Err(targetErr).Str("target", name).Msg("Unable to scrape metrics from Traefik instance")
+		}
+	}
+
+	if err != nil {
+		m.setScrapeFailed(err, res)
+
+		return err
+	}
+
+	scrapeSec := int64(scrapeInterval.Seconds())
+
+	pnts := make(map[SetKey]DataPoint)
+	var missed int
+	started := state.started(res.Metrics)
+	if state.lastTS != 0 {
+		// Without a reference, nothing is known up to this scrape. Otherwise, the traffic of the skipped intervals is
+		// counted in this scrape, which is flagged as a gap below.
+		lastGapTS := ts
+		if started {
+			lastGapTS = ts - scrapeSec
+		}
+
+		lastSets := state.lastSets()
+		for gapTS := state.lastTS + scrapeSec; gapTS <= lastGapTS; gapTS += scrapeSec {
+			for _, key := range lastSets {
+				pnts[key] = DataPoint{Timestamp: gapTS, Seconds: scrapeSec, Gap: true}
+			}
+			if len(pnts) > 0 {
+				m.store.Insert(pnts)
+				pnts = make(map[SetKey]DataPoint)
+			}
+			missed++
+		}
+	}
+
+	var (
+		mtrcs    []Metric
+		reset    = make(map[SetKey]struct{})
+		gaps     = make(map[SetKey]struct{})
+		vanished = make(map[SetKey]struct{})
+	)
+	for name, targetMtrcs := range res.Metrics {
+		tracker, ok := state.trackers[name]
+		if !ok {
+			tracker = newSeriesTracker()
+			state.trackers[name] = tracker
+		}
 
-			ts := time.Now().UTC().Truncate(time.Minute).Unix()
+		targetStarted := tracker.Started()
+		delta := tracker.Delta(targetMtrcs)
 
-			pnts := make(map[SetKey]DataPoint, len(mtrcSet))
-			for key, mtrc := range mtrcSet {
-				mtrc = mtrc.RelativeTo(ref[key])
+		mtrcs = append(mtrcs, delta.Metrics...)
+		for key := range delta.Reset {
+			reset[key] = struct{}{}
+		}
+		for _, key := range delta.Vanished {
+			vanished[key] = struct{}{}
+		}
 
-				pnt := mtrc.ToDataPoint(scrapeSec)
-				pnt.Timestamp = ts
-				pnt.Seconds = scrapeSec
+		state.targetSets[name] = tracker.Sets()
 
-				pnts[key] = pnt
+		// The target only gives its reference while others are counted: its traffic for this interval is unknown.
+		if !targetStarted && started {
+			for _, key := range state.targetSets[name] {
+				gaps[key] = struct{}{}
 			}
+		}
+	}
 
-			m.store.Insert(pnts)
+	if started {
+		for name := range res.Errors {
+			for _, key := range state.targetSets[name] {
+				gaps[key] = struct{}{}
+			}
+		}
+	}
+
+	// The traffic since the last scrape can't be split over the skipped intervals.
+	skipped := started && missed > 0
 
-			ref = mtrcSet
+	// Counted targets have been scraped for the last time with the last successful scrape, which may not have been
+	// made on an interval boundary, e.g. when it was the first one: the traffic is the one since then.
+	elapsedSec := scrapeSec
+	if started && !state.lastScrape.IsZero() {
+		elapsedSec = int64(math.Round(now.Sub(state.lastScrape).Seconds()))
+		if elapsedSec < 1 {
+			elapsedSec = 1
 		}
 	}
+
+	for key, mtrc := range Aggregate(mtrcs) {
+		pnt := mtrc.ToDataPoint(elapsedSec)
+		pnt.Timestamp = ts
+		pnt.Seconds = elapsedSec
+		_, isReset := reset[key]
+		pnt.Gap = isReset || skipped
+
+		pnts[key] = pnt
+	}
+
+	for key := range gaps {
+		pnt, ok := pnts[key]
+		if !ok {
+			pnt = DataPoint{Timestamp: ts, Seconds: scrapeSec}
+		}
+		pnt.Gap = true
+
+		pnts[key] = pnt
+	}
+
+	// Sets which vanished from all targets since the previous scrape get a gap marker, as the traffic they had before
+	// vanishing is unknown.
+	for key := range vanished {
+		if _, ok := pnts[key]; ok {
+			continue
+		}
+
+		pnts[key] = DataPoint{Timestamp: ts, Seconds: scrapeSec, Gap: true}
+	}
+
+	if len(pnts) > 0 {
+		m.store.Insert(pnts)
+		m.export(pnts)
+	}
+
+	state.lastTS = ts
+	state.lastScrape = now
+	m.setScrapeSucceeded(missed, res)
+
+	return nil
+}
+
+func (m *Manager) export(pnts map[SetKey]DataPoint) {
+	if len(m.exporters) == 0 {
+		return
+	}
+
+	grps := exportGroups(pnts)
+	if len(grps) == 0 {
+		return
+	}
+
+	for _, exporter := range m.exporters {
+		exporter.push(grps)
+	}
+}
+
+func (m *Manager) setScrapeFailed(err error, res ScrapeResult) {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+
+	m.scraperStatus.Healthy = false
+	m.scraperStatus.Targets = targetStatuses(res)
+	m.scraperStatus.LastError = err.Error()
+	m.scraperStatus.ConsecutiveFailures++
+}
+
+func (m *Manager) setScrapeSucceeded(missed int, res ScrapeResult) {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+
+	m.scraperStatus.Healthy = true
+	m.scraperStatus.Targets = targetStatuses(res)
+	m.scraperStatus.LastSuccess = m.nowFunc().UTC()
+	m.scraperStatus.LastError = ""
+	m.scraperStatus.ConsecutiveFailures = 0
+	m.scraperStatus.MissedIntervals += missed
+}
+
+func targetStatuses(res ScrapeResult) []TargetStatus {
+	statuses := make([]TargetStatus, 0, len(res.Metrics)+len(res.Errors))
+	for name := range res.Metrics {
+		statuses = append(statuses, TargetStatus{Name: name, Healthy: true})
+	}
+	for name, err := range res.Errors {
+		statuses = append(statuses, TargetStatus{Name: name, LastError: err.Error()})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+func (m *Manager) setSendFailed(err error) {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+
+	m.senderStatus.Healthy = false
+	m.senderStatus.LastError = err.Error()
+	m.senderStatus.ConsecutiveFailures++
+}
+
+func (m *Manager) setSendSucceeded() {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+
+	m.senderStatus.Healthy = true
+	m.senderStatus.LastSuccess = m.nowFunc().UTC()
+	m.senderStatus.LastError = ""
+	m.senderStatus.ConsecutiveFailures = 0
+}
+
+// Status returns the status of the metrics manager.
+func (m *Manager) Status() Status {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+
+	return Status{
+		Scraper: m.scraperStatus,
+		Sender:  m.senderStatus,
+		Buffer:  m.store.BufferStatus(),
+	}
 }
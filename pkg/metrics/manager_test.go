@@ -0,0 +1,434 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hamba/avro"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/metrics/protocol"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
+	"github.com/traefik/hub-agent-traefik/pkg/traefik"
+)
+
+func TestManager_scrapeRecoversFromFailures(t *testing.T) {
+	var failing int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// Not a server error, which would be retried by the client.
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(rw, "Traefik is being reconfigured", http.StatusForbidden)
+			return
+		}
+
+		file, err := os.Open("testdata/traefik-metrics.txt")
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer func() { _ = file.Close() }()
+
+		_, _ = io.Copy(rw, file)
+	}))
+	t.Cleanup(srv.Close)
+
+	traefikClient, err := traefik.NewClient(srv.URL, true, "", "", "")
+	require.NoError(t, err)
+
+	store := NewStore()
+	mgr := NewManager(nil, store, NewScraper(Target{Name: "traefik", Client: traefikClient}))
+	mgr.AddExporter("prometheus", NewPrometheusExporter(""))
+
+	start := time.Date(2021, 1, 1, 8, 0, 0, 0, time.UTC)
+	mgr.nowFunc = func() time.Time { return start }
+
+	ctx := context.Background()
+	state := newScrapeState()
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+	ts := func(minutes int) int64 {
+		return at(minutes).Unix()
+	}
+
+	// The first scrape is the reference, the second one gives the first data points.
+	require.NoError(t, mgr.scrape(ctx, state, at(0)))
+	require.NoError(t, mgr.scrape(ctx, state, at(1)))
+
+	atomic.StoreInt32(&failing, 1)
+	require.Error(t, mgr.scrape(ctx, state, at(2)))
+	require.Error(t, mgr.scrape(ctx, state, at(2)))
+
+	status := mgr.Status().Scraper
+	assert.False(t, status.Healthy)
+	assert.Equal(t, 2, status.ConsecutiveFailures)
+	assert.Contains(t, status.LastError, "403")
+
+	// Once Traefik is back, the missed intervals are recorded as gaps, and the scrape is the new reference.
+	atomic.StoreInt32(&failing, 0)
+	require.NoError(t, mgr.scrape(ctx, state, at(3)))
+	require.NoError(t, mgr.scrape(ctx, state, at(4)))
+
+	status = mgr.Status().Scraper
+	assert.True(t, status.Healthy)
+	assert.Equal(t, 0, status.ConsecutiveFailures)
+	assert.Empty(t, status.LastError)
+	assert.Equal(t, 2, status.MissedIntervals)
+
+	var got DataPoints
+	store.ForEach("1m", func(edgeIngr, _, _ string, pnts DataPoints) {
+		if edgeIngr == "myIngress-default-example-com" {
+			got = pnts
+		}
+	})
+
+	require.Len(t, got, 4)
+
+	assert.Equal(t, ts(1), got[0].Timestamp)
+	assert.False(t, got[0].Gap)
+	assert.Equal(t, DataPoint{Timestamp: ts(2), Seconds: 60, Gap: true}, got[1])
+	assert.Equal(t, DataPoint{Timestamp: ts(3), Seconds: 60, Gap: true}, got[2])
+	assert.Equal(t, ts(4), got[3].Timestamp)
+	assert.False(t, got[3].Gap)
+
+	// Only the scrapes giving data points are exported, gap markers are not.
+	assert.Len(t, mgr.exporters[0].grps, 2)
+}
+
+func TestManager_scrapeSeveralTargets(t *testing.T) {
+	newTarget := func(name string, requests, failing *int32) Target {
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			// Not a server error, which would be retried by the client.
+			if atomic.LoadInt32(failing) == 1 {
+				http.Error(rw, "Traefik is being reconfigured", http.StatusForbidden)
+				return
+			}
+
+			_, _ = fmt.Fprintf(rw, "# TYPE traefik_service_requests_total counter\n"+
+				"traefik_service_requests_total{code=\"200\",method=\"GET\",protocol=\"http\",service=\"whoami@docker\"} %d\n",
+				atomic.LoadInt32(requests))
+		}))
+		t.Cleanup(srv.Close)
+
+		client, err := traefik.NewClient(srv.URL, true, "", "", "")
+		require.NoError(t, err)
+
+		return Target{Name: name, Client: client}
+	}
+
+	var (
+		requests1, requests2 int32 = 10, 20
+		failing1, failing2   int32
+	)
+
+	store := NewStore()
+	mgr := NewManager(nil, store, NewScraper(
+		newTarget("traefik-1", &requests1, &failing1),
+		newTarget("traefik-2", &requests2, &failing2),
+	))
+
+	start := time.Date(2021, 1, 1, 8, 0, 0, 0, time.UTC)
+	mgr.nowFunc = func() time.Time { return start }
+
+	ctx := context.Background()
+	state := newScrapeState()
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+	ts := func(minutes int) int64 {
+		return at(minutes).Unix()
+	}
+	scrape := func(minutes int, req1, req2 int32) {
+		atomic.StoreInt32(&requests1, req1)
+		atomic.StoreInt32(&requests2, req2)
+		require.NoError(t, mgr.scrape(ctx, state, at(minutes)))
+	}
+
+	scrape(0, 10, 20)
+	scrape(1, 15, 30)
+
+	// While an instance is down, the others are still counted, but the interval is flagged as a gap.
+	atomic.StoreInt32(&failing2, 1)
+	scrape(2, 20, 30)
+
+	status := mgr.Status().Scraper
+	assert.True(t, status.Healthy)
+	require.Len(t, status.Targets, 2)
+	assert.Equal(t, TargetStatus{Name: "traefik-1", Healthy: true}, status.Targets[0])
+	assert.Equal(t, "traefik-2", status.Targets[1].Name)
+	assert.False(t, status.Targets[1].Healthy)
+	assert.Contains(t, status.Targets[1].LastError, "403")
+
+	// Once back, the instance gives a new reference, Traefik might have restarted in between.
+	atomic.StoreInt32(&failing2, 0)
+	scrape(3, 25, 100)
+	scrape(4, 30, 110)
+
+	var got DataPoints
+	store.ForEach("1m", func(_, _, svc string, pnts DataPoints) {
+		if svc == "whoami@docker" {
+			got = pnts
+		}
+	})
+
+	require.Len(t, got, 4)
+
+	wantRequests := []int64{15, 5, 5, 15}
+	wantGaps := []bool{false, true, true, false}
+	for i, pnt := range got {
+		assert.Equal(t, ts(i+1), pnt.Timestamp)
+		assert.Equal(t, wantRequests[i], pnt.Requests)
+		assert.Equal(t, wantGaps[i], pnt.Gap)
+	}
+	assert.Equal(t, 0, mgr.Status().Scraper.MissedIntervals)
+}
+
+func TestManager_scrapeSkippedInterval(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		file, err := os.Open("testdata/traefik-metrics.txt")
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer func() { _ = file.Close() }()
+
+		_, _ = io.Copy(rw, file)
+	}))
+	t.Cleanup(srv.Close)
+
+	traefikClient, err := traefik.NewClient(srv.URL, true, "", "", "")
+	require.NoError(t, err)
+
+	store := NewStore()
+	mgr := NewManager(nil, store, NewScraper(Target{Name: "traefik", Client: traefikClient}))
+
+	start := time.Date(2021, 1, 1, 8, 0, 0, 0, time.UTC)
+	mgr.nowFunc = func() time.Time { return start }
+
+	ctx := context.Background()
+	state := newScrapeState()
+	at := func(minutes int) time.Time {
+		return start.Add(time.Duration(minutes) * time.Minute)
+	}
+	ts := func(minutes int) int64 {
+		return at(minutes).Unix()
+	}
+
+	require.NoError(t, mgr.scrape(ctx, state, at(0)))
+	require.NoError(t, mgr.scrape(ctx, state, at(1)))
+
+	// A slow scrape made the scraper skip an interval.
+	require.NoError(t, mgr.scrape(ctx, state, at(3)))
+
+	assert.Equal(t, 1, mgr.Status().Scraper.MissedIntervals)
+
+	var got DataPoints
+	store.ForEach("1m", func(edgeIngr, _, _ string, pnts DataPoints) {
+		if edgeIngr == "myIngress-default-example-com" {
+			got = pnts
+		}
+	})
+
+	require.Len(t, got, 3)
+
+	assert.Equal(t, ts(1), got[0].Timestamp)
+	assert.False(t, got[0].Gap)
+	assert.Equal(t, DataPoint{Timestamp: ts(2), Seconds: 60, Gap: true}, got[1])
+	assert.Equal(t, ts(3), got[2].Timestamp)
+	assert.True(t, got[2].Gap)
+}
+
+func TestManager_scrapeReferenceMidInterval(t *testing.T) {
+	var requests int32 = 10
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = fmt.Fprintf(rw, "# TYPE traefik_service_requests_total counter\n"+
+			"traefik_service_requests_total{code=\"200\",method=\"GET\",protocol=\"http\",service=\"whoami@docker\"} %d\n",
+			atomic.LoadInt32(&requests))
+	}))
+	t.Cleanup(srv.Close)
+
+	traefikClient, err := traefik.NewClient(srv.URL, true, "", "", "")
+	require.NoError(t, err)
+
+	store := NewStore()
+	mgr := NewManager(nil, store, NewScraper(Target{Name: "traefik", Client: traefikClient}))
+
+	start := time.Date(2021, 1, 1, 8, 0, 0, 0, time.UTC)
+	mgr.nowFunc = func() time.Time { return start }
+
+	ctx := context.Background()
+	state := newScrapeState()
+
+	// The reference is taken 15 seconds before the next interval, e.g. when the agent starts.
+	require.NoError(t, mgr.scrape(ctx, state, start.Add(45*time.Second)))
+
+	atomic.StoreInt32(&requests, 25)
+	require.NoError(t, mgr.scrape(ctx, state, start.Add(time.Minute)))
+
+	atomic.StoreInt32(&requests, 85)
+	require.NoError(t, mgr.scrape(ctx, state, start.Add(2*time.Minute)))
+
+	var got DataPoints
+	store.ForEach("1m", func(_, _, svc string, pnts DataPoints) {
+		if svc == "whoami@docker" {
+			got = pnts
+		}
+	})
+
+	require.Len(t, got, 2)
+
+	assert.Equal(t, start.Add(time.Minute).Unix(), got[0].Timestamp)
+	assert.Equal(t, int64(15), got[0].Seconds)
+	assert.Equal(t, int64(15), got[0].Requests)
+	assert.InDelta(t, 1, got[0].ReqPerS, 0.001)
+	assert.False(t, got[0].Gap)
+
+	assert.Equal(t, start.Add(2*time.Minute).Unix(), got[1].Timestamp)
+	assert.Equal(t, int64(60), got[1].Seconds)
+	assert.Equal(t, int64(60), got[1].Requests)
+	assert.InDelta(t, 1, got[1].ReqPerS, 0.001)
+}
+
+func Test_nextScrapeIn(t *testing.T) {
+	lastTS := time.Date(2021, 1, 1, 8, 0, 0, 0, time.UTC).Unix()
+
+	tests := []struct {
+		desc string
+		now  time.Time
+		want time.Duration
+	}{
+		{
+			desc: "quick scrape",
+			now:  time.Date(2021, 1, 1, 8, 0, 1, 0, time.UTC),
+			want: 59 * time.Second,
+		},
+		{
+			desc: "scrape ending right before the next interval",
+			now:  time.Date(2021, 1, 1, 8, 0, 59, 900000000, time.UTC),
+			want: 100 * time.Millisecond,
+		},
+		{
+			desc: "scrape longer than the interval",
+			now:  time.Date(2021, 1, 1, 8, 1, 5, 0, time.UTC),
+			want: 0,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, test.want, nextScrapeIn(lastTS, test.now))
+		})
+	}
+}
+
+func TestManager_sendInBatches(t *testing.T) {
+	schema, err := avro.Parse(protocol.MetricsV3Schema)
+	require.NoError(t, err)
+
+	var (
+		received     []int
+		acceptedLeft int32 = 1
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		data := map[string][]DataPointGroup{}
+		if err := avro.NewDecoderForSchema(schema, req.Body).Decode(&data); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var count int
+		for _, grp := range data["1m"] {
+			count += len(grp.DataPoints)
+		}
+		received = append(received, count)
+
+		switch {
+		case count > 3:
+			http.Error(rw, "payload too large", http.StatusRequestEntityTooLarge)
+		case atomic.AddInt32(&acceptedLeft, -1) < 0:
+			// Not a server error, which would be retried by the client.
+			http.Error(rw, "unavailable", http.StatusForbidden)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client, err := NewClient(http.DefaultClient, srv.URL, secret.StaticToken("token"))
+	require.NoError(t, err)
+
+	now := time.Date(2021, 1, 1, 8, 0, 0, 0, time.UTC)
+	store := NewStore()
+	store.nowFunc = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		ts := now.Add(time.Duration(i) * time.Minute).Unix()
+		store.Insert(map[SetKey]DataPoint{
+			{EdgeIngress: "ing-1"}: {Timestamp: ts, Seconds: 60},
+			{EdgeIngress: "ing-2"}: {Timestamp: ts, Seconds: 60},
+		})
+	}
+
+	mgr := NewManager(client, store, nil)
+	mgr.SetBatchSize(5)
+
+	// The first batch is too large and split in two, the second half of which fails: only the first half is committed.
+	err = mgr.send(context.Background(), []string{"1m"})
+	require.Error(t, err)
+
+	assert.Equal(t, []int{5, 3, 2}, received)
+	assert.Equal(t, 5, store.BufferStatus()["1m"].Unsent)
+
+	received = nil
+	atomic.StoreInt32(&acceptedLeft, 10)
+
+	require.NoError(t, mgr.send(context.Background(), []string{"1m"}))
+
+	assert.Equal(t, []int{5, 3, 2}, received)
+	assert.Equal(t, 0, store.BufferStatus()["1m"].Unsent)
+}
+
+func TestBatchRows(t *testing.T) {
+	pnts := func(n int) DataPoints {
+		return make(DataPoints, n)
+	}
+	keyA, keyB := tableKey{EdgeIngress: "a"}, tableKey{EdgeIngress: "b"}
+
+	rows := []sendRow{
+		{table: "1m", key: keyA, pnts: pnts(3), mark: 5},
+		{table: "10m", key: keyB, pnts: pnts(2), mark: 2},
+	}
+
+	assert.Nil(t, batchRows(nil, 2))
+	assert.Equal(t, [][]sendRow{rows}, batchRows(rows, 0))
+	assert.Equal(t, [][]sendRow{
+		{{table: "1m", key: keyA, pnts: pnts(2), mark: 4}},
+		{{table: "1m", key: keyA, pnts: pnts(1), mark: 5}, {table: "10m", key: keyB, pnts: pnts(1), mark: 1}},
+		{{table: "10m", key: keyB, pnts: pnts(1), mark: 2}},
+	}, batchRows(rows, 2))
+}
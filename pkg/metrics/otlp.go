@@ -0,0 +1,182 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// otlpScopeName is the name of the instrumentation scope of the exported metrics.
+const otlpScopeName = "github.com/traefik/hub-agent-traefik/pkg/metrics"
+
+// OTLPExporter exports data points to an OpenTelemetry collector, using OTLP/HTTP with the JSON encoding.
+// Each exported value is a gauge.
+type OTLPExporter struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+// NewOTLPExporter creates an OTLP exporter sending metrics to the given OTLP/HTTP metrics endpoint, e.g.
+// http://localhost:4318/v1/metrics.
+func NewOTLPExporter(client *http.Client, endpoint string) (*OTLPExporter, error) {
+	u, err := url.ParseRequestURI(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP endpoint: %w", err)
+	}
+
+	return &OTLPExporter{
+		endpoint:   u.String(),
+		httpClient: client,
+	}, nil
+}
+
+type otlpExportRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Unit        string    `json:"unit"`
+	Gauge       otlpGauge `json:"gauge"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes        []otlpAttribute `json:"attributes"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsDouble          float64         `json:"asDouble"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// Export exports data points to the OTLP endpoint.
+func (e *OTLPExporter) Export(ctx context.Context, grps []DataPointGroup) error {
+	mtrcs := make([]otlpMetric, 0, len(exportedMetrics))
+	for _, exported := range exportedMetrics {
+		mtrc := otlpMetric{
+			Name:        exported.Name,
+			Description: exported.Help,
+			Unit:        exported.Unit,
+		}
+
+		for _, grp := range grps {
+			var attrs []otlpAttribute
+			for _, lbl := range exportLabels(grp) {
+				attrs = append(attrs, otlpAttribute{Key: lbl.Name, Value: otlpAnyValue{StringValue: lbl.Value}})
+			}
+
+			for _, pnt := range grp.DataPoints {
+				mtrc.Gauge.DataPoints = append(mtrc.Gauge.DataPoints, otlpNumberDataPoint{
+					Attributes:        attrs,
+					StartTimeUnixNano: unixNano(pnt.Timestamp - pnt.Seconds),
+					TimeUnixNano:      unixNano(pnt.Timestamp),
+					AsDouble:          exported.Value(pnt),
+				})
+			}
+		}
+
+		mtrcs = append(mtrcs, mtrc)
+	}
+
+	body, err := json.Marshal(otlpExportRequest{
+		ResourceMetrics: []otlpResourceMetrics{
+			{
+				Resource: otlpResource{
+					Attributes: []otlpAttribute{{Key: "service.name", Value: otlpAnyValue{StringValue: "traefik-hub-agent"}}},
+				},
+				ScopeMetrics: []otlpScopeMetrics{
+					{
+						Scope:   otlpScope{Name: otlpScopeName},
+						Metrics: mtrcs,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal OTLP request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return doExport(e.httpClient, req)
+}
+
+// unixNano returns the given Unix time, in seconds, as a string of nanoseconds, as fixed64 fields are encoded in JSON.
+func unixNano(ts int64) string {
+	return strconv.FormatInt(time.Unix(ts, 0).UnixNano(), 10)
+}
+
+// doExport sends an export request, failing on any non 2xx response.
+func doExport(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode/100 != 2 {
+		all, _ := io.ReadAll(resp.Body)
+
+		return fmt.Errorf("%d: %s", resp.StatusCode, string(all))
+	}
+
+	return nil
+}
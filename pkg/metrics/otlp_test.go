@@ -0,0 +1,86 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOTLPExporter_Export(t *testing.T) {
+	var got otlpExportRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/v1/metrics", req.URL.Path)
+		assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+
+		if err := json.NewDecoder(req.Body).Decode(&got); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	exporter, err := NewOTLPExporter(http.DefaultClient, srv.URL+"/v1/metrics")
+	require.NoError(t, err)
+
+	require.NoError(t, exporter.Export(context.Background(), testExportGroups()))
+
+	require.Len(t, got.ResourceMetrics, 1)
+	require.Len(t, got.ResourceMetrics[0].ScopeMetrics, 1)
+
+	mtrcs := got.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	require.Len(t, mtrcs, len(exportedMetrics))
+
+	assert.Equal(t, "traefik_hub_requests_per_second", mtrcs[0].Name)
+	assert.Equal(t, "1/s", mtrcs[0].Unit)
+	assert.Equal(t, []otlpNumberDataPoint{
+		{
+			Attributes:        []otlpAttribute{{Key: "edge_ingress", Value: otlpAnyValue{StringValue: "whoami"}}},
+			StartTimeUnixNano: "60000000000",
+			TimeUnixNano:      "120000000000",
+			AsDouble:          2.5,
+		},
+		{
+			Attributes: []otlpAttribute{
+				{Key: "ingress", Value: otlpAnyValue{StringValue: "web"}},
+				{Key: "service", Value: otlpAnyValue{StringValue: "whoami@docker"}},
+			},
+			StartTimeUnixNano: "60000000000",
+			TimeUnixNano:      "120000000000",
+			AsDouble:          1,
+		},
+	}, mtrcs[0].Gauge.DataPoints)
+}
+
+func TestOTLPExporter_ExportHandlesHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "collector unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+
+	exporter, err := NewOTLPExporter(http.DefaultClient, srv.URL)
+	require.NoError(t, err)
+
+	err = exporter.Export(context.Background(), testExportGroups())
+	assert.ErrorContains(t, err, "503")
+}
@@ -18,6 +18,8 @@ along with this program. If not, see <https://www.gnu.org/licenses/>.
 package metrics
 
 import (
+	"sort"
+	"strconv"
 	"strings"
 
 	dto "github.com/prometheus/client_model/go"
@@ -45,16 +47,65 @@ func (p TraefikParser) Parse(m *dto.MetricFamily) []Metric {
 
 	switch *m.Name {
 	case "traefik_router_request_duration_seconds":
-		metrics = append(metrics, p.parseRouterRequestDuration(m.Metric)...)
+		metrics = append(metrics, p.parseRequestDuration(m.Metric, p.guessRouter)...)
 
 	case "traefik_router_requests_total":
-		metrics = append(metrics, p.parseRouterRequestTotal(m.Metric)...)
+		metrics = append(metrics, p.parseRequestTotal(m.Metric, p.guessRouter)...)
+
+	case "traefik_router_requests_bytes_total":
+		metrics = append(metrics, p.parseCounter(m.Metric, MetricRequestBytes, p.guessRouter)...)
+
+	case "traefik_router_responses_bytes_total":
+		metrics = append(metrics, p.parseCounter(m.Metric, MetricResponseBytes, p.guessRouter)...)
+
+	case "traefik_router_open_connections":
+		metrics = append(metrics, p.parseGauge(m.Metric, MetricOpenConnections, p.guessRouter)...)
+
+	case "traefik_service_request_duration_seconds":
+		metrics = append(metrics, p.parseRequestDuration(m.Metric, guessService)...)
+
+	case "traefik_service_requests_total":
+		metrics = append(metrics, p.parseRequestTotal(m.Metric, guessService)...)
+
+	case "traefik_service_requests_bytes_total":
+		metrics = append(metrics, p.parseCounter(m.Metric, MetricRequestBytes, guessService)...)
+
+	case "traefik_service_responses_bytes_total":
+		metrics = append(metrics, p.parseCounter(m.Metric, MetricResponseBytes, guessService)...)
+
+	case "traefik_service_open_connections":
+		metrics = append(metrics, p.parseGauge(m.Metric, MetricOpenConnections, guessService)...)
+
+	case "traefik_service_retries_total":
+		metrics = append(metrics, p.parseCounter(m.Metric, MetricRetries, guessService)...)
+
+	case "traefik_service_server_up":
+		metrics = append(metrics, p.parseGauge(m.Metric, MetricServerUp, guessService)...)
+
+	case "traefik_entrypoint_request_duration_seconds":
+		metrics = append(metrics, p.parseRequestDuration(m.Metric, guessEntryPoint)...)
+
+	case "traefik_entrypoint_requests_total":
+		metrics = append(metrics, p.parseRequestTotal(m.Metric, guessEntryPoint)...)
+
+	case "traefik_entrypoint_requests_bytes_total":
+		metrics = append(metrics, p.parseCounter(m.Metric, MetricRequestBytes, guessEntryPoint)...)
+
+	case "traefik_entrypoint_responses_bytes_total":
+		metrics = append(metrics, p.parseCounter(m.Metric, MetricResponseBytes, guessEntryPoint)...)
+
+	case "traefik_entrypoint_open_connections":
+		metrics = append(metrics, p.parseGauge(m.Metric, MetricOpenConnections, guessEntryPoint)...)
 	}
 
 	return metrics
 }
 
-func (p TraefikParser) parseRouterRequestDuration(metrics []*dto.Metric) []Metric {
+// keyFunc returns the key of the set a metric belongs to, from its labels. It returns false if the metric must be
+// ignored.
+type keyFunc func(lbls []*dto.LabelPair) (SetKey, bool)
+
+func (p TraefikParser) parseRequestDuration(metrics []*dto.Metric, keyFn keyFunc) []Metric {
 	var enrichedMetrics []Metric
 
 	for _, metric := range metrics {
@@ -63,15 +114,16 @@ func (p TraefikParser) parseRouterRequestDuration(metrics []*dto.Metric) []Metri
 			continue
 		}
 
-		router := p.guessRouter(metric.Label)
-		if router == "" {
+		key, ok := keyFn(metric.Label)
+		if !ok {
 			continue
 		}
 
-		// Service can't be accurately obtained on router metrics. The service label holds the service name to which the
-		// router will deliver the traffic, not the leaf node of the service tree (e.g. load-balancer, wrr).
 		hist.Name = MetricRequestDuration
-		hist.EdgeIngress = router
+		hist.EdgeIngress = key.EdgeIngress
+		hist.Ingress = key.Ingress
+		hist.Service = key.Service
+		hist.Series = seriesID(metric.Label)
 
 		enrichedMetrics = append(enrichedMetrics, hist)
 	}
@@ -79,7 +131,7 @@ func (p TraefikParser) parseRouterRequestDuration(metrics []*dto.Metric) []Metri
 	return enrichedMetrics
 }
 
-func (p TraefikParser) parseRouterRequestTotal(metrics []*dto.Metric) []Metric {
+func (p TraefikParser) parseRequestTotal(metrics []*dto.Metric, keyFn keyFunc) []Metric {
 	var enrichedMetrics []Metric
 
 	for _, metric := range metrics {
@@ -88,16 +140,17 @@ func (p TraefikParser) parseRouterRequestTotal(metrics []*dto.Metric) []Metric {
 			continue
 		}
 
-		router := p.guessRouter(metric.Label)
-		if router == "" {
+		key, ok := keyFn(metric.Label)
+		if !ok {
 			continue
 		}
 
-		// Service can't be accurately obtained on router metrics. The service label holds the service name to which the
-		// router will deliver the traffic, not the leaf node of the service tree (e.g. load-balancer, wrr).
 		enrichedMetrics = append(enrichedMetrics, &Counter{
 			Name:        MetricRequests,
-			EdgeIngress: router,
+			EdgeIngress: key.EdgeIngress,
+			Ingress:     key.Ingress,
+			Service:     key.Service,
+			Series:      seriesID(metric.Label),
 			Value:       counter,
 		})
 
@@ -107,7 +160,37 @@ func (p TraefikParser) parseRouterRequestTotal(metrics []*dto.Metric) []Metric {
 		}
 		enrichedMetrics = append(enrichedMetrics, &Counter{
 			Name:        metricErrorName,
-			EdgeIngress: router,
+			EdgeIngress: key.EdgeIngress,
+			Ingress:     key.Ingress,
+			Service:     key.Service,
+			Series:      seriesID(metric.Label),
+			Value:       counter,
+		})
+	}
+
+	return enrichedMetrics
+}
+
+func (p TraefikParser) parseCounter(metrics []*dto.Metric, name string, keyFn keyFunc) []Metric {
+	var enrichedMetrics []Metric
+
+	for _, metric := range metrics {
+		counter := CounterFromMetric(metric)
+		if counter == 0 {
+			continue
+		}
+
+		key, ok := keyFn(metric.Label)
+		if !ok {
+			continue
+		}
+
+		enrichedMetrics = append(enrichedMetrics, &Counter{
+			Name:        name,
+			EdgeIngress: key.EdgeIngress,
+			Ingress:     key.Ingress,
+			Service:     key.Service,
+			Series:      seriesID(metric.Label),
 			Value:       counter,
 		})
 	}
@@ -115,15 +198,68 @@ func (p TraefikParser) parseRouterRequestTotal(metrics []*dto.Metric) []Metric {
 	return enrichedMetrics
 }
 
-func (p TraefikParser) guessRouter(lbls []*dto.LabelPair) string {
+func (p TraefikParser) parseGauge(metrics []*dto.Metric, name string, keyFn keyFunc) []Metric {
+	var enrichedMetrics []Metric
+
+	for _, metric := range metrics {
+		if metric.Gauge == nil {
+			continue
+		}
+
+		key, ok := keyFn(metric.Label)
+		if !ok {
+			continue
+		}
+
+		enrichedMetrics = append(enrichedMetrics, &Gauge{
+			Name:        name,
+			EdgeIngress: key.EdgeIngress,
+			Ingress:     key.Ingress,
+			Service:     key.Service,
+			Series:      seriesID(metric.Label),
+			Value:       GaugeFromMetric(metric),
+		})
+	}
+
+	return enrichedMetrics
+}
+
+// guessRouter returns the key of the edge ingress a router metric belongs to. Service can't be accurately obtained
+// on router metrics. The service label holds the service name to which the router will deliver the traffic, not the
+// leaf node of the service tree (e.g. load-balancer, wrr).
+func (p TraefikParser) guessRouter(lbls []*dto.LabelPair) (SetKey, bool) {
 	router := getLabel(lbls, "router")
 
 	if !strings.HasSuffix(router, "@hub") {
-		return ""
+		return SetKey{}, false
 	}
 
 	// Remove @hub suffix before returning
-	return router[:len(router)-4]
+	return SetKey{EdgeIngress: router[:len(router)-4]}, true
+}
+
+// guessService returns the key of the service a service metric belongs to. Internal services, such as the API or
+// the dashboard, are ignored.
+func guessService(lbls []*dto.LabelPair) (SetKey, bool) {
+	service := getLabel(lbls, "service")
+
+	if service == "" || strings.HasSuffix(service, "@internal") {
+		return SetKey{}, false
+	}
+
+	return SetKey{Service: service}, true
+}
+
+// guessEntryPoint returns the key of the entry point an entry point metric belongs to. Entry points are where the
+// traffic gets in, so they are reported as ingresses.
+func guessEntryPoint(lbls []*dto.LabelPair) (SetKey, bool) {
+	entryPoint := getLabel(lbls, "entrypoint")
+
+	if entryPoint == "" {
+		return SetKey{}, false
+	}
+
+	return SetKey{Ingress: entryPoint}, true
 }
 
 func getMetricErrorName(lbls []*dto.LabelPair, statusName string) string {
@@ -142,6 +278,17 @@ func getMetricErrorName(lbls []*dto.LabelPair, statusName string) string {
 	}
 }
 
+// seriesID returns an identifier of the series with the given labels, unique within a metric family.
+func seriesID(lbls []*dto.LabelPair) string {
+	pairs := make([]string, 0, len(lbls))
+	for _, l := range lbls {
+		pairs = append(pairs, l.GetName()+"="+strconv.Quote(l.GetValue()))
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
 func getLabel(lbls []*dto.LabelPair, name string) string {
 	for _, l := range lbls {
 		if l.Name != nil && l.Value != nil && *l.Name == name {
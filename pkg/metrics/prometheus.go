@@ -0,0 +1,156 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"net/http"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// PrometheusExporter serves the data points of the last scrape on a Prometheus /metrics endpoint.
+type PrometheusExporter struct {
+	listenAddr string
+
+	mu   sync.RWMutex
+	grps []DataPointGroup
+}
+
+// NewPrometheusExporter creates a Prometheus exporter listening on the given address.
+func NewPrometheusExporter(listenAddr string) *PrometheusExporter {
+	return &PrometheusExporter{listenAddr: listenAddr}
+}
+
+// Export replaces the data points served by the exporter.
+func (e *PrometheusExporter) Export(_ context.Context, grps []DataPointGroup) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.grps = grps
+
+	return nil
+}
+
+// ServeHTTP serves the data points of the last scrape in the Prometheus text format.
+func (e *PrometheusExporter) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", string(expfmt.FmtText))
+
+	for _, mf := range e.metricFamilies() {
+		if _, err := expfmt.MetricFamilyToText(rw, mf); err != nil {
+			log.Error().Err(err).Msg("Unable to write metrics")
+			return
+		}
+	}
+}
+
+func (e *PrometheusExporter) metricFamilies() []*dto.MetricFamily {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if len(e.grps) == 0 {
+		return nil
+	}
+
+	gauge := dto.MetricType_GAUGE
+
+	mfs := make([]*dto.MetricFamily, 0, len(exportedMetrics))
+	for _, exported := range exportedMetrics {
+		name, help := exported.Name, exported.Help
+		mf := &dto.MetricFamily{
+			Name: &name,
+			Help: &help,
+			Type: &gauge,
+		}
+
+		for _, grp := range e.grps {
+			var lbls []*dto.LabelPair
+			for _, lbl := range exportLabels(grp) {
+				lbl := lbl
+				lbls = append(lbls, &dto.LabelPair{Name: &lbl.Name, Value: &lbl.Value})
+			}
+
+			for _, pnt := range grp.DataPoints {
+				value, ts := exported.Value(pnt), pnt.Timestamp*1000
+				mf.Metric = append(mf.Metric, &dto.Metric{
+					Label:       lbls,
+					Gauge:       &dto.Gauge{Value: &value},
+					TimestampMs: &ts,
+				})
+			}
+		}
+
+		mfs = append(mfs, mf)
+	}
+
+	return mfs
+}
+
+// Run runs the Prometheus exporter server until the given context is canceled.
+func (e *PrometheusExporter) Run(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+
+	server := &http.Server{
+		Addr:              e.listenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          stdlog.New(log.Logger.Level(zerolog.DebugLevel), "", 0),
+	}
+
+	srvDone := make(chan struct{})
+
+	go func() {
+		log.Info().Str("addr", e.listenAddr).Msg("Starting Prometheus metrics server")
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Err(err).Msg("Unable to listen and serve metrics requests")
+		}
+		close(srvDone)
+	}()
+
+	select {
+	case <-ctx.Done():
+		gracefulCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		//nolint:contextcheck // False positive.
+		if err := server.Shutdown(gracefulCtx); err != nil {
+			log.Error().Err(err).Msg("Failed to shutdown Prometheus metrics server gracefully")
+			if err = server.Close(); err != nil {
+				return fmt.Errorf("close Prometheus metrics server: %w", err)
+			}
+		}
+
+		return nil
+	case <-srvDone:
+		return errors.New("prometheus metrics server stopped")
+	}
+}
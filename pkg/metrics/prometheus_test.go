@@ -0,0 +1,58 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusExporter_ServeHTTP(t *testing.T) {
+	exporter := NewPrometheusExporter("")
+
+	rec := httptest.NewRecorder()
+	exporter.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	require.NoError(t, exporter.Export(context.Background(), testExportGroups()))
+
+	rec = httptest.NewRecorder()
+	exporter.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var parser expfmt.TextParser
+	mfs, err := parser.TextToMetricFamilies(rec.Body)
+	require.NoError(t, err)
+	require.Len(t, mfs, len(exportedMetrics))
+
+	mf := mfs["traefik_hub_requests_per_second"]
+	require.NotNil(t, mf)
+	require.Len(t, mf.Metric, 2)
+
+	assert.Equal(t, "edge_ingress", mf.Metric[0].Label[0].GetName())
+	assert.Equal(t, "whoami", mf.Metric[0].Label[0].GetValue())
+	assert.Equal(t, 2.5, mf.Metric[0].Gauge.GetValue())
+	assert.Equal(t, int64(120000), mf.Metric[0].GetTimestampMs())
+}
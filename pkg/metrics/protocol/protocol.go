@@ -2,6 +2,6 @@ package protocol
 
 import _ "embed" // Needed for go embed.
 
-// MetricsV2Schema is the metrics v2 transport schema.
-//go:embed metrics-v2.avsc
-var MetricsV2Schema string
+// MetricsV3Schema is the metrics v3 transport schema.
+//go:embed metrics-v3.avsc
+var MetricsV3Schema string
@@ -0,0 +1,346 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultQueryRange = time.Hour
+	defaultTopLimit   = 10
+	maxTopLimit       = 100
+)
+
+// QueryResult is the result of a data point query.
+type QueryResult struct {
+	Table      string     `json:"table"`
+	From       time.Time  `json:"from"`
+	To         time.Time  `json:"to"`
+	DataPoints DataPoints `json:"dataPoints"`
+}
+
+// TopResult is the result of a top query.
+type TopResult struct {
+	Table   string     `json:"table"`
+	From    time.Time  `json:"from"`
+	To      time.Time  `json:"to"`
+	Group   string     `json:"group"`
+	By      string     `json:"by"`
+	Entries []TopEntry `json:"entries"`
+}
+
+// TopEntry is an entry of a top query, summarizing the traffic of an edge ingress, an ingress or a service over the
+// queried range.
+type TopEntry struct {
+	Name    string    `json:"name"`
+	Summary DataPoint `json:"summary"`
+}
+
+// topGroups are the keys by which rows can be grouped in top queries.
+var topGroups = map[string]func(edgeIngr, ingr, svc string) string{
+	"edge-ingress": func(edgeIngr, _, _ string) string { return edgeIngr },
+	"ingress":      func(_, ingr, _ string) string { return ingr },
+	"service":      func(_, _, svc string) string { return svc },
+}
+
+// topOrders are the values by which top queries can rank entries.
+var topOrders = map[string]func(pnt DataPoint) float64{
+	"requests":      func(pnt DataPoint) float64 { return float64(pnt.Requests) },
+	"errors":        func(pnt DataPoint) float64 { return float64(pnt.RequestErrs) },
+	"client-errors": func(pnt DataPoint) float64 { return float64(pnt.RequestClientErrs) },
+	"response-time": func(pnt DataPoint) float64 { return pnt.AvgResponseTime },
+}
+
+// QueryHandler serves the data points of a store as JSON:
+//   - /edge-ingress?name=<name> returns the data points of an edge ingress.
+//   - /ingress?name=<name>[&service=<name>] returns the data points of an ingress, optionally of one of its services.
+//   - /service?name=<name> returns the data points of a service.
+//   - /top?group=<edge-ingress|ingress|service>&by=<requests|errors|client-errors|response-time>&limit=<n> returns
+//     the busiest or most erroring edge ingresses, ingresses or services.
+//
+// The queried range is given by the from and to RFC 3339 timestamps, from defaulting to the given range duration
+// before to, and to to now. Unless a table is given, the finest table holding data points over the range is used.
+type QueryHandler struct {
+	store *Store
+	view  *DataPointView
+	mux   *http.ServeMux
+
+	nowFunc func() time.Time
+}
+
+// NewQueryHandler creates a query handler serving the data points of the given store.
+func NewQueryHandler(store *Store) *QueryHandler {
+	h := &QueryHandler{
+		store:   store,
+		view:    NewDataPointView(store),
+		mux:     http.NewServeMux(),
+		nowFunc: time.Now,
+	}
+
+	h.mux.HandleFunc("/edge-ingress", h.handleEdgeIngress)
+	h.mux.HandleFunc("/ingress", h.handleIngress)
+	h.mux.HandleFunc("/service", h.handleService)
+	h.mux.HandleFunc("/top", h.handleTop)
+
+	return h
+}
+
+// ServeHTTP serves a query.
+func (h *QueryHandler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mux.ServeHTTP(rw, req)
+}
+
+func (h *QueryHandler) handleEdgeIngress(rw http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		writeQueryError(rw, http.StatusBadRequest, errors.New("missing name"))
+		return
+	}
+
+	res, err := h.queryRange(req)
+	if err != nil {
+		writeQueryError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	res.DataPoints = h.view.FindByEdgeIngress(res.Table, name, res.From, res.To)
+
+	writeQueryResult(rw, res)
+}
+
+func (h *QueryHandler) handleIngress(rw http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		writeQueryError(rw, http.StatusBadRequest, errors.New("missing name"))
+		return
+	}
+
+	res, err := h.queryRange(req)
+	if err != nil {
+		writeQueryError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	service := req.URL.Query().Get("service")
+	if service == "" {
+		res.DataPoints = h.view.FindByIngress(res.Table, name, res.From, res.To)
+		writeQueryResult(rw, res)
+		return
+	}
+
+	res.DataPoints, err = h.view.FindByIngressAndService(res.Table, name, service, res.From, res.To)
+	if err != nil {
+		writeQueryError(rw, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeQueryResult(rw, res)
+}
+
+func (h *QueryHandler) handleService(rw http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("name")
+	if name == "" {
+		writeQueryError(rw, http.StatusBadRequest, errors.New("missing name"))
+		return
+	}
+
+	res, err := h.queryRange(req)
+	if err != nil {
+		writeQueryError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	res.DataPoints = h.view.FindByService(res.Table, name, res.From, res.To)
+
+	writeQueryResult(rw, res)
+}
+
+func (h *QueryHandler) handleTop(rw http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	group := query.Get("group")
+	if group == "" {
+		group = "edge-ingress"
+	}
+	keyFn, ok := topGroups[group]
+	if !ok {
+		writeQueryError(rw, http.StatusBadRequest, fmt.Errorf("invalid group %q", group))
+		return
+	}
+
+	by := query.Get("by")
+	if by == "" {
+		by = "requests"
+	}
+	valueFn, ok := topOrders[by]
+	if !ok {
+		writeQueryError(rw, http.StatusBadRequest, fmt.Errorf("invalid order %q", by))
+		return
+	}
+
+	limit := defaultTopLimit
+	if rawLimit := query.Get("limit"); rawLimit != "" {
+		var err error
+		limit, err = strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > maxTopLimit {
+			writeQueryError(rw, http.StatusBadRequest, fmt.Errorf("invalid limit %q, must be between 1 and %d", rawLimit, maxTopLimit))
+			return
+		}
+	}
+
+	res, err := h.queryRange(req)
+	if err != nil {
+		writeQueryError(rw, http.StatusBadRequest, err)
+		return
+	}
+
+	fromTS, toTS := res.From.Unix(), res.To.Unix()
+
+	groups := make(map[string][]DataPoints)
+	h.store.ForEach(res.Table, func(edgeIngr, ingr, svc string, pnts DataPoints) {
+		name := keyFn(edgeIngr, ingr, svc)
+		if name == "" {
+			return
+		}
+
+		var pntsInRange DataPoints
+		for _, pnt := range pnts {
+			if pnt.Timestamp < fromTS || pnt.Timestamp > toTS {
+				continue
+			}
+
+			pntsInRange = append(pntsInRange, pnt)
+		}
+
+		groups[name] = append(groups[name], pntsInRange)
+	})
+
+	entries := make([]TopEntry, 0, len(groups))
+	for name, grps := range groups {
+		summary := mergeGroups(grps).Aggregate()
+		if summary.Requests == 0 {
+			continue
+		}
+
+		entries = append(entries, TopEntry{Name: name, Summary: summary})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		vi, vj := valueFn(entries[i].Summary), valueFn(entries[j].Summary)
+		if vi != vj {
+			return vi > vj
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	writeJSON(rw, http.StatusOK, TopResult{
+		Table:   res.Table,
+		From:    res.From,
+		To:      res.To,
+		Group:   group,
+		By:      by,
+		Entries: entries,
+	})
+}
+
+// queryRange returns the table and time range of a query.
+func (h *QueryHandler) queryRange(req *http.Request) (QueryResult, error) {
+	query := req.URL.Query()
+
+	to := h.nowFunc().UTC()
+	if rawTo := query.Get("to"); rawTo != "" {
+		var err error
+		to, err = time.Parse(time.RFC3339, rawTo)
+		if err != nil {
+			return QueryResult{}, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+
+	rng := defaultQueryRange
+	if rawRange := query.Get("range"); rawRange != "" {
+		var err error
+		rng, err = time.ParseDuration(rawRange)
+		if err != nil || rng <= 0 {
+			return QueryResult{}, fmt.Errorf("invalid range %q", rawRange)
+		}
+	}
+
+	from := to.Add(-rng)
+	if rawFrom := query.Get("from"); rawFrom != "" {
+		var err error
+		from, err = time.Parse(time.RFC3339, rawFrom)
+		if err != nil {
+			return QueryResult{}, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+
+	if !from.Before(to) {
+		return QueryResult{}, errors.New("from must be before to")
+	}
+
+	table := query.Get("table")
+	switch {
+	case table == "":
+		table = h.store.TableFor(to.Sub(from))
+	case !h.store.HasTable(table):
+		return QueryResult{}, fmt.Errorf("invalid table %q", table)
+	}
+
+	return QueryResult{Table: table, From: from, To: to}, nil
+}
+
+func writeQueryResult(rw http.ResponseWriter, res QueryResult) {
+	if res.DataPoints == nil {
+		res.DataPoints = DataPoints{}
+	}
+
+	writeJSON(rw, http.StatusOK, res)
+}
+
+func writeQueryError(rw http.ResponseWriter, status int, err error) {
+	writeJSON(rw, status, struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		log.Error().Err(err).Msg("Unable to write metrics query result")
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryHandler(t *testing.T) {
+	now := time.Date(2021, 1, 1, 8, 0, 0, 0, time.UTC)
+	ts := func(minutes int) int64 {
+		return now.Add(time.Duration(minutes) * time.Minute).Unix()
+	}
+
+	store := NewStore()
+	require.NoError(t, store.Populate("10m", []DataPointGroup{
+		{
+			EdgeIngress: "api",
+			DataPoints: DataPoints{
+				{Timestamp: ts(-50), Seconds: 600, Requests: 60, RequestErrs: 6},
+				{Timestamp: ts(-40), Seconds: 600, Requests: 120},
+				{Timestamp: ts(-120), Seconds: 600, Requests: 1000},
+			},
+		},
+		{
+			EdgeIngress: "web",
+			DataPoints:  DataPoints{{Timestamp: ts(-30), Seconds: 600, Requests: 600}},
+		},
+		{
+			Ingress:    "websecure",
+			Service:    "whoami@docker",
+			DataPoints: DataPoints{{Timestamp: ts(-30), Seconds: 600, Requests: 30, RequestClientErrs: 3}},
+		},
+		{
+			Ingress:    "websecure",
+			Service:    "api@docker",
+			DataPoints: DataPoints{{Timestamp: ts(-30), Seconds: 600, Requests: 90}},
+		},
+	}))
+
+	handler := NewQueryHandler(store)
+	handler.nowFunc = func() time.Time { return now }
+
+	query := func(t *testing.T, target string, wantStatus int, res interface{}) {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+
+		require.Equal(t, wantStatus, rec.Code, rec.Body.String())
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), res))
+	}
+
+	t.Run("edge ingress", func(t *testing.T) {
+		var res QueryResult
+		query(t, "/edge-ingress?name=api", http.StatusOK, &res)
+
+		assert.Equal(t, "10m", res.Table)
+		assert.Equal(t, now.Add(-time.Hour), res.From)
+		assert.Equal(t, now, res.To)
+		require.Len(t, res.DataPoints, 2)
+		assert.Equal(t, ts(-50), res.DataPoints[0].Timestamp)
+		assert.Equal(t, int64(6), res.DataPoints[0].RequestErrs)
+		assert.Equal(t, 0.1, res.DataPoints[0].ReqPerS)
+	})
+
+	t.Run("explicit range and table", func(t *testing.T) {
+		var res QueryResult
+		query(t, "/edge-ingress?name=api&table=10m&from=2021-01-01T05:00:00Z&to=2021-01-01T06:30:00Z", http.StatusOK, &res)
+
+		assert.Equal(t, "10m", res.Table)
+		require.Len(t, res.DataPoints, 1)
+		assert.Equal(t, ts(-120), res.DataPoints[0].Timestamp)
+	})
+
+	t.Run("no data points", func(t *testing.T) {
+		var res map[string]interface{}
+		query(t, "/edge-ingress?name=unknown", http.StatusOK, &res)
+
+		assert.Equal(t, []interface{}{}, res["dataPoints"])
+	})
+
+	t.Run("ingress and service", func(t *testing.T) {
+		var res QueryResult
+		query(t, "/ingress?name=websecure&service=whoami@docker", http.StatusOK, &res)
+
+		require.Len(t, res.DataPoints, 1)
+		assert.Equal(t, int64(30), res.DataPoints[0].Requests)
+
+		query(t, "/ingress?name=websecure", http.StatusOK, &res)
+
+		require.Len(t, res.DataPoints, 1)
+		assert.Equal(t, int64(120), res.DataPoints[0].Requests)
+
+		query(t, "/service?name=api@docker", http.StatusOK, &res)
+
+		require.Len(t, res.DataPoints, 1)
+		assert.Equal(t, int64(90), res.DataPoints[0].Requests)
+	})
+
+	t.Run("top", func(t *testing.T) {
+		var res TopResult
+		query(t, "/top", http.StatusOK, &res)
+
+		assert.Equal(t, "edge-ingress", res.Group)
+		assert.Equal(t, "requests", res.By)
+		require.Len(t, res.Entries, 2)
+		assert.Equal(t, "web", res.Entries[0].Name)
+		assert.Equal(t, int64(600), res.Entries[0].Summary.Requests)
+		assert.Equal(t, "api", res.Entries[1].Name)
+		assert.Equal(t, int64(180), res.Entries[1].Summary.Requests)
+		assert.Equal(t, int64(1200), res.Entries[1].Summary.Seconds)
+
+		query(t, "/top?by=errors&limit=1", http.StatusOK, &res)
+
+		require.Len(t, res.Entries, 1)
+		assert.Equal(t, "api", res.Entries[0].Name)
+
+		query(t, "/top?group=service&by=client-errors", http.StatusOK, &res)
+
+		require.Len(t, res.Entries, 2)
+		assert.Equal(t, "whoami@docker", res.Entries[0].Name)
+		assert.Equal(t, "api@docker", res.Entries[1].Name)
+	})
+
+	t.Run("invalid queries", func(t *testing.T) {
+		for _, target := range []string{
+			"/edge-ingress",
+			"/edge-ingress?name=api&table=2m",
+			"/edge-ingress?name=api&from=2021-01-01T09:00:00Z",
+			"/edge-ingress?name=api&range=soon",
+			"/top?group=router",
+			"/top?by=bytes",
+			"/top?limit=0",
+		} {
+			var res map[string]string
+			query(t, target, http.StatusBadRequest, &res)
+
+			assert.NotEmpty(t, res["error"], target)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/top", http.NoBody))
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
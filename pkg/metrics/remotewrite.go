@@ -0,0 +1,131 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// RemoteWriteExporter exports data points to a Prometheus remote-write endpoint. Each exported value is a series,
+// labeled with the edge ingress, ingress and service of its data point.
+type RemoteWriteExporter struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewRemoteWriteExporter creates a remote-write exporter sending metrics to the given URL, e.g.
+// http://localhost:9090/api/v1/write.
+func NewRemoteWriteExporter(client *http.Client, rawURL string) (*RemoteWriteExporter, error) {
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote-write URL: %w", err)
+	}
+
+	return &RemoteWriteExporter{
+		url:        u.String(),
+		httpClient: client,
+	}, nil
+}
+
+// Export exports data points to the remote-write endpoint.
+func (e *RemoteWriteExporter) Export(ctx context.Context, grps []DataPointGroup) error {
+	var writeReq []byte
+	for _, exported := range exportedMetrics {
+		for _, grp := range grps {
+			lbls := append(exportLabels(grp), exportLabel{Name: "__name__", Value: exported.Name})
+			sort.Slice(lbls, func(i, j int) bool { return lbls[i].Name < lbls[j].Name })
+
+			var series []byte
+			for _, lbl := range lbls {
+				var label []byte
+				label = protowire.AppendTag(label, 1, protowire.BytesType)
+				label = protowire.AppendString(label, lbl.Name)
+				label = protowire.AppendTag(label, 2, protowire.BytesType)
+				label = protowire.AppendString(label, lbl.Value)
+
+				series = protowire.AppendTag(series, 1, protowire.BytesType)
+				series = protowire.AppendBytes(series, label)
+			}
+
+			for _, pnt := range grp.DataPoints {
+				var sample []byte
+				sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+				sample = protowire.AppendFixed64(sample, math.Float64bits(exported.Value(pnt)))
+				sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+				sample = protowire.AppendVarint(sample, uint64(pnt.Timestamp*1000))
+
+				series = protowire.AppendTag(series, 2, protowire.BytesType)
+				series = protowire.AppendBytes(series, sample)
+			}
+
+			writeReq = protowire.AppendTag(writeReq, 1, protowire.BytesType)
+			writeReq = protowire.AppendBytes(writeReq, series)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(snappyEncode(writeReq)))
+	if err != nil {
+		return fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	return doExport(e.httpClient, req)
+}
+
+// snappyMaxLiteral is the maximum length of the literals written by snappyEncode.
+const snappyMaxLiteral = 1 << 16
+
+// snappyEncode encodes the given data in the snappy block format, as required by remote-write. The data is stored as
+// literals, without compression: payloads are small and sent once per scrape, which doesn't justify a compression
+// library.
+func snappyEncode(src []byte) []byte {
+	dst := protowire.AppendVarint(nil, uint64(len(src)))
+
+	for len(src) > 0 {
+		n := len(src)
+		if n > snappyMaxLiteral {
+			n = snappyMaxLiteral
+		}
+
+		// The tag of a literal holds its length minus one, in the tag byte itself up to 60 bytes, and in the 1 or 2
+		// following bytes, little endian, above.
+		switch l := n - 1; {
+		case l < 60:
+			dst = append(dst, byte(l)<<2)
+		case l < 1<<8:
+			dst = append(dst, 60<<2, byte(l))
+		default:
+			dst = append(dst, 61<<2, byte(l), byte(l>>8))
+		}
+
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+
+	return dst
+}
@@ -0,0 +1,161 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type remoteWriteSeries struct {
+	Labels  map[string]string
+	Value   float64
+	TSMilli int64
+}
+
+func TestRemoteWriteExporter_Export(t *testing.T) {
+	var got []remoteWriteSeries
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "application/x-protobuf", req.Header.Get("Content-Type"))
+		assert.Equal(t, "snappy", req.Header.Get("Content-Encoding"))
+		assert.Equal(t, "0.1.0", req.Header.Get("X-Prometheus-Remote-Write-Version"))
+
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+
+		got = decodeWriteRequest(t, snappyDecodeLiterals(t, body))
+	}))
+	t.Cleanup(srv.Close)
+
+	exporter, err := NewRemoteWriteExporter(http.DefaultClient, srv.URL+"/api/v1/write")
+	require.NoError(t, err)
+
+	require.NoError(t, exporter.Export(context.Background(), testExportGroups()))
+
+	require.Len(t, got, 2*len(exportedMetrics))
+	assert.Contains(t, got, remoteWriteSeries{
+		Labels:  map[string]string{"__name__": "traefik_hub_response_time_p99_seconds", "edge_ingress": "whoami"},
+		Value:   0.3,
+		TSMilli: 120000,
+	})
+	assert.Contains(t, got, remoteWriteSeries{
+		Labels:  map[string]string{"__name__": "traefik_hub_servers_up", "ingress": "web", "service": "whoami@docker"},
+		Value:   2,
+		TSMilli: 120000,
+	})
+}
+
+func TestSnappyEncode(t *testing.T) {
+	for _, size := range []int{0, 1, 60, 61, 256, 257, 70000} {
+		src := bytes.Repeat([]byte{'x'}, size)
+		assert.Equal(t, src, snappyDecodeLiterals(t, snappyEncode(src)), size)
+	}
+}
+
+// snappyDecodeLiterals decodes a snappy block only made of literals.
+func snappyDecodeLiterals(t *testing.T, src []byte) []byte {
+	t.Helper()
+
+	length, n := protowire.ConsumeVarint(src)
+	require.GreaterOrEqual(t, n, 0)
+	src = src[n:]
+
+	dst := make([]byte, 0, length)
+	for len(src) > 0 {
+		tag := src[0]
+		require.Zero(t, tag&0x03, "not a literal")
+
+		l, extra := int(tag>>2), 0
+		switch l {
+		case 60:
+			l, extra = int(src[1]), 1
+		case 61:
+			l, extra = int(src[1])|int(src[2])<<8, 2
+		}
+		src = src[1+extra:]
+
+		dst = append(dst, src[:l+1]...)
+		src = src[l+1:]
+	}
+	require.Len(t, dst, int(length))
+
+	return dst
+}
+
+func decodeWriteRequest(t *testing.T, b []byte) []remoteWriteSeries {
+	t.Helper()
+
+	var res []remoteWriteSeries
+	forEachField(t, b, func(num protowire.Number, series []byte) {
+		require.Equal(t, protowire.Number(1), num)
+
+		s := remoteWriteSeries{Labels: map[string]string{}}
+		forEachField(t, series, func(num protowire.Number, v []byte) {
+			switch num {
+			case 1:
+				var name, value string
+				forEachField(t, v, func(num protowire.Number, v []byte) {
+					if num == 1 {
+						name = string(v)
+					} else {
+						value = string(v)
+					}
+				})
+				s.Labels[name] = value
+			case 2:
+				value, n := protowire.ConsumeFixed64(v[1:])
+				require.GreaterOrEqual(t, n, 0)
+				ts, n := protowire.ConsumeVarint(v[1+n+1:])
+				require.GreaterOrEqual(t, n, 0)
+
+				s.Value, s.TSMilli = math.Float64frombits(value), int64(ts)
+			}
+		})
+
+		res = append(res, s)
+	})
+
+	return res
+}
+
+// forEachField calls fn with each length delimited field of a protobuf message.
+func forEachField(t *testing.T, b []byte, fn func(num protowire.Number, v []byte)) {
+	t.Helper()
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.GreaterOrEqual(t, n, 0)
+		require.Equal(t, protowire.BytesType, typ)
+		b = b[n:]
+
+		v, n := protowire.ConsumeBytes(b)
+		require.GreaterOrEqual(t, n, 0)
+		b = b[n:]
+
+		fn(num, v)
+	}
+}
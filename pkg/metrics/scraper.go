@@ -19,10 +19,13 @@ package metrics
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	dto "github.com/prometheus/client_model/go"
-	"github.com/traefik/hub-agent-traefik/pkg/traefik"
 )
 
 // Metric names.
@@ -31,6 +34,11 @@ const (
 	MetricRequests            = "requests"
 	MetricRequestErrors       = "request_errors"
 	MetricRequestClientErrors = "request_client_errors"
+	MetricRetries             = "retries"
+	MetricServerUp            = "server_up"
+	MetricRequestBytes        = "request_bytes"
+	MetricResponseBytes       = "response_bytes"
+	MetricOpenConnections     = "open_connections"
 )
 
 // Metric represents a metric object.
@@ -46,7 +54,9 @@ type Counter struct {
 	EdgeIngress string
 	Ingress     string
 	Service     string
-	Value       uint64
+	// Series identifies the Prometheus series the metric comes from.
+	Series string
+	Value  uint64
 }
 
 // CounterFromMetric returns a counter metric from a prometheus
@@ -75,6 +85,43 @@ func (c Counter) ServiceName() string {
 	return c.Service
 }
 
+// Gauge represents a gauge metric.
+type Gauge struct {
+	Name        string
+	EdgeIngress string
+	Ingress     string
+	Service     string
+	// Series identifies the Prometheus series the metric comes from.
+	Series string
+	Value  float64
+}
+
+// GaugeFromMetric returns a gauge metric from a prometheus
+// metric.
+func GaugeFromMetric(m *dto.Metric) float64 {
+	g := m.Gauge
+	if g == nil {
+		return 0
+	}
+
+	return g.GetValue()
+}
+
+// EdgeIngressName returns the metric edge ingress name.
+func (g Gauge) EdgeIngressName() string {
+	return g.EdgeIngress
+}
+
+// IngressName returns the metric ingress name.
+func (g Gauge) IngressName() string {
+	return g.Ingress
+}
+
+// ServiceName returns the metric service name.
+func (g Gauge) ServiceName() string {
+	return g.Service
+}
+
 // Histogram represents a histogram metric.
 type Histogram struct {
 	Name        string
@@ -82,8 +129,11 @@ type Histogram struct {
 	EdgeIngress string
 	Ingress     string
 	Service     string
-	Sum         float64
-	Count       uint64
+	// Series identifies the Prometheus series the metric comes from.
+	Series  string
+	Sum     float64
+	Count   uint64
+	Buckets Buckets
 }
 
 // HistogramFromMetric returns a histogram metric from a prometheus
@@ -94,9 +144,19 @@ func HistogramFromMetric(m *dto.Metric) *Histogram {
 		return nil
 	}
 
+	buckets := make(Buckets, 0, len(hist.Bucket))
+	for _, bkt := range hist.Bucket {
+		buckets = append(buckets, Bucket{UpperBound: bkt.GetUpperBound(), Count: int64(bkt.GetCumulativeCount())})
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].UpperBound < buckets[j].UpperBound
+	})
+
 	return &Histogram{
-		Sum:   hist.GetSampleSum(),
-		Count: hist.GetSampleCount(),
+		Sum:     hist.GetSampleSum(),
+		Count:   hist.GetSampleCount(),
+		Buckets: buckets,
 	}
 }
 
@@ -115,37 +175,125 @@ func (h Histogram) ServiceName() string {
 	return h.Service
 }
 
+// MetricsGetter gets the Prometheus metrics of a Traefik instance.
+type MetricsGetter interface {
+	GetMetrics(ctx context.Context) ([]*dto.MetricFamily, error)
+}
+
+// Target is a Traefik instance to scrape.
+type Target struct {
+	// Name identifies the target, e.g. its address.
+	Name   string
+	Client MetricsGetter
+}
+
+// ScrapeResult holds the metrics scraped from each target, and the errors of the targets which couldn't be scraped.
+type ScrapeResult struct {
+	Metrics map[string][]Metric
+	Errors  map[string]error
+}
+
 // Scraper scrapes metrics from Prometheus.
 type Scraper struct {
-	traefik       *traefik.Client
 	traefikParser TraefikParser
+
+	targetsMu sync.Mutex
+	targets   []Target
 }
 
-// NewScraper returns a scraper instance with parser p.
-func NewScraper(traefikClient *traefik.Client) *Scraper {
+// NewScraper returns a scraper instance scraping the given targets.
+func NewScraper(targets ...Target) *Scraper {
 	return &Scraper{
-		traefik:       traefikClient,
 		traefikParser: NewTraefikParser(),
+		targets:       targets,
+	}
+}
+
+// SetTargets replaces the targets to scrape, e.g. when Traefik instances are added or removed.
+func (s *Scraper) SetTargets(targets []Target) {
+	s.targetsMu.Lock()
+	defer s.targetsMu.Unlock()
+
+	s.targets = targets
+}
+
+// Targets returns the targets to scrape.
+func (s *Scraper) Targets() []Target {
+	s.targetsMu.Lock()
+	defer s.targetsMu.Unlock()
+
+	return append([]Target(nil), s.targets...)
+}
+
+// Scrape returns metrics scraped from all targets, which are scraped concurrently. An error is returned only if no
+// target could be scraped, the errors of individual targets are reported in the result.
+func (s *Scraper) Scrape(ctx context.Context) (ScrapeResult, error) {
+	targets := s.Targets()
+
+	res := ScrapeResult{
+		Metrics: make(map[string][]Metric, len(targets)),
+		Errors:  make(map[string]error),
+	}
+	if len(targets) == 0 {
+		return res, errors.New("no target to scrape")
 	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, target := range targets {
+		wg.Add(1)
+
+		go func(target Target) {
+			defer wg.Done()
+
+			mtrcs, err := s.scrapeTarget(ctx, target)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				res.Errors[target.Name] = err
+				return
+			}
+			res.Metrics[target.Name] = mtrcs
+		}(target)
+	}
+	wg.Wait()
+
+	if len(res.Metrics) == 0 {
+		names := make([]string, 0, len(res.Errors))
+		for name := range res.Errors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		errs := make([]string, 0, len(names))
+		for _, name := range names {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, res.Errors[name]))
+		}
+
+		return res, fmt.Errorf("unable to get metrics from any target: %s", strings.Join(errs, ", "))
+	}
+
+	return res, nil
 }
 
-// Scrape returns metrics scraped from all targets.
-func (s *Scraper) Scrape(ctx context.Context) ([]Metric, error) {
+func (s *Scraper) scrapeTarget(ctx context.Context, target Target) ([]Metric, error) {
 	// This is a naive approach and should be dealt with
 	// as an iterator later to control the amount of RAM
 	// used while scraping many targets with many services.
 	// e.g. 100 pods * 4000 services * 4 metrics = bad news bears (1.6 million)
 
-	p := s.traefikParser
-	var m []Metric
-
-	raw, err := s.traefik.GetMetrics(ctx)
+	raw, err := target.Client.GetMetrics(ctx)
 	if err != nil {
-		return []Metric{}, fmt.Errorf("unable to get metrics from target: %w", err)
+		return nil, fmt.Errorf("unable to get metrics from target: %w", err)
 	}
 
+	var m []Metric
 	for _, v := range raw {
-		m = append(m, p.Parse(v)...)
+		m = append(m, s.traefikParser.Parse(v)...)
 	}
 
 	return m, nil
@@ -21,6 +21,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -63,17 +64,133 @@ func setupTraefikClient(t *testing.T) *traefik.Client {
 
 func TestScraper_ScrapeTraefik(t *testing.T) {
 	traefikClient := setupTraefikClient(t)
-	s := metrics.NewScraper(traefikClient)
+	s := metrics.NewScraper(metrics.Target{Name: "traefik", Client: traefikClient})
 
-	got, err := s.Scrape(context.Background())
+	res, err := s.Scrape(context.Background())
 	require.NoError(t, err)
+	assert.Empty(t, res.Errors)
+
+	got := res.Metrics["traefik"]
+
+	assert.Contains(t, got, &metrics.Counter{
+		Name:        metrics.MetricRequests,
+		EdgeIngress: "default-myIngressRoute-6f97418635c7e18853da",
+		Series:      `code="200",method="GET",protocol="http",router="default-myIngressRoute-6f97418635c7e18853da@hub",service="default-myIngressRoute-6f97418635c7e18853da@hub"`,
+		Value:       1,
+	})
+
+	// Series are checked above, ignore them to keep the following assertions readable.
+	got = withoutSeries(got)
+
+	buckets := metrics.Buckets{
+		{UpperBound: 0.1, Count: 1},
+		{UpperBound: 0.3, Count: 1},
+		{UpperBound: 1.2, Count: 1},
+		{UpperBound: 5, Count: 1},
+		{UpperBound: math.Inf(1), Count: 1},
+	}
 
 	// router
-	assert.Contains(t, got, &metrics.Histogram{Name: metrics.MetricRequestDuration, EdgeIngress: "myIngress-default-example-com", Sum: 0.0137623, Count: 1})
-	assert.Contains(t, got, &metrics.Histogram{Name: metrics.MetricRequestDuration, EdgeIngress: "default-myIngressRoute-6f97418635c7e18853da", Sum: 0.0216373, Count: 1})
+	assert.Contains(t, got, &metrics.Histogram{Name: metrics.MetricRequestDuration, EdgeIngress: "myIngress-default-example-com", Sum: 0.0137623, Count: 1, Buckets: buckets})
+	assert.Contains(t, got, &metrics.Histogram{Name: metrics.MetricRequestDuration, EdgeIngress: "default-myIngressRoute-6f97418635c7e18853da", Sum: 0.0216373, Count: 1, Buckets: buckets})
 	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricRequests, EdgeIngress: "myIngress-default-example-com", Value: 2})
 	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricRequestClientErrors, EdgeIngress: "myIngress-default-example-com", Value: 4})
 	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricRequestErrors, EdgeIngress: "myIngress-default-example-com", Value: 6})
 	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricRequests, EdgeIngress: "default-myIngressRoute-6f97418635c7e18853da", Value: 1})
-	require.Len(t, got, 8)
+
+	// service
+	assert.Contains(t, got, &metrics.Histogram{Name: metrics.MetricRequestDuration, Service: "default-whoami-80@docker", Sum: 0.021072671000000005, Count: 12, Buckets: countBuckets(12)})
+	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricRequests, Service: "default-whoami-sdfsdfsdsd@docker", Value: 12})
+	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricRequests, Service: "default-whoami-80@docker", Value: 14})
+	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricRequestClientErrors, Service: "default-whoami-80@docker", Value: 14})
+	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricRequestErrors, Service: "default-whoami2-80@docker", Value: 16})
+	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricRetries, Service: "default-whoami-80@docker", Value: 3})
+	assert.Contains(t, got, &metrics.Gauge{Name: metrics.MetricServerUp, Service: "default-whoami-80@docker", Value: 1})
+	assert.Contains(t, got, &metrics.Gauge{Name: metrics.MetricServerUp, Service: "default-whoami-80@docker", Value: 0})
+	for _, m := range got {
+		assert.NotEqual(t, "api@internal", m.ServiceName())
+	}
+
+	// entrypoint
+	assert.Contains(t, got, &metrics.Histogram{Name: metrics.MetricRequestDuration, Ingress: "web", Sum: 0.023270587, Count: 12, Buckets: countBuckets(12)})
+	assert.Contains(t, got, &metrics.Histogram{Name: metrics.MetricRequestDuration, Ingress: "traefik", Sum: 0.000816351, Count: 7, Buckets: countBuckets(7)})
+	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricRequests, Ingress: "traefik", Value: 234})
+	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricRequests, Ingress: "web", Value: 9})
+	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricRequestClientErrors, Ingress: "web", Value: 9})
+
+	// bytes and open connections
+	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricResponseBytes, EdgeIngress: "myIngress-default-example-com", Value: 2048})
+	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricRequestBytes, Service: "default-whoami-80@docker", Value: 1024})
+	assert.Contains(t, got, &metrics.Counter{Name: metrics.MetricResponseBytes, Service: "default-whoami-80@docker", Value: 4096})
+	assert.Contains(t, got, &metrics.Gauge{Name: metrics.MetricOpenConnections, Service: "default-whoami-80@docker", Value: 0})
+	assert.Contains(t, got, &metrics.Gauge{Name: metrics.MetricOpenConnections, Ingress: "traefik", Value: 1})
+	assert.Contains(t, got, &metrics.Gauge{Name: metrics.MetricOpenConnections, Ingress: "web", Value: 0})
+
+	require.Len(t, got, 40)
+}
+
+func TestScraper_ScrapeSeveralTargets(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		http.Error(rw, "forbidden", http.StatusForbidden)
+	}))
+	t.Cleanup(srv.Close)
+
+	downClient, err := traefik.NewClient(srv.URL, true, "", "", "")
+	require.NoError(t, err)
+
+	s := metrics.NewScraper(
+		metrics.Target{Name: "traefik-1", Client: setupTraefikClient(t)},
+		metrics.Target{Name: "traefik-2", Client: setupTraefikClient(t)},
+		metrics.Target{Name: "traefik-3", Client: downClient},
+	)
+
+	// Targets being down are reported without failing the scrape.
+	res, err := s.Scrape(context.Background())
+	require.NoError(t, err)
+
+	assert.Len(t, res.Metrics["traefik-1"], 40)
+	assert.Len(t, res.Metrics["traefik-2"], 40)
+	assert.NotContains(t, res.Metrics, "traefik-3")
+	require.Contains(t, res.Errors, "traefik-3")
+	assert.Contains(t, res.Errors["traefik-3"].Error(), "403")
+
+	// The scrape fails when no target is up.
+	s.SetTargets([]metrics.Target{{Name: "traefik-3", Client: downClient}})
+
+	res, err = s.Scrape(context.Background())
+	require.Error(t, err)
+	assert.Empty(t, res.Metrics)
+	assert.Len(t, res.Errors, 1)
+}
+
+func withoutSeries(mtrcs []metrics.Metric) []metrics.Metric {
+	res := make([]metrics.Metric, 0, len(mtrcs))
+	for _, mtrc := range mtrcs {
+		switch val := mtrc.(type) {
+		case *metrics.Counter:
+			c := *val
+			c.Series = ""
+			res = append(res, &c)
+		case *metrics.Histogram:
+			h := *val
+			h.Series = ""
+			res = append(res, &h)
+		case *metrics.Gauge:
+			g := *val
+			g.Series = ""
+			res = append(res, &g)
+		}
+	}
+
+	return res
+}
+
+func countBuckets(count int64) metrics.Buckets {
+	return metrics.Buckets{
+		{UpperBound: 0.1, Count: count},
+		{UpperBound: 0.3, Count: count},
+		{UpperBound: 1.2, Count: count},
+		{UpperBound: 5, Count: count},
+		{UpperBound: math.Inf(1), Count: count},
+	}
 }
@@ -0,0 +1,199 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+// maxMissedScrapes is the number of consecutive scrapes a series can be missing from before being forgotten. Until
+// then, a series coming back is compared to its last known value rather than being counted from zero.
+const maxMissedScrapes = 5
+
+// seriesKey identifies a scraped series.
+type seriesKey struct {
+	SetKey
+
+	Name   string
+	Series string
+}
+
+type trackedSeries struct {
+	metric Metric
+	missed int
+}
+
+// scrapeDelta holds what changed between two scrapes.
+type scrapeDelta struct {
+	// Metrics holds the increase of counters and histograms since the previous scrape, and the current gauges.
+	Metrics []Metric
+	// Reset holds the sets of which at least one series has been reset since the previous scrape, e.g. because
+	// Traefik restarted. The traffic between the previous scrape and the reset is unknown.
+	Reset map[SetKey]struct{}
+	// Vanished holds the sets which were in the previous scrape and are no longer.
+	Vanished []SetKey
+}
+
+// seriesTracker computes the increase of scraped counters and histograms between scrapes, detecting resets for
+// each series.
+type seriesTracker struct {
+	series map[seriesKey]*trackedSeries
+	sets   map[SetKey]struct{}
+}
+
+// newSeriesTracker returns a new seriesTracker.
+func newSeriesTracker() *seriesTracker {
+	return &seriesTracker{}
+}
+
+// Started reports whether the tracker has a reference scrape to compute deltas from.
+func (t *seriesTracker) Started() bool {
+	return t.series != nil
+}
+
+// Reset forgets the reference scrape: the next call to Delta will establish a new one.
+func (t *seriesTracker) Reset() {
+	t.series = nil
+	t.sets = nil
+}
+
+// Sets returns the sets of the last scrape.
+func (t *seriesTracker) Sets() []SetKey {
+	sets := make([]SetKey, 0, len(t.sets))
+	for key := range t.sets {
+		sets = append(sets, key)
+	}
+
+	return sets
+}
+
+// Delta returns the difference between the given scraped metrics and the previous ones. The first call, or the first
+// one after a Reset, only establishes the reference and returns an empty delta.
+func (t *seriesTracker) Delta(mtrcs []Metric) scrapeDelta {
+	started := t.Started()
+	if !started {
+		t.series = make(map[seriesKey]*trackedSeries)
+	}
+
+	delta := scrapeDelta{Reset: make(map[SetKey]struct{})}
+	sets := make(map[SetKey]struct{})
+	seen := make(map[seriesKey]struct{}, len(mtrcs))
+
+	for _, mtrc := range mtrcs {
+		key, ok := toSeriesKey(mtrc)
+		if !ok {
+			continue
+		}
+
+		seen[key] = struct{}{}
+		sets[key.SetKey] = struct{}{}
+
+		prev, known := t.series[key]
+		t.series[key] = &trackedSeries{metric: mtrc}
+
+		if !started {
+			continue
+		}
+
+		if !known {
+			// The series appeared since the previous scrape: Traefik creates series on their first observation,
+			// so all of their value is new.
+			delta.Metrics = append(delta.Metrics, mtrc)
+			continue
+		}
+
+		d, reset := seriesDelta(mtrc, prev.metric)
+		if reset {
+			delta.Reset[key.SetKey] = struct{}{}
+		}
+
+		delta.Metrics = append(delta.Metrics, d)
+	}
+
+	for key, series := range t.series {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+
+		series.missed++
+		if series.missed > maxMissedScrapes {
+			delete(t.series, key)
+		}
+	}
+
+	if started {
+		for key := range t.sets {
+			if _, ok := sets[key]; !ok {
+				delta.Vanished = append(delta.Vanished, key)
+			}
+		}
+	}
+	t.sets = sets
+
+	return delta
+}
+
+func toSeriesKey(mtrc Metric) (seriesKey, bool) {
+	key := seriesKey{
+		SetKey: SetKey{EdgeIngress: mtrc.EdgeIngressName(), Ingress: mtrc.IngressName(), Service: mtrc.ServiceName()},
+	}
+
+	switch val := mtrc.(type) {
+	case *Counter:
+		key.Name, key.Series = val.Name, val.Series
+	case *Histogram:
+		key.Name, key.Series = val.Name, val.Series
+	case *Gauge:
+		key.Name, key.Series = val.Name, val.Series
+	default:
+		return seriesKey{}, false
+	}
+
+	return key, true
+}
+
+// seriesDelta returns the increase of cur since prev, and whether the series has been reset in between, in which
+// case the increase is counted from zero.
+func seriesDelta(cur, prev Metric) (Metric, bool) {
+	switch val := cur.(type) {
+	case *Counter:
+		prevCounter, ok := prev.(*Counter)
+		if !ok || val.Value < prevCounter.Value {
+			return val, true
+		}
+
+		d := *val
+		d.Value -= prevCounter.Value
+		return &d, false
+
+	case *Histogram:
+		if val.Relative {
+			return val, false
+		}
+
+		prevHist, ok := prev.(*Histogram)
+		if !ok || val.Count < prevHist.Count || val.Sum < prevHist.Sum {
+			return val, true
+		}
+
+		d := *val
+		d.Count -= prevHist.Count
+		d.Sum -= prevHist.Sum
+		d.Buckets = val.Buckets.Sub(prevHist.Buckets)
+		return &d, false
+
+	default:
+		return cur, false
+	}
+}
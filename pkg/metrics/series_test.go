@@ -0,0 +1,158 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package metrics
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesTracker_Delta(t *testing.T) {
+	tracker := newSeriesTracker()
+
+	delta := tracker.Delta([]Metric{
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=200", Value: 10},
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=404", Value: 5},
+		&Histogram{Name: MetricRequestDuration, EdgeIngress: "ing", Series: "code=200", Sum: 2, Count: 10},
+	})
+	assert.Empty(t, delta.Metrics)
+	assert.Empty(t, delta.Reset)
+	assert.Empty(t, delta.Vanished)
+
+	delta = tracker.Delta([]Metric{
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=200", Value: 15},
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=404", Value: 5},
+		&Histogram{Name: MetricRequestDuration, EdgeIngress: "ing", Series: "code=200", Sum: 3, Count: 15},
+		&Gauge{Name: MetricOpenConnections, Ingress: "web", Series: "method=GET", Value: 4},
+	})
+	assert.ElementsMatch(t, []Metric{
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=200", Value: 5},
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=404", Value: 0},
+		&Histogram{Name: MetricRequestDuration, EdgeIngress: "ing", Series: "code=200", Sum: 1, Count: 5},
+		&Gauge{Name: MetricOpenConnections, Ingress: "web", Series: "method=GET", Value: 4},
+	}, delta.Metrics)
+	assert.Empty(t, delta.Reset)
+	assert.Empty(t, delta.Vanished)
+}
+
+func TestSeriesTracker_DeltaPartialReset(t *testing.T) {
+	tracker := newSeriesTracker()
+
+	tracker.Delta([]Metric{
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=200", Value: 100},
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=500", Value: 10},
+		&Counter{Name: MetricRequests, EdgeIngress: "other", Series: "code=200", Value: 10},
+		&Histogram{Name: MetricRequestDuration, EdgeIngress: "other", Series: "code=200", Sum: 5, Count: 10},
+	})
+
+	// Only the 500 series of "ing" and the histogram of "other" are reset: the total number of requests of "ing"
+	// still grows, which used to hide the reset.
+	delta := tracker.Delta([]Metric{
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=200", Value: 120},
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=500", Value: 2},
+		&Counter{Name: MetricRequests, EdgeIngress: "other", Series: "code=200", Value: 12},
+		&Histogram{Name: MetricRequestDuration, EdgeIngress: "other", Series: "code=200", Sum: 1, Count: 2},
+	})
+	assert.ElementsMatch(t, []Metric{
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=200", Value: 20},
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=500", Value: 2},
+		&Counter{Name: MetricRequests, EdgeIngress: "other", Series: "code=200", Value: 2},
+		&Histogram{Name: MetricRequestDuration, EdgeIngress: "other", Series: "code=200", Sum: 1, Count: 2},
+	}, delta.Metrics)
+	assert.Equal(t, map[SetKey]struct{}{
+		{EdgeIngress: "ing"}:   {},
+		{EdgeIngress: "other"}: {},
+	}, delta.Reset)
+
+	sets := Aggregate(delta.Metrics)
+	assert.Equal(t, int64(22), sets[SetKey{EdgeIngress: "ing"}].Requests)
+}
+
+func TestSeriesTracker_DeltaAppearingAndVanishingSeries(t *testing.T) {
+	tracker := newSeriesTracker()
+
+	tracker.Delta([]Metric{
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=200", Value: 10},
+		&Counter{Name: MetricRequests, EdgeIngress: "gone", Series: "code=200", Value: 50},
+	})
+
+	// A new series is counted from zero, and a set without series anymore is reported as vanished.
+	delta := tracker.Delta([]Metric{
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=200", Value: 10},
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=404", Value: 3},
+	})
+	assert.ElementsMatch(t, []Metric{
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=200", Value: 0},
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=404", Value: 3},
+	}, delta.Metrics)
+	assert.Equal(t, []SetKey{{EdgeIngress: "gone"}}, delta.Vanished)
+	assert.Empty(t, delta.Reset)
+
+	// A series coming back shortly is compared to its last known value.
+	delta = tracker.Delta([]Metric{
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=200", Value: 10},
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=404", Value: 3},
+		&Counter{Name: MetricRequests, EdgeIngress: "gone", Series: "code=200", Value: 55},
+	})
+	assert.Contains(t, delta.Metrics, &Counter{Name: MetricRequests, EdgeIngress: "gone", Series: "code=200", Value: 5})
+	assert.Empty(t, delta.Vanished)
+
+	// After too many missed scrapes, the series is forgotten and counted from zero when it comes back.
+	for i := 0; i <= maxMissedScrapes; i++ {
+		tracker.Delta([]Metric{
+			&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=200", Value: 10},
+		})
+	}
+	delta = tracker.Delta([]Metric{
+		&Counter{Name: MetricRequests, EdgeIngress: "ing", Series: "code=200", Value: 10},
+		&Counter{Name: MetricRequests, EdgeIngress: "gone", Series: "code=200", Value: 60},
+	})
+	require.Contains(t, delta.Metrics, &Counter{Name: MetricRequests, EdgeIngress: "gone", Series: "code=200", Value: 60})
+	assert.Empty(t, delta.Reset)
+}
+
+func TestSeriesTracker_DeltaHistogramBuckets(t *testing.T) {
+	tracker := newSeriesTracker()
+
+	tracker.Delta([]Metric{
+		&Histogram{Name: MetricRequestDuration, Service: "svc", Series: "code=200", Sum: 1, Count: 10, Buckets: Buckets{
+			{UpperBound: 0.1, Count: 8},
+			{UpperBound: math.Inf(1), Count: 10},
+		}},
+	})
+
+	delta := tracker.Delta([]Metric{
+		&Histogram{Name: MetricRequestDuration, Service: "svc", Series: "code=200", Sum: 3, Count: 30, Buckets: Buckets{
+			{UpperBound: 0.1, Count: 18},
+			{UpperBound: math.Inf(1), Count: 30},
+		}},
+	})
+
+	got := Aggregate(delta.Metrics)[SetKey{Service: "svc"}].ToDataPoint(60)
+
+	assert.Equal(t, int64(20), got.ResponseTimeCount)
+	assert.Equal(t, Buckets{
+		{UpperBound: 0.1, Count: 10},
+		{UpperBound: math.Inf(1), Count: 20},
+	}, got.ResponseTimeBuckets)
+	assert.InDelta(t, 0.1, got.ResponseTimeP50, 1e-9)
+	assert.InDelta(t, 0.1, got.ResponseTimeP95, 1e-9)
+}
@@ -19,16 +19,22 @@ package metrics
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"sort"
 	"sync"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 type tableInfo struct {
-	Name     string
-	MinCount int
-	RollUp   time.Duration
-	Next     string
+	Name string
+	// Granularity is the period covered by each data point of the table.
+	Granularity time.Duration
+	MinCount    int
+	RollUp      time.Duration
+	Next        string
 }
 
 type tableKey struct {
@@ -56,6 +62,16 @@ type Store struct {
 	data  map[string]map[tableKey]DataPoints
 	marks map[string]WaterMarks
 
+	// maxPoints is the maximum number of data points kept in each table, 0 meaning unlimited, and dropped the number
+	// of data points of each table evicted before being sent.
+	maxPoints map[string]int
+	dropped   map[string]int64
+
+	// journal persists the changes of the store when it is backed by a directory.
+	journal *journal
+	// restored is true when the store has been rebuilt from its journal.
+	restored bool
+
 	// NowFunc is the function used to test time.
 	nowFunc func() time.Time
 }
@@ -63,10 +79,10 @@ type Store struct {
 // NewStore returns metrics store.
 func NewStore() *Store {
 	tables := []tableInfo{
-		{Name: "1m", MinCount: 10, RollUp: 10 * time.Minute, Next: "10m"},
-		{Name: "10m", MinCount: 6, RollUp: time.Hour, Next: "1h"},
-		{Name: "1h", MinCount: 24, RollUp: 24 * time.Hour, Next: "1d"},
-		{Name: "1d", MinCount: 30, RollUp: 30 * 24 * time.Hour},
+		{Name: "1m", Granularity: time.Minute, MinCount: 10, RollUp: 10 * time.Minute, Next: "10m"},
+		{Name: "10m", Granularity: 10 * time.Minute, MinCount: 6, RollUp: time.Hour, Next: "1h"},
+		{Name: "1h", Granularity: time.Hour, MinCount: 24, RollUp: 24 * time.Hour, Next: "1d"},
+		{Name: "1d", Granularity: 24 * time.Hour, MinCount: 30, RollUp: 30 * 24 * time.Hour},
 	}
 
 	tbls := make(map[string]map[tableKey]DataPoints, len(tables))
@@ -77,13 +93,129 @@ func NewStore() *Store {
 	}
 
 	return &Store{
-		tables:  tables,
-		data:    tbls,
-		marks:   marks,
-		nowFunc: time.Now,
+		tables:    tables,
+		data:      tbls,
+		marks:     marks,
+		maxPoints: map[string]int{},
+		dropped:   map[string]int64{},
+		nowFunc:   time.Now,
 	}
 }
 
+// SetMaxPoints sets the maximum number of data points kept in a table, 0 meaning unlimited. Once a table is full, its
+// oldest data points are evicted when the store is cleaned up, whether they have been sent or not.
+func (s *Store) SetMaxPoints(tbl string, maxPoints int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.data[tbl]; !ok {
+		return fmt.Errorf("table %q does not exist", tbl)
+	}
+	if maxPoints < 0 {
+		return fmt.Errorf("invalid maximum number of points %d for table %q", maxPoints, tbl)
+	}
+
+	s.maxPoints[tbl] = maxPoints
+
+	return nil
+}
+
+// HasTable reports whether the store has the given table.
+func (s *Store) HasTable(tbl string) bool {
+	_, ok := s.data[tbl]
+	return ok
+}
+
+// TableFor returns the finest table keeping data points over the given duration, or the coarsest table when none
+// does.
+func (s *Store) TableFor(d time.Duration) string {
+	for _, info := range s.tables {
+		if info.Granularity*time.Duration(info.MinCount) >= d {
+			return info.Name
+		}
+	}
+
+	return s.tables[len(s.tables)-1].Name
+}
+
+// BufferStatus describes the data points held by a table.
+type BufferStatus struct {
+	Points int `json:"points"`
+	// Unsent is the number of data points not sent yet.
+	Unsent int `json:"unsent"`
+	// MaxPoints is the maximum number of data points kept in the table, 0 meaning unlimited.
+	MaxPoints int `json:"maxPoints"`
+	// Dropped is the number of data points evicted before being sent since the agent started.
+	Dropped int64 `json:"dropped"`
+}
+
+// BufferStatus returns the status of each table of the store.
+func (s *Store) BufferStatus() map[string]BufferStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status := make(map[string]BufferStatus, len(s.tables))
+	for _, info := range s.tables {
+		tblStatus := BufferStatus{
+			MaxPoints: s.maxPoints[info.Name],
+			Dropped:   s.dropped[info.Name],
+		}
+		for key, pnts := range s.data[info.Name] {
+			tblStatus.Points += len(pnts)
+			tblStatus.Unsent += len(pnts) - s.marks[info.Name][key]
+		}
+
+		status[info.Name] = tblStatus
+	}
+
+	return status
+}
+
+// NewPersistentStore returns a metrics store persisting its data points and water marks in the given directory, so
+// that unsent data points survive restarts. The data of a previous run, if any, is loaded.
+func NewPersistentStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create data directory: %w", err)
+	}
+
+	s := NewStore()
+	path := filepath.Join(dir, journalFile)
+
+	var records int
+	err := readJournal(path, func(rec journalRecord) {
+		s.apply(rec)
+		records++
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.restored = records > 0
+
+	s.journal = &journal{path: path}
+	if err = s.journal.compact(s.snapshot()); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Restored reports whether the store has been rebuilt from the data of a previous run.
+func (s *Store) Restored() bool {
+	return s.restored
+}
+
+// Close closes the store.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+
+	return s.journal.close()
+}
+
 // Populate populates the store with initial data points.
 func (s *Store) Populate(tbl string, grps []DataPointGroup) error {
 	s.mu.Lock()
@@ -106,6 +238,8 @@ func (s *Store) Populate(tbl string, grps []DataPointGroup) error {
 		})
 		table[key] = dataPoints
 		s.marks[tbl][key] = len(dataPoints)
+
+		s.persist(journalRecord{Op: opSet, Table: tbl, Key: key, Points: dataPoints, Mark: len(dataPoints)})
 	}
 
 	return nil
@@ -118,12 +252,17 @@ func (s *Store) Insert(svcs map[SetKey]DataPoint) {
 
 	table := s.data["1m"]
 
+	recs := make([]journalRecord, 0, len(svcs))
 	for k, pnt := range svcs {
 		key := tableKey(k)
 		pnts := table[key]
 		pnts = append(pnts, pnt)
 		table[key] = pnts
+
+		recs = append(recs, journalRecord{Op: opAppend, Table: "1m", Key: key, Points: DataPoints{pnt}})
 	}
+
+	s.persist(recs...)
 }
 
 // ForEachFunc represents a function that will be called while iterating over a table.
@@ -172,17 +311,21 @@ func (s *Store) ForEachUnmarked(tbl string, fn ForEachFunc) WaterMarks {
 	return newMarks
 }
 
-// CommitMarks sets the new low water marks for a table.
+// CommitMarks sets the new low water marks of the given rows of a table.
 func (s *Store) CommitMarks(tbl string, marks WaterMarks) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, ok := s.marks[tbl]
+	tblMarks, ok := s.marks[tbl]
 	if !ok {
 		return
 	}
 
-	s.marks[tbl] = marks
+	for key, mark := range marks {
+		tblMarks[key] = mark
+	}
+
+	s.persist(journalRecord{Op: opMarks, Table: tbl, Marks: marks})
 }
 
 // RollUp creates combines data points.
@@ -231,6 +374,7 @@ func (s *Store) RollUp() {
 
 		// Insert new computed points into dest.
 		table := s.data[dest]
+		var recs []journalRecord
 		for key, tsPnts := range res {
 			for ts, pnts := range tsPnts {
 				pnt := pnts.Aggregate()
@@ -239,12 +383,17 @@ func (s *Store) RollUp() {
 				destPnts := table[key]
 				destPnts = append(destPnts, pnt)
 				table[key] = destPnts
+
+				recs = append(recs, journalRecord{Op: opAppend, Table: dest, Key: key, Points: DataPoints{pnt}})
 			}
 		}
+
+		s.persist(recs...)
 	}
 }
 
-// Cleanup removes old data points no longer needed for roll up.
+// Cleanup removes old data points no longer needed for roll up, and evicts the oldest data points of the tables
+// holding more than their maximum number of points.
 func (s *Store) Cleanup() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -268,6 +417,190 @@ func (s *Store) Cleanup() {
 			pnts = pnts[0 : len(pnts)-idx]
 			s.data[tbl][k] = pnts
 			s.marks[tbl][k] = mark - idx
+
+			s.persist(journalRecord{Op: opTrim, Table: tbl, Key: k, Count: idx})
+		}
+
+		s.evict(tbl)
+	}
+
+	s.compactJournal()
+}
+
+// evict removes the oldest data points of a table until it holds no more than its maximum number of points.
+func (s *Store) evict(tbl string) {
+	maxPoints := s.maxPoints[tbl]
+	if maxPoints == 0 {
+		return
+	}
+
+	table := s.data[tbl]
+
+	var total int
+	for _, pnts := range table {
+		total += len(pnts)
+	}
+	if total <= maxPoints {
+		return
+	}
+	toEvict := total - maxPoints
+
+	// Find the timestamp of the newest evicted point, and how many of the points with this timestamp are evicted.
+	tss := make([]int64, 0, total)
+	for _, pnts := range table {
+		for _, pnt := range pnts {
+			tss = append(tss, pnt.Timestamp)
+		}
+	}
+	sort.Slice(tss, func(i, j int) bool { return tss[i] < tss[j] })
+
+	cutoff := tss[toEvict-1]
+	atCutoff := toEvict - sort.Search(len(tss), func(i int) bool { return tss[i] >= cutoff })
+
+	keys := make([]tableKey, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].EdgeIngress != keys[j].EdgeIngress {
+			return keys[i].EdgeIngress < keys[j].EdgeIngress
+		}
+		if keys[i].Ingress != keys[j].Ingress {
+			return keys[i].Ingress < keys[j].Ingress
+		}
+		return keys[i].Service < keys[j].Service
+	})
+
+	var dropped int
+	for _, key := range keys {
+		pnts := table[key]
+
+		var idx int
+		for idx < len(pnts) && pnts[idx].Timestamp < cutoff {
+			idx++
+		}
+		for atCutoff > 0 && idx < len(pnts) && pnts[idx].Timestamp == cutoff {
+			idx++
+			atCutoff--
+		}
+		if idx == 0 {
+			continue
+		}
+
+		mark := s.marks[tbl][key]
+		if idx > mark {
+			dropped += idx - mark
+			mark = 0
+		} else {
+			mark -= idx
+		}
+
+		if idx == len(pnts) {
+			delete(table, key)
+			delete(s.marks[tbl], key)
+		} else {
+			// The remaining points are copied, so that the memory held by the evicted ones is released.
+			table[key] = append(DataPoints(nil), pnts[idx:]...)
+			s.marks[tbl][key] = mark
+		}
+
+		s.persist(journalRecord{Op: opTrim, Table: tbl, Key: key, Count: idx})
+	}
+
+	s.dropped[tbl] += int64(dropped)
+
+	log.Warn().
+		Str("table", tbl).
+		Int("max_points", maxPoints).
+		Int("evicted", toEvict).
+		Int("dropped", dropped).
+		Msg("Metrics table full, oldest data points evicted")
+}
+
+// persist appends the given changes to the journal, if any. Persistence errors are only logged, the in-memory store
+// remaining the reference.
+func (s *Store) persist(recs ...journalRecord) {
+	if s.journal == nil || len(recs) == 0 {
+		return
+	}
+
+	if err := s.journal.write(recs...); err != nil {
+		log.Error().Err(err).Str("path", s.journal.path).Msg("Unable to persist metrics")
+	}
+}
+
+// compactJournal rewrites the journal once it holds more records than needed to rebuild the store.
+func (s *Store) compactJournal() {
+	if s.journal == nil {
+		return
+	}
+
+	var count int
+	for _, table := range s.data {
+		for _, pnts := range table {
+			count += len(pnts) + 1
+		}
+	}
+	if s.journal.records <= count {
+		return
+	}
+
+	if err := s.journal.compact(s.snapshot()); err != nil {
+		log.Error().Err(err).Str("path", s.journal.path).Msg("Unable to compact metrics journal")
+	}
+}
+
+// snapshot returns the records rebuilding the store.
+func (s *Store) snapshot() []journalRecord {
+	var recs []journalRecord
+	for tbl, table := range s.data {
+		for key, pnts := range table {
+			recs = append(recs, journalRecord{Op: opSet, Table: tbl, Key: key, Points: pnts, Mark: s.marks[tbl][key]})
+		}
+	}
+
+	return recs
+}
+
+// apply applies a change read from the journal.
+func (s *Store) apply(rec journalRecord) {
+	table, ok := s.data[rec.Table]
+	if !ok {
+		return
+	}
+
+	switch rec.Op {
+	case opSet:
+		if len(rec.Points) == 0 {
+			delete(table, rec.Key)
+			delete(s.marks[rec.Table], rec.Key)
+			break
+		}
+		table[rec.Key] = rec.Points
+		if rec.Mark == 0 {
+			delete(s.marks[rec.Table], rec.Key)
+			break
+		}
+		s.marks[rec.Table][rec.Key] = rec.Mark
+	case opAppend:
+		table[rec.Key] = append(table[rec.Key], rec.Points...)
+	case opMarks:
+		for key, mark := range rec.Marks {
+			s.marks[rec.Table][key] = mark
+		}
+	case opTrim:
+		pnts := table[rec.Key]
+		if rec.Count >= len(pnts) {
+			delete(table, rec.Key)
+			delete(s.marks[rec.Table], rec.Key)
+			break
+		}
+		table[rec.Key] = append(DataPoints(nil), pnts[rec.Count:]...)
+
+		mark := s.marks[rec.Table][rec.Key] - rec.Count
+		if mark < 0 {
+			mark = 0
 		}
+		s.marks[rec.Table][rec.Key] = mark
 	}
 }
@@ -18,11 +18,15 @@ along with this program. If not, see <https://www.gnu.org/licenses/>.
 package metrics
 
 import (
+	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestStore_PopulateAndForEach(t *testing.T) {
@@ -127,6 +131,44 @@ func TestStore_RollUp(t *testing.T) {
 	})
 }
 
+func TestStore_RollUpBytesAndOpenConnections(t *testing.T) {
+	now := time.Date(2021, 1, 1, 8, 21, 0, 0, time.UTC)
+
+	store := NewStore()
+	store.nowFunc = func() time.Time {
+		return now
+	}
+
+	start := time.Date(2021, 1, 1, 8, 0, 0, 0, time.UTC)
+	var pnts DataPoints
+	for i := 0; i < 10; i++ {
+		pnts = append(pnts, DataPoint{
+			Timestamp:          start.Add(time.Duration(i) * time.Minute).Unix(),
+			Seconds:            60,
+			RequestBytes:       100,
+			ResponseBytes:      1000,
+			AvgOpenConnections: float64(i),
+			MaxOpenConnections: int64(i),
+		})
+	}
+	require.NoError(t, store.Populate("1m", []DataPointGroup{{Ingress: "web", DataPoints: pnts}}))
+
+	store.RollUp()
+
+	var got DataPoints
+	store.ForEach("10m", func(_, _, _ string, pnts DataPoints) {
+		got = pnts
+	})
+
+	require.Len(t, got, 1)
+	assert.Equal(t, start.Unix(), got[0].Timestamp)
+	assert.Equal(t, int64(1000), got[0].RequestBytes)
+	assert.Equal(t, int64(10000), got[0].ResponseBytes)
+	assert.InDelta(t, 1.6667, got[0].RequestBytesPerS, 0.0001)
+	assert.Equal(t, 4.5, got[0].AvgOpenConnections)
+	assert.Equal(t, int64(9), got[0].MaxOpenConnections)
+}
+
 func TestStore_Cleanup(t *testing.T) {
 	now := time.Now().Truncate(time.Hour).Add(-1 * time.Minute)
 
@@ -202,3 +244,158 @@ func genDataPoints(t *testing.T, now time.Time, n int, gran time.Duration) []Dat
 
 	return pnts
 }
+
+func TestPersistentStore_Restore(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2021, 1, 1, 8, 21, 0, 0, time.UTC)
+
+	store, err := NewPersistentStore(dir)
+	require.NoError(t, err)
+	assert.False(t, store.Restored())
+
+	store.nowFunc = func() time.Time { return now }
+
+	require.NoError(t, store.Populate("10m", []DataPointGroup{
+		{EdgeIngress: "ing", DataPoints: DataPoints{{Timestamp: now.Add(-time.Hour).Unix(), Seconds: 600, Requests: 60}}},
+	}))
+
+	buckets := Buckets{{UpperBound: 0.1, Count: 1}, {UpperBound: math.Inf(1), Count: 2}}
+	for i := 0; i < 3; i++ {
+		store.Insert(map[SetKey]DataPoint{
+			{EdgeIngress: "ing"}: {Timestamp: now.Add(time.Duration(i-3) * time.Minute).Unix(), Seconds: 60, Requests: 2, ResponseTimeBuckets: buckets},
+		})
+	}
+
+	// The first two points are sent, the last one is not.
+	marks := store.ForEachUnmarked("1m", func(_, _, _ string, _ DataPoints) {})
+	marks[tableKey{EdgeIngress: "ing"}] = 2
+	store.CommitMarks("1m", marks)
+
+	require.NoError(t, store.Close())
+
+	restored, err := NewPersistentStore(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = restored.Close() })
+
+	assert.True(t, restored.Restored())
+	assert.Equal(t, store.data, restored.data)
+	assert.Equal(t, store.marks, restored.marks)
+
+	var unsent DataPoints
+	restored.ForEachUnmarked("1m", func(_, _, _ string, pnts DataPoints) {
+		unsent = pnts
+	})
+	require.Len(t, unsent, 1)
+	assert.Equal(t, now.Add(-time.Minute).Unix(), unsent[0].Timestamp)
+	assert.Equal(t, buckets, unsent[0].ResponseTimeBuckets)
+}
+
+func TestStore_TableFor(t *testing.T) {
+	store := NewStore()
+
+	assert.Equal(t, "1m", store.TableFor(5*time.Minute))
+	assert.Equal(t, "10m", store.TableFor(time.Hour))
+	assert.Equal(t, "1h", store.TableFor(2*time.Hour))
+	assert.Equal(t, "1d", store.TableFor(7*24*time.Hour))
+	assert.Equal(t, "1d", store.TableFor(365*24*time.Hour))
+}
+
+func TestPersistentStore_RestoreAfterCleanupAndCompaction(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Hour)
+
+	store, err := NewPersistentStore(dir)
+	require.NoError(t, err)
+	store.nowFunc = func() time.Time { return now }
+
+	for _, pnt := range genDataPoints(t, now, 30, time.Minute) {
+		store.Insert(map[SetKey]DataPoint{{Ingress: "bar", Service: "baz"}: pnt})
+	}
+
+	store.RollUp()
+	for _, tbl := range []string{"1m", "10m"} {
+		store.CommitMarks(tbl, store.ForEachUnmarked(tbl, func(_, _, _ string, _ DataPoints) {}))
+	}
+	store.Cleanup()
+
+	// Each insertion has been journaled separately, the cleanup compacted them.
+	assert.Zero(t, store.journal.records)
+	require.NoError(t, store.Close())
+
+	restored, err := NewPersistentStore(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = restored.Close() })
+
+	assert.Equal(t, store.data, restored.data)
+	assert.Equal(t, store.marks, restored.marks)
+}
+
+func TestStore_CleanupEvictsOldestPoints(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2021, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	store, err := NewPersistentStore(dir)
+	require.NoError(t, err)
+	store.nowFunc = func() time.Time { return now }
+
+	require.NoError(t, store.SetMaxPoints("1m", 5))
+	assert.Error(t, store.SetMaxPoints("2m", 5))
+
+	keyA, keyB := SetKey{EdgeIngress: "a"}, SetKey{EdgeIngress: "b"}
+	for i := 0; i < 4; i++ {
+		ts := now.Add(time.Duration(i) * time.Minute).Unix()
+		store.Insert(map[SetKey]DataPoint{keyA: {Timestamp: ts, Seconds: 60}})
+		if i >= 2 {
+			store.Insert(map[SetKey]DataPoint{keyB: {Timestamp: ts, Seconds: 60}})
+		}
+	}
+
+	// The first point of each row has been sent.
+	store.CommitMarks("1m", WaterMarks{tableKey(keyA): 1, tableKey(keyB): 1})
+
+	store.Cleanup()
+
+	// The 6 points don't fit: the oldest one, already sent, is evicted.
+	assert.Equal(t, BufferStatus{Points: 5, Unsent: 4, MaxPoints: 5}, store.BufferStatus()["1m"])
+	assert.Equal(t, 0, store.marks["1m"][tableKey(keyA)])
+
+	store.Insert(map[SetKey]DataPoint{keyB: {Timestamp: now.Add(4 * time.Minute).Unix(), Seconds: 60}})
+	store.Insert(map[SetKey]DataPoint{keyB: {Timestamp: now.Add(5 * time.Minute).Unix(), Seconds: 60}})
+	store.Cleanup()
+
+	// The 2 oldest points, which were not sent yet, are dropped.
+	assert.Equal(t, BufferStatus{Points: 5, Unsent: 4, MaxPoints: 5, Dropped: 2}, store.BufferStatus()["1m"])
+	assert.Equal(t, DataPoints{{Timestamp: now.Add(3 * time.Minute).Unix(), Seconds: 60}}, store.data["1m"][tableKey(keyA)])
+
+	require.NoError(t, store.Close())
+
+	restored, err := NewPersistentStore(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = restored.Close() })
+
+	assert.Equal(t, store.data, restored.data)
+	assert.Equal(t, store.marks, restored.marks)
+}
+
+func TestPersistentStore_RestoreIgnoresTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewPersistentStore(dir)
+	require.NoError(t, err)
+
+	store.Insert(map[SetKey]DataPoint{{EdgeIngress: "ing"}: {Timestamp: 60, Seconds: 60, Requests: 1}})
+	store.Insert(map[SetKey]DataPoint{{EdgeIngress: "ing"}: {Timestamp: 120, Seconds: 60, Requests: 2}})
+	require.NoError(t, store.Close())
+
+	// Simulate a crash while writing the last record.
+	path := filepath.Join(dir, journalFile)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+
+	restored, err := NewPersistentStore(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = restored.Close() })
+
+	assert.Equal(t, DataPoints{{Timestamp: 60, Seconds: 60, Requests: 1}}, restored.data["1m"][tableKey{EdgeIngress: "ing"}])
+}
@@ -195,8 +195,17 @@ func mergeGroups(groups []DataPoints) DataPoints {
 			sum.Requests += point.Requests
 			sum.RequestErrs += point.RequestErrs
 			sum.RequestClientErrs += point.RequestClientErrs
+			sum.Gap = sum.Gap || point.Gap
+			sum.Retries += point.Retries
+			sum.RequestBytes += point.RequestBytes
+			sum.ResponseBytes += point.ResponseBytes
 			sum.ResponseTimeSum += point.ResponseTimeSum
 			sum.ResponseTimeCount += point.ResponseTimeCount
+			sum.ResponseTimeBuckets = sum.ResponseTimeBuckets.Add(point.ResponseTimeBuckets)
+			sum.ServersUp += point.ServersUp
+			sum.ServersDown += point.ServersDown
+			sum.AvgOpenConnections += point.AvgOpenConnections
+			sum.MaxOpenConnections += point.MaxOpenConnections
 
 			pointSums[point.Timestamp] = sum
 			counts[point.Timestamp]++
@@ -218,6 +227,9 @@ func mergeGroups(groups []DataPoints) DataPoints {
 		point.ReqPerS = float64(point.Requests) / float64(point.Seconds)
 		point.RequestErrPerS = float64(point.RequestErrs) / float64(point.Seconds)
 		point.RequestClientErrPerS = float64(point.RequestClientErrs) / float64(point.Seconds)
+		point.RetryPerS = float64(point.Retries) / float64(point.Seconds)
+		point.RequestBytesPerS = float64(point.RequestBytes) / float64(point.Seconds)
+		point.ResponseBytesPerS = float64(point.ResponseBytes) / float64(point.Seconds)
 
 		if point.Requests > 0 {
 			point.RequestErrPercent = float64(point.RequestErrs) / float64(point.Requests)
@@ -227,6 +239,8 @@ func mergeGroups(groups []DataPoints) DataPoints {
 		if point.ResponseTimeCount > 0 {
 			point.AvgResponseTime = point.ResponseTimeSum / float64(point.ResponseTimeCount)
 		}
+		point.setResponseTimeQuantiles()
+
 		points = append(points, point)
 	}
 
@@ -31,6 +31,7 @@ import (
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/rs/zerolog/log"
 	"github.com/traefik/hub-agent-traefik/pkg/logger"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
 // APIError represents an error returned by the API.
@@ -48,6 +49,9 @@ type Config struct {
 	Metrics       MetricsConfig       `json:"metrics"`
 	Topology      TopologyConfig      `json:"topology"`
 	AccessControl AccessControlConfig `json:"accessControl"`
+	Edge          EdgeConfig          `json:"edge"`
+	Tunnel        TunnelConfig        `json:"tunnel"`
+	Heartbeat     HeartbeatConfig     `json:"heartbeat"`
 }
 
 // TopologyConfig holds the topology part of the agent config.
@@ -68,6 +72,24 @@ type AccessControlConfig struct {
 	MaxSecuredRoutes int `json:"maxSecuredRoutes"`
 }
 
+// EdgeConfig holds the edge part of the agent config.
+type EdgeConfig struct {
+	// PollInterval is the interval at which edge ingresses and ACPs are fetched. Zero means the default one.
+	PollInterval time.Duration `json:"pollInterval"`
+}
+
+// TunnelConfig holds the tunnel part of the agent config.
+type TunnelConfig struct {
+	// PollInterval is the interval at which tunnel endpoints are fetched. Zero means the default one.
+	PollInterval time.Duration `json:"pollInterval"`
+}
+
+// HeartbeatConfig holds the heartbeat part of the agent config.
+type HeartbeatConfig struct {
+	// Interval is the interval at which the platform is pinged. Zero means the default one.
+	Interval time.Duration `json:"interval"`
+}
+
 type linkClusterReq struct {
 	Platform string `json:"platform"`
 }
@@ -79,12 +101,12 @@ type linkClusterResp struct {
 // Client allows interacting with the cluster service.
 type Client struct {
 	baseURL    *url.URL
-	token      string
+	token      secret.Token
 	httpClient *http.Client
 }
 
 // NewClient creates a new client for the cluster service.
-func NewClient(baseURL, token string) (*Client, error) {
+func NewClient(baseURL string, token secret.Token, transport http.RoundTripper) (*Client, error) {
 	u, err := url.ParseRequestURI(baseURL)
 	if err != nil {
 		return nil, err
@@ -92,6 +114,7 @@ func NewClient(baseURL, token string) (*Client, error) {
 
 	rc := retryablehttp.NewClient()
 	rc.RetryMax = 4
+	rc.HTTPClient.Transport = transport
 	rc.Logger = logger.NewRetryableHTTPWrapper(log.Logger.With().Str("component", "platform-client").Logger())
 
 	return &Client{
@@ -164,7 +187,7 @@ func (c *Client) Ping(ctx context.Context) error {
 }
 
 func (c Client) do(req *http.Request, result interface{}) error {
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Authorization", "Bearer "+c.token.Value())
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -30,6 +30,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
 const testToken = "123"
@@ -95,7 +96,7 @@ func TestClient_Link(t *testing.T) {
 
 			t.Cleanup(srv.Close)
 
-			c, err := NewClient(srv.URL, testToken)
+			c, err := NewClient(srv.URL, secret.StaticToken(testToken), http.DefaultTransport)
 			require.NoError(t, err)
 			c.httpClient = srv.Client()
 
@@ -165,7 +166,7 @@ func TestClient_GetConfig(t *testing.T) {
 
 			t.Cleanup(srv.Close)
 
-			c, err := NewClient(srv.URL, testToken)
+			c, err := NewClient(srv.URL, secret.StaticToken(testToken), http.DefaultTransport)
 			require.NoError(t, err)
 			c.httpClient = srv.Client()
 
@@ -230,7 +231,7 @@ func TestClient_Ping(t *testing.T) {
 
 			t.Cleanup(srv.Close)
 
-			c, err := NewClient(srv.URL, testToken)
+			c, err := NewClient(srv.URL, secret.StaticToken(testToken), http.DefaultTransport)
 			require.NoError(t, err)
 			c.httpClient = srv.Client()
 
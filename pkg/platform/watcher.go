@@ -19,9 +19,11 @@ package platform
 
 import (
 	"context"
+	"errors"
 	"os"
 	"os/signal"
 	"reflect"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -29,22 +31,38 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// ConfigGetter is able to fetch the agent configuration.
+type ConfigGetter interface {
+	GetConfig(ctx context.Context) (Config, error)
+}
+
+// Listener is called with the new agent configuration whenever it changes.
+type Listener func(ctx context.Context, cfg Config) error
+
 // ConfigWatcher watches hub agent configuration.
 type ConfigWatcher struct {
-	client   *Client
+	client   ConfigGetter
 	interval time.Duration
 
 	currentCfg Config
 
 	listenersMu sync.RWMutex
-	listeners   []func(cfg Config)
+	listeners   []*listenerState
 }
 
-// NewConfigWatcher return a new ConfigWatcher.
-func NewConfigWatcher(interval time.Duration, c *Client) *ConfigWatcher {
+// listenerState holds the configuration last applied by a listener.
+type listenerState struct {
+	listener Listener
+	cfg      Config
+}
+
+// NewConfigWatcher return a new ConfigWatcher, listeners being called once the configuration differs from
+// the given current one.
+func NewConfigWatcher(interval time.Duration, c ConfigGetter, currentCfg Config) *ConfigWatcher {
 	return &ConfigWatcher{
-		client:   c,
-		interval: interval,
+		client:     c,
+		interval:   interval,
+		currentCfg: currentCfg,
 	}
 }
 
@@ -55,6 +73,7 @@ func (w *ConfigWatcher) Run(ctx context.Context) {
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
 
 	for {
 		select {
@@ -73,30 +92,42 @@ func (w *ConfigWatcher) Run(ctx context.Context) {
 }
 
 // AddListener adds a listeners to the ConfigWatcher.
-func (w *ConfigWatcher) AddListener(listener func(cfg Config)) {
+func (w *ConfigWatcher) AddListener(listener Listener) {
 	w.listenersMu.Lock()
 	defer w.listenersMu.Unlock()
 
-	w.listeners = append(w.listeners, listener)
+	w.listeners = append(w.listeners, &listenerState{listener: listener, cfg: w.currentCfg})
 }
 
+// reload fetches the configuration and, when it changed, calls the listeners one after another in registration order.
+// The configuration is considered applied by each listener which succeeded, and only the failing ones are called again
+// on the next reload.
 func (w *ConfigWatcher) reload(ctx context.Context) error {
 	cfg, err := w.client.GetConfig(ctx)
 	if err != nil {
 		return err
 	}
 
-	if reflect.DeepEqual(w.currentCfg, cfg) {
-		return nil
-	}
+	w.listenersMu.RLock()
+	defer w.listenersMu.RUnlock()
 
-	w.currentCfg = cfg
+	var errs []string
+	for _, state := range w.listeners {
+		if reflect.DeepEqual(state.cfg, cfg) {
+			continue
+		}
 
-	w.listenersMu.RLock()
-	for _, listener := range w.listeners {
-		go listener(cfg)
+		if err = state.listener(ctx, cfg); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		state.cfg = cfg
+	}
+
+	if len(errs) > 0 {
+		return errors.New("apply configuration: " + strings.Join(errs, ", "))
 	}
-	w.listenersMu.RUnlock()
 
 	return nil
 }
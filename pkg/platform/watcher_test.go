@@ -3,11 +3,13 @@ package platform
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -16,6 +18,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
 func TestMain(m *testing.M) {
@@ -30,13 +33,14 @@ func TestConfigWatcher_Run(t *testing.T) {
 	}
 
 	client := setupClient(t, cfg)
-	configWatcher := NewConfigWatcher(time.Millisecond, client)
+	configWatcher := NewConfigWatcher(time.Millisecond, client, Config{})
 
 	wait := make(chan struct{})
 	var gotCfg Config
-	listener := func(cfg Config) {
+	listener := func(_ context.Context, cfg Config) error {
 		gotCfg = cfg
 		close(wait)
+		return nil
 	}
 	configWatcher.AddListener(listener)
 
@@ -53,13 +57,13 @@ func TestConfigWatcher_RunHandlesSIGHUP(t *testing.T) {
 		Metrics: MetricsConfig{Interval: time.Second},
 	}
 	client := setupClient(t, cfg)
-	configWatcher := NewConfigWatcher(time.Hour, client)
+	configWatcher := NewConfigWatcher(time.Hour, client, Config{})
 
 	wait := make(chan struct{})
 	var gotCfg Config
 	var l sync.RWMutex
 	var closed bool
-	listener := func(cfg Config) {
+	listener := func(_ context.Context, cfg Config) error {
 		gotCfg = cfg
 
 		l.Lock()
@@ -68,6 +72,8 @@ func TestConfigWatcher_RunHandlesSIGHUP(t *testing.T) {
 			closed = true
 		}
 		l.Unlock()
+
+		return nil
 	}
 	configWatcher.AddListener(listener)
 
@@ -94,6 +100,83 @@ func TestConfigWatcher_RunHandlesSIGHUP(t *testing.T) {
 	assert.Equal(t, cfg, gotCfg)
 }
 
+func TestConfigWatcher_reload(t *testing.T) {
+	cfg := Config{
+		Metrics: MetricsConfig{Interval: time.Second},
+		Edge:    EdgeConfig{PollInterval: time.Minute},
+	}
+	client := setupClient(t, cfg)
+
+	calls := map[string]int{}
+	failing := true
+
+	configWatcher := NewConfigWatcher(time.Hour, client, Config{Metrics: MetricsConfig{Interval: time.Second}})
+	configWatcher.AddListener(func(_ context.Context, _ Config) error {
+		calls["first"]++
+		if failing {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	configWatcher.AddListener(func(_ context.Context, gotCfg Config) error {
+		calls["second"]++
+		assert.Equal(t, cfg, gotCfg)
+		return nil
+	})
+
+	ctx := context.Background()
+
+	err := configWatcher.reload(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "boom")
+	assert.Equal(t, map[string]int{"first": 1, "second": 1}, calls)
+
+	// The configuration has only been applied by the second listener, the first one alone is called again.
+	failing = false
+	require.NoError(t, configWatcher.reload(ctx))
+	assert.Equal(t, map[string]int{"first": 2, "second": 1}, calls)
+
+	// The configuration is unchanged.
+	require.NoError(t, configWatcher.reload(ctx))
+	assert.Equal(t, map[string]int{"first": 2, "second": 1}, calls)
+}
+
+func TestConfigWatcher_reloadCallsListenersSerially(t *testing.T) {
+	cfg := Config{Metrics: MetricsConfig{Interval: time.Second}}
+	client := setupClient(t, cfg)
+
+	var (
+		calls   []string
+		running int32
+	)
+	listener := func(name string) Listener {
+		return func(_ context.Context, _ Config) error {
+			// Listeners must not run concurrently, they may update the same components.
+			assert.Equal(t, int32(1), atomic.AddInt32(&running, 1))
+			defer atomic.AddInt32(&running, -1)
+
+			time.Sleep(10 * time.Millisecond)
+			calls = append(calls, name)
+
+			if name == "second" {
+				return errors.New("boom")
+			}
+			return nil
+		}
+	}
+
+	configWatcher := NewConfigWatcher(time.Hour, client, Config{})
+	configWatcher.AddListener(listener("first"))
+	configWatcher.AddListener(listener("second"))
+	configWatcher.AddListener(listener("third"))
+
+	// A failing listener doesn't prevent the next ones from being called.
+	err := configWatcher.reload(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, "apply configuration: boom", err.Error())
+	assert.Equal(t, []string{"first", "second", "third"}, calls)
+}
+
 func setupClient(t *testing.T, cfg Config) *Client {
 	t.Helper()
 
@@ -110,7 +193,7 @@ func setupClient(t *testing.T, cfg Config) *Client {
 
 	t.Cleanup(srv.Close)
 
-	client, err := NewClient(srv.URL, "123")
+	client, err := NewClient(srv.URL, secret.StaticToken("123"), http.DefaultTransport)
 	require.NoError(t, err)
 	client.httpClient = srv.Client()
 
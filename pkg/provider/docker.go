@@ -270,6 +270,67 @@ func (d Docker) GetIP(ctx context.Context, serviceName, network string) (string,
 	return getContainerIP(container, network)
 }
 
+// GetTraefikInstances returns the IPs of the running Traefik containers having the given label, which is either a
+// label name or a name=value pair. The IPs are taken preferably on the networks of the main Traefik instance.
+func (d Docker) GetTraefikInstances(ctx context.Context, label string) ([]string, error) {
+	networks, err := d.getTraefikNetworks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get Traefik networks: %w", err)
+	}
+
+	containers, err := d.client.ContainerList(ctx, types.ContainerListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", label), filters.Arg("status", "running")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list containers: %w", err)
+	}
+
+	var ips []string
+	for _, container := range containers {
+		if container.NetworkSettings == nil {
+			continue
+		}
+
+		ip := getTraefikInstanceIP(container.NetworkSettings, networks)
+		if ip == "" {
+			log.Debug().Str("container_id", container.ID).Msg("No IP address found for Traefik container")
+			continue
+		}
+
+		ips = append(ips, ip)
+	}
+
+	sort.Strings(ips)
+
+	return ips, nil
+}
+
+func getTraefikInstanceIP(settings *types.SummaryNetworkSettings, traefikNetworks []string) string {
+	names := make([]string, 0, len(settings.Networks))
+	for name := range settings.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var fallback string
+	for _, name := range names {
+		endpoint := settings.Networks[name]
+		if endpoint == nil || endpoint.IPAddress == "" {
+			continue
+		}
+
+		if contains(traefikNetworks, name) {
+			return endpoint.IPAddress
+		}
+
+		if fallback == "" {
+			fallback = endpoint.IPAddress
+		}
+	}
+
+	return fallback
+}
+
 func getContainerName(networks []types.NetworkResource, ip net.IP) (string, error) {
 	for _, network := range networks {
 		for _, config := range network.IPAM.Config {
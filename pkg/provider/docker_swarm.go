@@ -293,6 +293,74 @@ func (d DockerSwarm) GetIP(ctx context.Context, serviceName, network string) (st
 	return "", nil
 }
 
+// GetTraefikInstances returns the IPs of the running tasks of the Traefik services having the given label, which is
+// either a label name or a name=value pair.
+func (d DockerSwarm) GetTraefikInstances(ctx context.Context, label string) ([]string, error) {
+	serviceList, err := d.client.ServiceList(ctx, dockertypes.ServiceListOptions{Filters: filters.NewArgs(filters.Arg("label", label))})
+	if err != nil {
+		return nil, fmt.Errorf("list services: %w", err)
+	}
+
+	if len(serviceList) == 0 {
+		return nil, nil
+	}
+
+	networks, err := d.getAllNetworks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get networks: %w", err)
+	}
+
+	networkMap := toNetworkMap(networks)
+
+	var ips []string
+	for _, service := range serviceList {
+		tasks, err := d.client.TaskList(ctx, dockertypes.TaskListOptions{
+			Filters: filters.NewArgs(filters.Arg("service", service.ID), filters.Arg("desired-state", "running")),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list tasks of service %q: %w", service.Spec.Name, err)
+		}
+
+		for _, task := range tasks {
+			if task.Status.State != swarmtypes.TaskStateRunning {
+				continue
+			}
+
+			ip := getTaskIP(task, networkMap)
+			if ip == "" {
+				log.Debug().Str("service_name", service.Spec.Name).Str("task_id", task.ID).Msg("No IP address found for Traefik task")
+				continue
+			}
+
+			ips = append(ips, ip)
+		}
+	}
+
+	sort.Strings(ips)
+
+	return ips, nil
+}
+
+func getTaskIP(task swarmtypes.Task, networkMap map[string]*dockertypes.NetworkResource) string {
+	for _, attachment := range task.NetworksAttachments {
+		network := networkMap[attachment.Network.ID]
+		if network == nil || network.Ingress {
+			continue
+		}
+
+		for _, addr := range attachment.Addresses {
+			ip, _, err := net.ParseCIDR(addr)
+			if err != nil || ip == nil {
+				continue
+			}
+
+			return ip.String()
+		}
+	}
+
+	return ""
+}
+
 func getServiceIP(ctx context.Context, service swarmtypes.Service, networkMap map[string]*dockertypes.NetworkResource, network string) string {
 	logger := log.Ctx(ctx)
 
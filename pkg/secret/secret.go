@@ -0,0 +1,234 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Reference schemes handled by the Resolver itself.
+const (
+	SchemeFile = "file"
+	SchemeEnv  = "env"
+)
+
+// Provider fetches secrets from an external secret store.
+type Provider interface {
+	// Secret returns the secret identified by the given provider-specific reference.
+	Secret(ctx context.Context, ref string) (string, error)
+}
+
+// Resolver resolves secret settings. A setting is either a literal secret, or a reference to it:
+//   - "file:<path>": the content of the file, surrounding whitespaces trimmed.
+//   - "env:<name>": the value of the environment variable.
+//   - "<scheme>:<ref>": the secret returned by the Provider registered for the scheme.
+//
+// Any other value, including those with an unregistered scheme, is taken literally.
+type Resolver struct {
+	mu        sync.RWMutex
+	providers map[string]Provider
+}
+
+// NewResolver creates a new Resolver, without any Provider.
+func NewResolver() *Resolver {
+	return &Resolver{providers: make(map[string]Provider)}
+}
+
+// Register registers the given Provider for the given reference scheme.
+func (r *Resolver) Register(scheme string, p Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.providers[scheme] = p
+}
+
+// Resolve returns the secret of the given setting.
+func (r *Resolver) Resolve(ctx context.Context, setting string) (string, error) {
+	scheme, ref, ok := r.parse(setting)
+	if !ok {
+		return setting, nil
+	}
+
+	switch scheme {
+	case SchemeFile:
+		content, err := os.ReadFile(ref)
+		if err != nil {
+			return "", fmt.Errorf("read secret file: %w", err)
+		}
+
+		return strings.TrimSpace(string(content)), nil
+
+	case SchemeEnv:
+		value, exists := os.LookupEnv(ref)
+		if !exists {
+			return "", fmt.Errorf("environment variable %q not set", ref)
+		}
+
+		return value, nil
+
+	default:
+		r.mu.RLock()
+		p := r.providers[scheme]
+		r.mu.RUnlock()
+
+		value, err := p.Secret(ctx, ref)
+		if err != nil {
+			return "", fmt.Errorf("get %s secret %q: %w", scheme, ref, err)
+		}
+
+		return value, nil
+	}
+}
+
+// IsReference reports whether the given setting is a reference to a secret rather than a literal one.
+func (r *Resolver) IsReference(setting string) bool {
+	_, _, ok := r.parse(setting)
+	return ok
+}
+
+func (r *Resolver) parse(setting string) (scheme, ref string, ok bool) {
+	i := strings.Index(setting, ":")
+	if i <= 0 {
+		return "", "", false
+	}
+
+	scheme, ref = setting[:i], setting[i+1:]
+	if scheme == SchemeFile || scheme == SchemeEnv {
+		return scheme, ref, true
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, exists := r.providers[scheme]; !exists {
+		return "", "", false
+	}
+
+	return scheme, ref, true
+}
+
+// Token is a secret read each time it is used, so that its changes are picked up.
+type Token interface {
+	// Value returns the current value of the secret.
+	Value() string
+}
+
+// StaticToken is a Token which never changes.
+type StaticToken string
+
+// Value returns the token.
+func (t StaticToken) Value() string {
+	return string(t)
+}
+
+// Source is a secret setting, kept up to date with the secret it references.
+type Source struct {
+	name     string
+	setting  string
+	resolver *Resolver
+
+	mu        sync.RWMutex
+	value     string
+	listeners []func(value string)
+}
+
+// NewSource creates a new Source for the given named setting, resolving it.
+func (r *Resolver) NewSource(ctx context.Context, name, setting string) (*Source, error) {
+	value, err := r.Resolve(ctx, setting)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", name, err)
+	}
+
+	return &Source{
+		name:     name,
+		setting:  setting,
+		resolver: r,
+		value:    value,
+	}, nil
+}
+
+// Value returns the current value of the secret.
+func (s *Source) Value() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.value
+}
+
+// OnChange adds a listener called with the new value of the secret each time it changes.
+func (s *Source) OnChange(fn func(value string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listeners = append(s.listeners, fn)
+}
+
+// Reload resolves the secret again, calling the listeners if it changed.
+func (s *Source) Reload(ctx context.Context) error {
+	value, err := s.resolver.Resolve(ctx, s.setting)
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", s.name, err)
+	}
+
+	s.mu.Lock()
+	if value == s.value {
+		s.mu.Unlock()
+		return nil
+	}
+	s.value = value
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	log.Info().Str("secret", s.name).Msg("Secret changed")
+
+	for _, listener := range listeners {
+		listener(value)
+	}
+
+	return nil
+}
+
+// Run reloads the secret at the given interval, until the context is canceled.
+// Literal secrets never change, so Run returns immediately for them.
+func (s *Source) Run(ctx context.Context, interval time.Duration) {
+	if !s.resolver.IsReference(s.setting) {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Reload(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				log.Error().Err(err).Str("secret", s.name).Msg("Unable to reload secret")
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package secret
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type providerMock func(ctx context.Context, ref string) (string, error)
+
+func (m providerMock) Secret(ctx context.Context, ref string) (string, error) {
+	return m(ctx, ref)
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("file-secret\n"), 0o600))
+
+	t.Setenv("SECRET_TEST_TOKEN", "env-secret")
+
+	resolver := NewResolver()
+	resolver.Register("stub", providerMock(func(_ context.Context, ref string) (string, error) {
+		if ref != "hub#token" {
+			return "", errors.New("not found")
+		}
+		return "stub-secret", nil
+	}))
+
+	tests := []struct {
+		desc    string
+		setting string
+		want    string
+		wantErr bool
+	}{
+		{desc: "literal", setting: "secret", want: "secret"},
+		{desc: "literal with an unknown scheme", setting: "foo:bar", want: "foo:bar"},
+		{desc: "file", setting: "file:" + path, want: "file-secret"},
+		{desc: "missing file", setting: "file:" + path + ".missing", wantErr: true},
+		{desc: "env", setting: "env:SECRET_TEST_TOKEN", want: "env-secret"},
+		{desc: "missing env", setting: "env:SECRET_TEST_MISSING", wantErr: true},
+		{desc: "provider", setting: "stub:hub#token", want: "stub-secret"},
+		{desc: "provider error", setting: "stub:hub#missing", wantErr: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			got, err := resolver.Resolve(context.Background(), test.setting)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestSource_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("secret-1"), 0o600))
+
+	src, err := NewResolver().NewSource(context.Background(), "hub.token", "file:"+path)
+	require.NoError(t, err)
+	assert.Equal(t, "secret-1", src.Value())
+
+	var changes []string
+	src.OnChange(func(value string) {
+		changes = append(changes, value)
+	})
+
+	require.NoError(t, src.Reload(context.Background()))
+	assert.Empty(t, changes)
+
+	require.NoError(t, os.WriteFile(path, []byte("secret-2"), 0o600))
+	require.NoError(t, src.Reload(context.Background()))
+	assert.Equal(t, "secret-2", src.Value())
+	assert.Equal(t, []string{"secret-2"}, changes)
+
+	// A failed reload keeps the last known value.
+	require.NoError(t, os.Remove(path))
+	assert.Error(t, src.Reload(context.Background()))
+	assert.Equal(t, "secret-2", src.Value())
+}
@@ -0,0 +1,138 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// SchemeVault is the reference scheme of secrets stored in Vault.
+const SchemeVault = "vault"
+
+// VaultProvider fetches secrets from the KV secrets engine of a Vault server, through its HTTP API.
+// References are of the form "<path>#<key>", path being the API path of the secret under /v1
+// (e.g. "secret/data/hub#token" for the "token" key of the "hub" secret of a KV version 2 engine mounted on "secret").
+// Both versions of the KV secrets engine are supported.
+type VaultProvider struct {
+	baseURL    *url.URL
+	token      string
+	httpClient *http.Client
+}
+
+// NewVaultProvider creates a new VaultProvider for the Vault server at the given address, authenticated with the given token.
+func NewVaultProvider(addr, token string, transport http.RoundTripper) (*VaultProvider, error) {
+	u, err := url.ParseRequestURI(addr)
+	if err != nil {
+		return nil, fmt.Errorf("parse Vault address: %w", err)
+	}
+
+	return &VaultProvider{
+		baseURL: u,
+		token:   token,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   10 * time.Second,
+		},
+	}, nil
+}
+
+type vaultSecret struct {
+	Data map[string]interface{} `json:"data"`
+}
+
+type vaultError struct {
+	Errors []string `json:"errors"`
+}
+
+// Secret returns the secret identified by the given reference.
+func (p *VaultProvider) Secret(ctx context.Context, ref string) (string, error) {
+	secretPath, key, ok := cut(ref, "#")
+	if !ok || secretPath == "" || key == "" {
+		return "", errors.New(`invalid reference, must be of the form "<path>#<key>"`)
+	}
+
+	endpoint, err := p.baseURL.Parse(path.Join(p.baseURL.Path, "v1", secretPath))
+	if err != nil {
+		return "", fmt.Errorf("parse endpoint: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), http.NoBody)
+	if err != nil {
+		return "", fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		all, _ := io.ReadAll(resp.Body)
+
+		var vaultErr vaultError
+		if err = json.Unmarshal(all, &vaultErr); err == nil && len(vaultErr.Errors) > 0 {
+			return "", fmt.Errorf("failed with code %d: %s", resp.StatusCode, strings.Join(vaultErr.Errors, ", "))
+		}
+
+		return "", fmt.Errorf("failed with code %d: %s", resp.StatusCode, string(all))
+	}
+
+	var secret vaultSecret
+	if err = json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("decode secret: %w", err)
+	}
+
+	data := secret.Data
+	// Version 2 of the KV secrets engine nests the secret data, next to its metadata.
+	if nested, isV2 := data["data"].(map[string]interface{}); isV2 {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			data = nested
+		}
+	}
+
+	value, exists := data[key]
+	if !exists {
+		return "", fmt.Errorf("key %q not found", key)
+	}
+
+	s, isString := value.(string)
+	if !isString {
+		return "", fmt.Errorf("key %q is not a string", key)
+	}
+
+	return s, nil
+}
+
+// cut is strings.Cut, which is not available in Go 1.17.
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
@@ -0,0 +1,80 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package secret
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVaultProvider_Secret(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/secret/data/hub", func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "vault-token" {
+			rw.WriteHeader(http.StatusForbidden)
+			_, _ = rw.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		_, _ = rw.Write([]byte(`{"data":{"data":{"token":"kv2-secret","port":443},"metadata":{"version":3}}}`))
+	})
+	mux.HandleFunc("/v1/kv/hub", func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{"data":{"token":"kv1-secret"}}`))
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	tests := []struct {
+		desc    string
+		token   string
+		ref     string
+		want    string
+		wantErr string
+	}{
+		{desc: "KV version 2", token: "vault-token", ref: "secret/data/hub#token", want: "kv2-secret"},
+		{desc: "KV version 1", token: "vault-token", ref: "kv/hub#token", want: "kv1-secret"},
+		{desc: "missing key", token: "vault-token", ref: "secret/data/hub#password", wantErr: `key "password" not found`},
+		{desc: "non string key", token: "vault-token", ref: "secret/data/hub#port", wantErr: `key "port" is not a string`},
+		{desc: "invalid reference", token: "vault-token", ref: "secret/data/hub", wantErr: "invalid reference"},
+		{desc: "permission denied", token: "bad-token", ref: "secret/data/hub#token", wantErr: "failed with code 403: permission denied"},
+		{desc: "not found", token: "vault-token", ref: "secret/data/missing#token", wantErr: "failed with code 404"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			p, err := NewVaultProvider(srv.URL, test.token, http.DefaultTransport)
+			require.NoError(t, err)
+
+			got, err := p.Secret(context.Background(), test.ref)
+			if test.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package state
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/traefik/hub-agent-traefik/pkg/certificate"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+	"github.com/traefik/hub-agent-traefik/pkg/platform"
+	"github.com/traefik/hub-agent-traefik/pkg/tunnel"
+)
+
+// Snapshot sources.
+const (
+	SourceClusterID           = "clusterId"
+	SourceConfig              = "config"
+	SourceEdgeIngresses       = "edgeIngresses"
+	SourceACPs                = "acps"
+	SourceWildcardCertificate = "wildcardCertificate"
+	SourceTunnelEndpoints     = "tunnelEndpoints"
+)
+
+// isUnreachable reports whether the given error means the platform couldn't be reached or failed on its side, e.g.
+// a transport error, a timeout or a 5xx response. Other errors returned by the platform, such as an authentication
+// failure, are not worked around with the snapshot.
+func isUnreachable(err error) bool {
+	var statusCode int
+
+	var platformErr platform.APIError
+	var edgeErr edge.APIError
+	var certErr certificate.APIError
+	var tunnelErr tunnel.APIError
+	switch {
+	case errors.As(err, &platformErr):
+		statusCode = platformErr.StatusCode
+	case errors.As(err, &edgeErr):
+		statusCode = edgeErr.StatusCode
+	case errors.As(err, &certErr):
+		statusCode = certErr.StatusCode
+	case errors.As(err, &tunnelErr):
+		statusCode = tunnelErr.StatusCode
+	default:
+		return true
+	}
+
+	return statusCode >= http.StatusInternalServerError
+}
+
+// PlatformBackend is able to link the agent and fetch its configuration.
+type PlatformBackend interface {
+	Link(ctx context.Context) (string, error)
+	GetConfig(ctx context.Context) (platform.Config, error)
+}
+
+// CertificateBackend is able to fetch certificates.
+type CertificateBackend interface {
+	GetWildcardCertificate(ctx context.Context) (certificate.Certificate, error)
+	GetCertificateByDomains(ctx context.Context, domains []string) (certificate.Certificate, error)
+}
+
+// PlatformClient is a PlatformBackend falling back to the snapshot when the platform is unreachable.
+type PlatformClient struct {
+	client PlatformBackend
+	store  *Store
+}
+
+// NewPlatformClient returns a new PlatformClient.
+func NewPlatformClient(client PlatformBackend, store *Store) *PlatformClient {
+	return &PlatformClient{client: client, store: store}
+}
+
+// Link links the agent to the Hub platform, or returns the last known cluster ID.
+func (c *PlatformClient) Link(ctx context.Context) (string, error) {
+	clusterID, err := c.client.Link(ctx)
+	if err != nil {
+		if c.store.fallback(SourceClusterID, err, func(s Snapshot) { clusterID = s.ClusterID }) {
+			return clusterID, nil
+		}
+
+		return "", err
+	}
+
+	c.store.save(SourceClusterID, func(s *Snapshot) { s.ClusterID = clusterID })
+
+	return clusterID, nil
+}
+
+// GetConfig returns the agent configuration, or the last known one.
+func (c *PlatformClient) GetConfig(ctx context.Context) (platform.Config, error) {
+	cfg, err := c.client.GetConfig(ctx)
+	if err != nil {
+		if c.store.fallback(SourceConfig, err, func(s Snapshot) { cfg = s.Config }) {
+			return cfg, nil
+		}
+
+		return platform.Config{}, err
+	}
+
+	c.store.save(SourceConfig, func(s *Snapshot) { s.Config = cfg })
+
+	return cfg, nil
+}
+
+// EdgeClient is an edge.Backend falling back to the snapshot when the platform is unreachable.
+type EdgeClient struct {
+	client edge.Backend
+	store  *Store
+}
+
+// NewEdgeClient returns a new EdgeClient.
+func NewEdgeClient(client edge.Backend, store *Store) *EdgeClient {
+	return &EdgeClient{client: client, store: store}
+}
+
+// GetEdgeIngresses returns the edge ingresses of the agent, or the last known ones.
+func (c *EdgeClient) GetEdgeIngresses(ctx context.Context) ([]edge.Ingress, error) {
+	ingresses, err := c.client.GetEdgeIngresses(ctx)
+	if err != nil {
+		read := func(s Snapshot) { ingresses = append([]edge.Ingress{}, s.EdgeIngresses...) }
+		if c.store.fallback(SourceEdgeIngresses, err, read) {
+			return ingresses, nil
+		}
+
+		return nil, err
+	}
+
+	saved := append([]edge.Ingress{}, ingresses...)
+	c.store.save(SourceEdgeIngresses, func(s *Snapshot) { s.EdgeIngresses = saved })
+
+	return ingresses, nil
+}
+
+// GetACPs returns the ACPs of the agent, or the last known ones.
+func (c *EdgeClient) GetACPs(ctx context.Context) ([]edge.ACP, error) {
+	acps, err := c.client.GetACPs(ctx)
+	if err != nil {
+		if c.store.fallback(SourceACPs, err, func(s Snapshot) { acps = append([]edge.ACP{}, s.ACPs...) }) {
+			return acps, nil
+		}
+
+		return nil, err
+	}
+
+	saved := append([]edge.ACP{}, acps...)
+	c.store.save(SourceACPs, func(s *Snapshot) { s.ACPs = saved })
+
+	return acps, nil
+}
+
+// CertificateClient is a CertificateBackend falling back to the snapshot when the platform is unreachable.
+type CertificateClient struct {
+	client CertificateBackend
+	store  *Store
+}
+
+// NewCertificateClient returns a new CertificateClient.
+func NewCertificateClient(client CertificateBackend, store *Store) *CertificateClient {
+	return &CertificateClient{client: client, store: store}
+}
+
+// GetWildcardCertificate returns the wildcard certificate of the agent, or the last known one.
+func (c *CertificateClient) GetWildcardCertificate(ctx context.Context) (certificate.Certificate, error) {
+	cert, err := c.client.GetWildcardCertificate(ctx)
+	if err != nil {
+		read := func(s Snapshot) { cert = s.WildcardCertificate }
+		if c.store.fallback(SourceWildcardCertificate, err, read) {
+			return cert, nil
+		}
+
+		return certificate.Certificate{}, err
+	}
+
+	c.store.save(SourceWildcardCertificate, func(s *Snapshot) { s.WildcardCertificate = cert })
+
+	return cert, nil
+}
+
+// GetCertificateByDomains returns the certificate of the given domains, or the last known one.
+func (c *CertificateClient) GetCertificateByDomains(ctx context.Context, domains []string) (certificate.Certificate, error) {
+	source := certificateSource(domains)
+
+	cert, err := c.client.GetCertificateByDomains(ctx, domains)
+	if err != nil {
+		if c.store.fallback(source, err, func(s Snapshot) { cert = s.Certificates[source] }) {
+			return cert, nil
+		}
+
+		return certificate.Certificate{}, err
+	}
+
+	c.store.save(source, func(s *Snapshot) { s.Certificates[source] = cert })
+
+	return cert, nil
+}
+
+// TunnelClient is a tunnel.Backend falling back to the snapshot when the platform is unreachable.
+type TunnelClient struct {
+	client tunnel.Backend
+	store  *Store
+}
+
+// NewTunnelClient returns a new TunnelClient.
+func NewTunnelClient(client tunnel.Backend, store *Store) *TunnelClient {
+	return &TunnelClient{client: client, store: store}
+}
+
+// ListClusterTunnelEndpoints returns the tunnel endpoints of the cluster, or the last known ones.
+func (c *TunnelClient) ListClusterTunnelEndpoints(ctx context.Context) ([]tunnel.Endpoint, error) {
+	endpoints, err := c.client.ListClusterTunnelEndpoints(ctx)
+	if err != nil {
+		read := func(s Snapshot) { endpoints = append([]tunnel.Endpoint{}, s.TunnelEndpoints...) }
+		if c.store.fallback(SourceTunnelEndpoints, err, read) {
+			return endpoints, nil
+		}
+
+		return nil, err
+	}
+
+	saved := append([]tunnel.Endpoint{}, endpoints...)
+	c.store.save(SourceTunnelEndpoints, func(s *Snapshot) { s.TunnelEndpoints = saved })
+
+	return endpoints, nil
+}
@@ -0,0 +1,217 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/certificate"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+	"github.com/traefik/hub-agent-traefik/pkg/platform"
+	"github.com/traefik/hub-agent-traefik/pkg/tunnel"
+)
+
+const snapshotFile = "state.json"
+
+// Snapshot is the last known state of the platform.
+type Snapshot struct {
+	ClusterID           string                             `json:"clusterId"`
+	Config              platform.Config                    `json:"config"`
+	EdgeIngresses       []edge.Ingress                     `json:"edgeIngresses"`
+	ACPs                []edge.ACP                         `json:"acps"`
+	WildcardCertificate certificate.Certificate            `json:"wildcardCertificate"`
+	Certificates        map[string]certificate.Certificate `json:"certificates"`
+	TunnelEndpoints     []tunnel.Endpoint                  `json:"tunnelEndpoints"`
+
+	// Sources holds the time at which each part of the snapshot has last been fetched from the platform.
+	Sources map[string]time.Time `json:"sources"`
+}
+
+// Status is the status of the state store.
+type Status struct {
+	// RunningFromCache is true when a part of the agent runs from the snapshot, because the platform is unreachable.
+	RunningFromCache bool `json:"runningFromCache"`
+	// CachedSources are the parts of the snapshot in use, along with the time they have been fetched.
+	CachedSources map[string]time.Time `json:"cachedSources,omitempty"`
+}
+
+// Store keeps the last known state of the platform in a data directory, so the agent can run from it
+// while the platform is unreachable. The snapshot is written atomically after each successful fetch.
+type Store struct {
+	path string
+
+	mu       sync.Mutex
+	snapshot Snapshot
+	// cached holds the sources currently served from the snapshot.
+	cached map[string]struct{}
+}
+
+// NewStore creates a new Store persisting its snapshot in the given directory, loading the existing one if any.
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create data directory: %w", err)
+	}
+
+	s := &Store{
+		path: filepath.Join(dir, snapshotFile),
+		snapshot: Snapshot{
+			Certificates: make(map[string]certificate.Certificate),
+			Sources:      make(map[string]time.Time),
+		},
+		cached: make(map[string]struct{}),
+	}
+
+	data, err := os.ReadFile(s.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return s, nil
+	case err != nil:
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+
+	var snapshot Snapshot
+	if err = json.Unmarshal(data, &snapshot); err != nil {
+		log.Warn().Err(err).Str("path", s.path).Msg("Ignoring corrupted platform state snapshot")
+		return s, nil
+	}
+
+	if snapshot.Certificates == nil {
+		snapshot.Certificates = make(map[string]certificate.Certificate)
+	}
+	if snapshot.Sources == nil {
+		snapshot.Sources = make(map[string]time.Time)
+	}
+	s.snapshot = snapshot
+
+	return s, nil
+}
+
+// Status returns the status of the store.
+func (s *Store) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := Status{RunningFromCache: len(s.cached) > 0}
+	if status.RunningFromCache {
+		status.CachedSources = make(map[string]time.Time, len(s.cached))
+		for source := range s.cached {
+			status.CachedSources[source] = s.snapshot.Sources[source]
+		}
+	}
+
+	return status
+}
+
+// save applies the given update, fetched from the platform, to the snapshot and persists it.
+// Persistence errors are only logged, the snapshot being a best effort.
+func (s *Store) save(source string, update func(*Snapshot)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	update(&s.snapshot)
+	s.snapshot.Sources[source] = time.Now().UTC()
+
+	if _, ok := s.cached[source]; ok {
+		delete(s.cached, source)
+		log.Info().Str("source", source).Msg("Platform reachable again, no longer running from cache")
+	}
+
+	if err := s.write(); err != nil {
+		log.Error().Err(err).Str("path", s.path).Msg("Unable to persist platform state snapshot")
+	}
+}
+
+// fallback reads the given source from the snapshot, because fetching it from the platform failed with
+// the given error. It returns false if the source has never been fetched, or if the platform answered with an error
+// which must be surfaced, e.g. because the token has been revoked.
+func (s *Store) fallback(source string, fetchErr error, read func(Snapshot)) bool {
+	if !isUnreachable(fetchErr) {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fetchedAt, ok := s.snapshot.Sources[source]
+	if !ok {
+		return false
+	}
+
+	if _, cached := s.cached[source]; !cached {
+		s.cached[source] = struct{}{}
+		log.Warn().Err(fetchErr).
+			Str("source", source).
+			Time("fetched_at", fetchedAt).
+			Msg("Unable to reach the platform, running from cache")
+	}
+
+	read(s.snapshot)
+
+	return true
+}
+
+// write writes the snapshot to a temporary file and renames it, so a crash never leaves a partial snapshot.
+func (s *Store) write() error {
+	data, err := json.Marshal(s.snapshot)
+	if err != nil {
+		return fmt.Errorf("marshal snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), snapshotFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temporary snapshot: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("write temporary snapshot: %w", err)
+	}
+
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("sync temporary snapshot: %w", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("close temporary snapshot: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("rename temporary snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// certificateSource returns the source of the certificate of the given domains.
+func certificateSource(domains []string) string {
+	sorted := make([]string, len(domains))
+	copy(sorted, domains)
+	sort.Strings(sorted)
+
+	return "certificate:" + strings.Join(sorted, ",")
+}
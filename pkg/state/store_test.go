@@ -0,0 +1,230 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/certificate"
+	"github.com/traefik/hub-agent-traefik/pkg/edge"
+	"github.com/traefik/hub-agent-traefik/pkg/platform"
+	"github.com/traefik/hub-agent-traefik/pkg/tunnel"
+)
+
+var errUnreachable = errors.New("platform unreachable")
+
+type platformMock struct {
+	err       error
+	clusterID string
+	cfg       platform.Config
+}
+
+func (m platformMock) Link(_ context.Context) (string, error) {
+	return m.clusterID, m.err
+}
+
+func (m platformMock) GetConfig(_ context.Context) (platform.Config, error) {
+	return m.cfg, m.err
+}
+
+type edgeMock struct {
+	err       error
+	ingresses []edge.Ingress
+	acps      []edge.ACP
+}
+
+func (m edgeMock) GetEdgeIngresses(_ context.Context) ([]edge.Ingress, error) {
+	return m.ingresses, m.err
+}
+
+func (m edgeMock) GetACPs(_ context.Context) ([]edge.ACP, error) {
+	return m.acps, m.err
+}
+
+type certificateMock struct {
+	err  error
+	cert certificate.Certificate
+}
+
+func (m certificateMock) GetWildcardCertificate(_ context.Context) (certificate.Certificate, error) {
+	return m.cert, m.err
+}
+
+func (m certificateMock) GetCertificateByDomains(_ context.Context, domains []string) (certificate.Certificate, error) {
+	cert := m.cert
+	cert.Domains = domains
+
+	return cert, m.err
+}
+
+type tunnelMock struct {
+	err       error
+	endpoints []tunnel.Endpoint
+}
+
+func (m tunnelMock) ListClusterTunnelEndpoints(_ context.Context) ([]tunnel.Endpoint, error) {
+	return m.endpoints, m.err
+}
+
+func TestStore_runFromCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	store, err := NewStore(dir)
+	require.NoError(t, err)
+
+	cfg := platform.Config{AccessControl: platform.AccessControlConfig{MaxSecuredRoutes: 3}}
+	ingresses := []edge.Ingress{{ID: "ingress", Name: "ingress"}}
+	acps := []edge.ACP{{ID: "acp", Name: "acp"}}
+	cert := certificate.Certificate{Certificate: []byte("cert"), PrivateKey: []byte("key")}
+	endpoints := []tunnel.Endpoint{{TunnelID: "tunnel", BrokerEndpoint: "wss://broker"}}
+
+	// Fetch everything from the platform.
+	_, err = NewPlatformClient(platformMock{clusterID: "cluster", cfg: cfg}, store).Link(ctx)
+	require.NoError(t, err)
+	_, err = NewPlatformClient(platformMock{clusterID: "cluster", cfg: cfg}, store).GetConfig(ctx)
+	require.NoError(t, err)
+	_, err = NewEdgeClient(edgeMock{ingresses: ingresses}, store).GetEdgeIngresses(ctx)
+	require.NoError(t, err)
+	_, err = NewEdgeClient(edgeMock{acps: acps}, store).GetACPs(ctx)
+	require.NoError(t, err)
+	_, err = NewCertificateClient(certificateMock{cert: cert}, store).GetWildcardCertificate(ctx)
+	require.NoError(t, err)
+	_, err = NewCertificateClient(certificateMock{cert: cert}, store).GetCertificateByDomains(ctx, []string{"b.com", "a.com"})
+	require.NoError(t, err)
+	_, err = NewTunnelClient(tunnelMock{endpoints: endpoints}, store).ListClusterTunnelEndpoints(ctx)
+	require.NoError(t, err)
+
+	assert.False(t, store.Status().RunningFromCache)
+
+	// Restart while the platform is unreachable.
+	store, err = NewStore(dir)
+	require.NoError(t, err)
+
+	platformClient := NewPlatformClient(platformMock{err: errUnreachable}, store)
+	clusterID, err := platformClient.Link(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "cluster", clusterID)
+
+	gotCfg, err := platformClient.GetConfig(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, cfg, gotCfg)
+
+	edgeClient := NewEdgeClient(edgeMock{err: errUnreachable}, store)
+	gotIngresses, err := edgeClient.GetEdgeIngresses(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ingresses, gotIngresses)
+
+	gotACPs, err := edgeClient.GetACPs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, acps, gotACPs)
+
+	certClient := NewCertificateClient(certificateMock{err: errUnreachable}, store)
+	gotCert, err := certClient.GetWildcardCertificate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, cert, gotCert)
+
+	gotCert, err = certClient.GetCertificateByDomains(ctx, []string{"a.com", "b.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b.com", "a.com"}, gotCert.Domains)
+
+	_, err = certClient.GetCertificateByDomains(ctx, []string{"c.com"})
+	assert.ErrorIs(t, err, errUnreachable)
+
+	gotEndpoints, err := NewTunnelClient(tunnelMock{err: errUnreachable}, store).ListClusterTunnelEndpoints(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, endpoints, gotEndpoints)
+
+	status := store.Status()
+	assert.True(t, status.RunningFromCache)
+	assert.Len(t, status.CachedSources, 7)
+	assert.Contains(t, status.CachedSources, "certificate:a.com,b.com")
+
+	// The platform is reachable again.
+	for _, source := range []string{SourceClusterID, SourceConfig, SourceEdgeIngresses, SourceACPs, SourceWildcardCertificate, SourceTunnelEndpoints} {
+		store.save(source, func(*Snapshot) {})
+	}
+	_, err = NewCertificateClient(certificateMock{cert: cert}, store).GetCertificateByDomains(ctx, []string{"a.com", "b.com"})
+	require.NoError(t, err)
+
+	assert.False(t, store.Status().RunningFromCache)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, snapshotFile, entries[0].Name())
+}
+
+func TestStore_platformErrors(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewStore(t.TempDir())
+	require.NoError(t, err)
+
+	ingresses := []edge.Ingress{{ID: "ingress", Name: "ingress"}}
+	_, err = NewEdgeClient(edgeMock{ingresses: ingresses}, store).GetEdgeIngresses(ctx)
+	require.NoError(t, err)
+	_, err = NewPlatformClient(platformMock{clusterID: "cluster"}, store).Link(ctx)
+	require.NoError(t, err)
+
+	// Authentication and client errors are surfaced rather than hidden by the snapshot.
+	unauthorized := platform.APIError{StatusCode: http.StatusUnauthorized, Message: "invalid token"}
+	_, err = NewPlatformClient(platformMock{err: unauthorized}, store).Link(ctx)
+	assert.ErrorIs(t, err, unauthorized)
+
+	forbidden := fmt.Errorf("get edge ingresses: %w", edge.APIError{StatusCode: http.StatusForbidden, Message: "unlinked"})
+	_, err = NewEdgeClient(edgeMock{err: forbidden}, store).GetEdgeIngresses(ctx)
+	assert.ErrorIs(t, err, forbidden)
+
+	assert.False(t, store.Status().RunningFromCache)
+
+	// Server errors are worked around.
+	gotIngresses, err := NewEdgeClient(edgeMock{err: edge.APIError{StatusCode: http.StatusBadGateway}}, store).GetEdgeIngresses(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ingresses, gotIngresses)
+
+	assert.True(t, store.Status().RunningFromCache)
+}
+
+func TestStore_noSnapshot(t *testing.T) {
+	store, err := NewStore(filepath.Join(t.TempDir(), "data"))
+	require.NoError(t, err)
+
+	_, err = NewPlatformClient(platformMock{err: errUnreachable}, store).Link(context.Background())
+	assert.ErrorIs(t, err, errUnreachable)
+
+	assert.False(t, store.Status().RunningFromCache)
+}
+
+func TestStore_corruptedSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, snapshotFile), []byte("{"), 0o600))
+
+	store, err := NewStore(dir)
+	require.NoError(t, err)
+
+	_, err = NewEdgeClient(edgeMock{err: errUnreachable}, store).GetEdgeIngresses(context.Background())
+	assert.ErrorIs(t, err, errUnreachable)
+}
@@ -0,0 +1,144 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Provider returns the status of a component of the agent. The returned value is marshaled to JSON.
+type Provider func() interface{}
+
+// Server serves the status of the components of the agent.
+type Server struct {
+	listenAddr string
+
+	providersMu sync.RWMutex
+	providers   map[string]Provider
+
+	handlers map[string]http.Handler
+}
+
+// NewServer creates a new status server.
+func NewServer(listenAddr string) *Server {
+	return &Server{
+		listenAddr: listenAddr,
+		providers:  make(map[string]Provider),
+		handlers:   make(map[string]http.Handler),
+	}
+}
+
+// Handle serves the given handler, alongside the status, for the given pattern. It must be called before running the
+// server.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.handlers[pattern] = handler
+}
+
+// Register registers the status provider of a component under the given name.
+func (s *Server) Register(name string, provider Provider) {
+	s.providersMu.Lock()
+	defer s.providersMu.Unlock()
+
+	s.providers[name] = provider
+}
+
+// Status returns the status of every registered component, indexed by component name.
+func (s *Server) Status() map[string]interface{} {
+	s.providersMu.RLock()
+	defer s.providersMu.RUnlock()
+
+	status := make(map[string]interface{}, len(s.providers))
+	for name, provider := range s.providers {
+		status[name] = provider()
+	}
+
+	return status
+}
+
+// ServeHTTP serves the status of the agent as JSON.
+func (s *Server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(rw).Encode(s.Status()); err != nil {
+		log.Error().Err(err).Msg("Unable to write status")
+	}
+}
+
+// handler returns the handler serving the status and the handlers registered alongside.
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/status", s)
+	for pattern, handler := range s.handlers {
+		mux.Handle(pattern, handler)
+	}
+
+	return mux
+}
+
+// Run runs the status server until the given context is canceled.
+func (s *Server) Run(ctx context.Context) error {
+	server := &http.Server{
+		Addr:              s.listenAddr,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          stdlog.New(log.Logger.Level(zerolog.DebugLevel), "", 0),
+	}
+
+	srvDone := make(chan struct{})
+
+	go func() {
+		log.Info().Str("addr", s.listenAddr).Msg("Starting status server")
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Err(err).Msg("Unable to listen and serve status requests")
+		}
+		close(srvDone)
+	}()
+
+	select {
+	case <-ctx.Done():
+		gracefulCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		//nolint:contextcheck // False positive.
+		if err := server.Shutdown(gracefulCtx); err != nil {
+			log.Error().Err(err).Msg("Failed to shutdown status server gracefully")
+			if err = server.Close(); err != nil {
+				return fmt.Errorf("close status server: %w", err)
+			}
+		}
+
+		return nil
+	case <-srvDone:
+		return errors.New("status server stopped")
+	}
+}
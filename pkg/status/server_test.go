@@ -0,0 +1,82 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package status
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_ServeHTTP(t *testing.T) {
+	srv := NewServer("")
+
+	calls := 0
+	srv.Register("tunnels", func() interface{} {
+		calls++
+		return []map[string]string{{"tunnelId": "tunnel", "state": "connected"}}
+	})
+	srv.Register("platform", func() interface{} {
+		return map[string]bool{"cached": true}
+	})
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", http.NoBody))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"platform":{"cached":true},"tunnels":[{"tunnelId":"tunnel","state":"connected"}]}`, rec.Body.String())
+
+	// The status is computed on each request.
+	srv.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/status", http.NoBody))
+	assert.Equal(t, 2, calls)
+}
+
+func TestServer_ServeHTTP_methodNotAllowed(t *testing.T) {
+	srv := NewServer("")
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/status", http.NoBody))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestServer_Handle(t *testing.T) {
+	srv := NewServer("")
+	srv.Register("platform", func() interface{} {
+		return map[string]bool{"cached": true}
+	})
+	srv.Handle("/api/", http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(req.URL.Path))
+	}))
+
+	handler := srv.handler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/metrics/top", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "/api/metrics/top", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"platform":{"cached":true}}`, rec.Body.String())
+}
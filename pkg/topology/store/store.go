@@ -23,6 +23,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -32,48 +33,98 @@ import (
 	"github.com/ldez/go-git-cmd-wrapper/v2/remote"
 	"github.com/ldez/go-git-cmd-wrapper/v2/types"
 	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/outbound"
 	"github.com/traefik/hub-agent-traefik/pkg/platform"
 )
 
+// gitTokenEnv is the environment variable through which the token is given to the git credential helper.
+const gitTokenEnv = "HUB_AGENT_GIT_TOKEN"
+
+// gitCredentialHelper answers git credential requests with the token found in the environment of the git command,
+// so that the token is never written to the repository configuration.
+const gitCredentialHelper = `!f() { test "$1" = get && echo "username=${` + gitTokenEnv + `}" && echo "password="; }; f`
+
 // Config represents the topology store config.
 type Config struct {
 	platform.TopologyConfig
 
-	Token string
+	// Token returns the token used to authenticate to the repository. It is called for each git command.
+	Token func() string
+	// Outbound configures the proxy and CAs git commands use to reach the repository.
+	Outbound outbound.Config
 }
 
 // Store stores a state in a Git repository.
 type Store struct {
-	gitRepo     string
-	gitExecutor types.Executor
-	workingDir  string
+	// mu prevents the repository from being written while it's being switched.
+	mu sync.Mutex
+
+	gitRepo       string
+	gitExecutor   types.Executor
+	cloneExecutor types.Executor
+	workingDir    string
 }
 
 // New instantiates a new Store.
 func New(ctx context.Context, cfg Config) (*Store, error) {
-	repoURL := fmt.Sprintf("https://%s:@%s/%s/%s.git", cfg.Token, cfg.GitProxyHost, cfg.GitOrgName, cfg.GitRepoName)
+	s := &Store{}
+	s.configure(cfg)
 
-	s := &Store{
-		gitRepo:    repoURL,
-		workingDir: cfg.GitRepoName,
-		gitExecutor: func(ctx context.Context, name string, debug bool, args ...string) (string, error) {
-			cmd := exec.CommandContext(ctx, name, args...)
-			cmd.Dir = cfg.GitRepoName
+	if err := s.cloneRepository(ctx); err != nil {
+		return nil, err
+	}
 
-			out, err := cmd.CombinedOutput()
-			output := string(out)
+	return s, nil
+}
 
-			log.Trace().Str("cmd", name).Strs("args", args).Str("output", output).Send()
+// SetConfig makes the store use the repository described by the given configuration, cloning it. The clone, which
+// may be retried for a while, doesn't block the writes to the current repository, the store switches to the new one
+// once it's ready.
+func (s *Store) SetConfig(ctx context.Context, cfg Config) error {
+	next := &Store{}
+	next.configure(cfg)
 
-			return output, err
-		},
+	if err := next.cloneRepository(ctx); err != nil {
+		return err
 	}
 
-	if err := s.cloneRepository(ctx); err != nil {
-		return nil, err
-	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	return s, nil
+	s.gitRepo = next.gitRepo
+	s.workingDir = next.workingDir
+	s.gitExecutor = next.gitExecutor
+	s.cloneExecutor = next.cloneExecutor
+
+	return nil
+}
+
+func (s *Store) configure(cfg Config) {
+	s.gitRepo = fmt.Sprintf("https://%s/%s/%s.git", cfg.GitProxyHost, cfg.GitOrgName, cfg.GitRepoName)
+	s.workingDir = cfg.GitRepoName
+	s.gitExecutor = newGitExecutor(cfg.GitRepoName, cfg.Token, cfg.Outbound.GitEnv())
+	s.cloneExecutor = newGitExecutor("", cfg.Token, cfg.Outbound.GitEnv())
+}
+
+// newGitExecutor returns an executor running git commands in the given directory, authenticated with the given token
+// through a credential helper, and with the given additional environment.
+func newGitExecutor(dir string, token func() string, env []string) types.Executor {
+	return func(ctx context.Context, name string, debug bool, args ...string) (string, error) {
+		// The first helper setting resets the helpers which could be configured on the host.
+		args = append([]string{"-c", "credential.helper=", "-c", "credential.helper=" + gitCredentialHelper}, args...)
+
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Env = append(cmd.Env, gitTokenEnv+"="+token(), "GIT_TERMINAL_PROMPT=0")
+
+		out, err := cmd.CombinedOutput()
+		output := string(out)
+
+		log.Trace().Str("cmd", name).Strs("args", args).Str("output", output).Send()
+
+		return output, err
+	}
 }
 
 func (s *Store) cloneRepository(ctx context.Context) error {
@@ -93,7 +144,7 @@ func (s *Store) cloneRepository(ctx context.Context) error {
 
 	if err := backoff.RetryNotify(func() error {
 		// Setup local repo for topology files, by cloning hub distant repository.
-		output, err := git.CloneWithContext(ctx, clone.Repository(s.gitRepo), clone.Depth("1"))
+		output, err := git.CloneWithContext(ctx, clone.Repository(s.gitRepo), clone.Depth("1"), git.CmdExecutor(s.cloneExecutor))
 		if err != nil {
 			switch {
 			case strings.Contains(output, "already exists and is not an empty directory"):
@@ -131,8 +182,9 @@ func (s *Store) updateOriginURL() error {
 	}
 
 	if url != s.gitRepo {
-		// This case happens when the agent restart and the token is updated.
-		// In this case, we need to update the URL of the origin remote.
+		// This case happens when the repository has been cloned by a previous version of the agent,
+		// which embedded the token in the URL. In this case, we need to update the URL of the origin remote
+		// so that the token is no longer kept in the repository configuration.
 		_, err = git.Remote(remote.SetURL("origin", s.gitRepo, ""), git.CmdExecutor(s.gitExecutor))
 		if err != nil {
 			return fmt.Errorf("set remote URL %w", err)
@@ -0,0 +1,56 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/outbound"
+)
+
+func TestNewGitExecutor_env(t *testing.T) {
+	dir := t.TempDir()
+
+	// A fake git command printing its environment.
+	fakeGit := filepath.Join(dir, "git")
+	require.NoError(t, os.WriteFile(fakeGit, []byte("#!/bin/sh\nenv\n"), 0o700))
+
+	outboundCfg := outbound.Config{
+		ProxyURL: "http://proxy:3128",
+		NoProxy:  "localhost",
+		CABundle: "/etc/hub/ca.pem",
+	}
+	exec := newGitExecutor(dir, func() string { return "token" }, outboundCfg.GitEnv())
+
+	output, err := exec(context.Background(), fakeGit, false, "clone")
+	require.NoError(t, err)
+
+	env := strings.Split(output, "\n")
+	assert.Contains(t, env, "HTTPS_PROXY=http://proxy:3128")
+	assert.Contains(t, env, "https_proxy=http://proxy:3128")
+	assert.Contains(t, env, "NO_PROXY=localhost")
+	assert.Contains(t, env, "GIT_SSL_CAINFO=/etc/hub/ca.pem")
+	assert.Contains(t, env, gitTokenEnv+"=token")
+	assert.Contains(t, env, "GIT_TERMINAL_PROMPT=0")
+}
@@ -40,6 +40,9 @@ import (
 
 // Write writes the given cluster state in the current git repository.
 func (s *Store) Write(ctx context.Context, st *topology.Cluster) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	output, err := git.Branch(branch.List, branch.Format("%(refname:short)"), git.CmdExecutor(s.gitExecutor))
 	if err != nil {
 		return fmt.Errorf("list branches: %w %s", err, output)
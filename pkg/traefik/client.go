@@ -47,6 +47,7 @@ const hostname = "proxy.traefik"
 // Client allows interacting with a Traefik instance.
 type Client struct {
 	baseURL *url.URL
+	tlsCfg  *tls.Config
 
 	httpClient *http.Client
 }
@@ -70,10 +71,16 @@ func NewClient(baseURL string, insecure bool, ca, cert, key string) (*Client, er
 
 	return &Client{
 		baseURL:    u,
+		tlsCfg:     tlsCfg,
 		httpClient: rc.StandardClient(),
 	}, nil
 }
 
+// TLSConfig returns a copy of the TLS configuration used to communicate with Traefik.
+func (c *Client) TLSConfig() *tls.Config {
+	return c.tlsCfg.Clone()
+}
+
 // createTLSConf creates TLS configuration with the provided certificates.
 func createTLSConf(insecure bool, ca, cert, key string) (*tls.Config, error) {
 	if insecure && (ca != "" || cert != "" || key != "") {
@@ -29,18 +29,19 @@ import (
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/rs/zerolog/log"
 	"github.com/traefik/hub-agent-traefik/pkg/logger"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
 // Client allows interacting with the tunnel service.
 type Client struct {
 	baseURL *url.URL
-	token   string
+	token   secret.Token
 
 	httpClient *http.Client
 }
 
 // NewClient creates a new client for the tunnel service.
-func NewClient(baseURL, token string) (*Client, error) {
+func NewClient(baseURL string, token secret.Token, transport http.RoundTripper) (*Client, error) {
 	u, err := url.ParseRequestURI(baseURL)
 	if err != nil {
 		return nil, fmt.Errorf("parse client url: %w", err)
@@ -48,6 +49,7 @@ func NewClient(baseURL, token string) (*Client, error) {
 
 	rc := retryablehttp.NewClient()
 	rc.RetryMax = 4
+	rc.HTTPClient.Transport = transport
 	rc.Logger = logger.NewWrappedLogger(log.Logger.With().Str("component", "tunnel-client").Logger())
 
 	retryClient := rc.StandardClient()
@@ -73,6 +75,8 @@ func (a APIError) Error() string {
 type Endpoint struct {
 	TunnelID       string `json:"tunnelId"`
 	BrokerEndpoint string `json:"brokerEndpoint"`
+	// Transport is the transport to use to reach the broker. Defaults to websocket.
+	Transport string `json:"transport,omitempty"`
 }
 
 // ListClusterTunnelEndpoints lists all tunnels the agent needs to open.
@@ -97,7 +101,7 @@ func (c *Client) ListClusterTunnelEndpoints(ctx context.Context) ([]Endpoint, er
 }
 
 func (c Client) do(req *http.Request, result interface{}) error {
-	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Authorization", "Bearer "+c.token.Value())
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -0,0 +1,271 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// proxyProtocolSignature is the signature starting a PROXY protocol v2 header.
+var proxyProtocolSignature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// pooledConnMaxIdle is the duration after which a pooled connection is not used anymore, as Traefik may have closed it.
+const pooledConnMaxIdle = 30 * time.Second
+
+// traefikDialer dials the Traefik tunnel entrypoint for each stream accepted from a broker.
+type traefikDialer struct {
+	addr          string
+	tlsConfig     *tls.Config
+	timeout       time.Duration
+	retries       int
+	proxyProtocol bool
+	pool          *connPool
+}
+
+func newTraefikDialer(addr string, cfg Config) traefikDialer {
+	d := traefikDialer{
+		addr:          addr,
+		tlsConfig:     cfg.TraefikTLSConfig,
+		timeout:       cfg.TraefikDialTimeout,
+		retries:       cfg.TraefikDialRetries,
+		proxyProtocol: cfg.TraefikProxyProtocol,
+	}
+
+	if cfg.TraefikPoolSize > 0 {
+		d.pool = newConnPool(cfg.TraefikPoolSize, pooledConnMaxIdle, d.dial)
+	}
+
+	return d
+}
+
+// Dial dials Traefik on behalf of the given stream, retrying with a short backoff on failure. A pooled connection is
+// used when one is available. When enabled, the PROXY protocol v2 header sent by the broker at the start of the
+// stream, carrying the address of the client, is forwarded to Traefik first.
+func (d traefikDialer) Dial(stream net.Conn) (net.Conn, error) {
+	var header []byte
+	if d.proxyProtocol {
+		var err error
+		header, err = readProxyProtocolHeader(stream, d.timeout)
+		if err != nil {
+			return nil, fmt.Errorf("read PROXY protocol header: %w", err)
+		}
+	}
+
+	var conn net.Conn
+	if d.pool != nil {
+		conn = d.pool.get()
+	}
+
+	if conn == nil {
+		exp := backoff.NewExponentialBackOff()
+		exp.InitialInterval = 50 * time.Millisecond
+		exp.MaxInterval = time.Second
+
+		var retries uint64
+		if d.retries > 0 {
+			retries = uint64(d.retries)
+		}
+
+		err := backoff.Retry(func() error {
+			var err error
+			conn, err = d.dial()
+
+			return err
+		}, backoff.WithMaxRetries(exp, retries))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if header == nil {
+		return conn, nil
+	}
+
+	if _, err := conn.Write(header); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("write PROXY protocol header: %w", err)
+	}
+
+	return conn, nil
+}
+
+// Close closes the pooled connections.
+func (d traefikDialer) Close() {
+	if d.pool != nil {
+		d.pool.close()
+	}
+}
+
+func (d traefikDialer) dial() (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   d.timeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	if d.tlsConfig == nil {
+		return dialer.Dial("tcp", d.addr)
+	}
+
+	tlsDialer := &tls.Dialer{
+		NetDialer: dialer,
+		Config:    d.tlsConfig,
+	}
+
+	return tlsDialer.DialContext(context.Background(), "tcp", d.addr)
+}
+
+// readProxyProtocolHeader reads a PROXY protocol v2 header from the given connection, waiting for it at most for the
+// given timeout. The header is returned as is.
+func readProxyProtocolHeader(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	if timeout > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+		defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+	}
+
+	// Signature, version and command, family and protocol, and length of the addresses.
+	header := make([]byte, len(proxyProtocolSignature)+4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(header[:len(proxyProtocolSignature)], proxyProtocolSignature) {
+		return nil, errors.New("invalid signature")
+	}
+
+	if version := header[len(proxyProtocolSignature)] >> 4; version != 2 {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+
+	addrLen := binary.BigEndian.Uint16(header[len(header)-2:])
+	addrs := make([]byte, addrLen)
+	if _, err := io.ReadFull(conn, addrs); err != nil {
+		return nil, err
+	}
+
+	return append(header, addrs...), nil
+}
+
+// connPool holds connections to Traefik dialed ahead of the streams, so that streams don't wait for a connection, and
+// its TLS handshake, to be established. Traefik sees each stream as a distinct connection: a connection taken from the
+// pool is never given back.
+type connPool struct {
+	size    int
+	maxIdle time.Duration
+	dial    func() (net.Conn, error)
+
+	mu      sync.Mutex
+	conns   []pooledConn
+	filling bool
+	closed  bool
+}
+
+type pooledConn struct {
+	net.Conn
+
+	dialedAt time.Time
+}
+
+func newConnPool(size int, maxIdle time.Duration, dial func() (net.Conn, error)) *connPool {
+	return &connPool{
+		size:    size,
+		maxIdle: maxIdle,
+		dial:    dial,
+	}
+}
+
+// get returns a pooled connection, or nil if none is available, and refills the pool in the background.
+func (p *connPool) get() net.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var conn net.Conn
+	for conn == nil && len(p.conns) > 0 {
+		pooled := p.conns[0]
+		p.conns = p.conns[1:]
+
+		if time.Since(pooled.dialedAt) > p.maxIdle {
+			_ = pooled.Close()
+			continue
+		}
+
+		conn = pooled.Conn
+	}
+
+	if !p.filling && !p.closed {
+		p.filling = true
+		go p.fill()
+	}
+
+	return conn
+}
+
+func (p *connPool) fill() {
+	for {
+		p.mu.Lock()
+		if p.closed || len(p.conns) >= p.size {
+			p.filling = false
+			p.mu.Unlock()
+			return
+		}
+		p.mu.Unlock()
+
+		conn, err := p.dial()
+
+		p.mu.Lock()
+		if err != nil || p.closed {
+			p.filling = false
+			p.mu.Unlock()
+
+			if err != nil {
+				log.Debug().Err(err).Msg("Unable to fill the Traefik connection pool")
+			} else {
+				_ = conn.Close()
+			}
+			return
+		}
+
+		p.conns = append(p.conns, pooledConn{Conn: conn, dialedAt: time.Now()})
+		p.mu.Unlock()
+	}
+}
+
+// close closes the pooled connections. Connections are not pooled anymore once closed.
+func (p *connPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, pooled := range p.conns {
+		_ = pooled.Close()
+	}
+	p.conns = nil
+}
@@ -0,0 +1,284 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tunnel
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraefikDialer_Dial_retries(t *testing.T) {
+	// Reserve a port and release it, so Traefik is not listening yet on the first attempt.
+	reserved, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"))
+	require.NoError(t, err)
+	addr := reserved.Addr().String()
+	require.NoError(t, reserved.Close())
+
+	_, err = traefikDialer{addr: addr, timeout: time.Second}.Dial(nil)
+	require.Error(t, err)
+
+	listening := make(chan net.Listener)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+
+		traefikMock, lErr := net.Listen("tcp", addr)
+		require.NoError(t, lErr)
+		listening <- traefikMock
+	}()
+
+	conn, err := traefikDialer{addr: addr, timeout: time.Second, retries: 10}.Dial(nil)
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	traefikMock := <-listening
+	_ = traefikMock.Close()
+}
+
+func TestTraefikDialer_Dial_tls(t *testing.T) {
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+
+	dialer := traefikDialer{
+		addr:      srv.Listener.Addr().String(),
+		tlsConfig: &tls.Config{RootCAs: pool, ServerName: "example.com", MinVersion: tls.VersionTLS12},
+		timeout:   time.Second,
+	}
+
+	conn, err := dialer.Dial(nil)
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+
+	tlsConn, ok := conn.(*tls.Conn)
+	require.True(t, ok)
+	assert.True(t, tlsConn.ConnectionState().HandshakeComplete)
+
+	// Without trusting the server certificate, the handshake fails.
+	dialer.tlsConfig = &tls.Config{ServerName: "example.com", MinVersion: tls.VersionTLS12}
+	_, err = dialer.Dial(nil)
+	assert.Error(t, err)
+}
+
+func TestTraefikDialer_Dial_proxyProtocol(t *testing.T) {
+	traefikMock, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"))
+	require.NoError(t, err)
+	defer func() { _ = traefikMock.Close() }()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, aErr := traefikMock.Accept()
+		if aErr != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		b, _ := io.ReadAll(conn)
+		received <- b
+	}()
+
+	stream, broker := net.Pipe()
+	defer func() { _ = broker.Close() }()
+
+	go func() {
+		_, _ = broker.Write(append(proxyProtocolV4Header(), []byte("hello")...))
+	}()
+
+	conn, err := traefikDialer{addr: traefikMock.Addr().String(), timeout: time.Second, proxyProtocol: true}.Dial(stream)
+	require.NoError(t, err)
+
+	// Only the header is consumed from the stream, the rest is proxied as is.
+	b := make([]byte, 5)
+	_, err = io.ReadFull(stream, b)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	_, err = conn.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	assert.Equal(t, append(proxyProtocolV4Header(), []byte("hello")...), <-received)
+}
+
+func TestTraefikDialer_Dial_pool(t *testing.T) {
+	traefikMock, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"))
+	require.NoError(t, err)
+	defer func() { _ = traefikMock.Close() }()
+
+	var accepted int32
+	closed := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, aErr := traefikMock.Accept()
+			if aErr != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+
+			go func() {
+				_, _ = io.ReadAll(conn)
+				closed <- struct{}{}
+			}()
+		}
+	}()
+
+	cfg := Config{TraefikDialTimeout: time.Second, TraefikPoolSize: 2}
+	dialer := newTraefikDialer(traefikMock.Addr().String(), cfg)
+
+	// The first stream dials Traefik, and the pool gets filled.
+	first, err := dialer.Dial(nil)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&accepted) == 3 }, 5*time.Second, 10*time.Millisecond)
+
+	// The next stream takes a pooled connection, which is replaced.
+	second, err := dialer.Dial(nil)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&accepted) == 4 }, 5*time.Second, 10*time.Millisecond)
+
+	_ = first.Close()
+	_ = second.Close()
+
+	// Pooled connections are closed with the dialer.
+	dialer.Close()
+	for i := 0; i < 4; i++ {
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("connection not closed")
+		}
+	}
+}
+
+func TestConnPool_getSkipsExpiredConnections(t *testing.T) {
+	var dialed int32
+	pool := newConnPool(1, 50*time.Millisecond, func() (net.Conn, error) {
+		atomic.AddInt32(&dialed, 1)
+
+		conn, _ := net.Pipe()
+		return conn, nil
+	})
+	defer pool.close()
+
+	assert.Nil(t, pool.get())
+	assert.Eventually(t, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+
+		return len(pool.conns) == 1 && !pool.filling
+	}, time.Second, 5*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+
+	// The pooled connection expired, the stream has to dial Traefik.
+	assert.Nil(t, pool.get())
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&dialed) == 2 }, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+
+		return len(pool.conns) == 1 && !pool.filling
+	}, time.Second, 5*time.Millisecond)
+
+	assert.NotNil(t, pool.get())
+}
+
+func Test_readProxyProtocolHeader(t *testing.T) {
+	signature := "\r\n\r\n\x00\r\nQUIT\n"
+
+	tests := []struct {
+		desc    string
+		data    []byte
+		want    []byte
+		wantErr bool
+	}{
+		{
+			desc: "IPv4",
+			data: append(proxyProtocolV4Header(), []byte("hello")...),
+			want: proxyProtocolV4Header(),
+		},
+		{
+			desc: "LOCAL",
+			data: append([]byte(signature), 0x20, 0x00, 0x00, 0x00, 'h', 'e', 'l', 'l', 'o'),
+			want: append([]byte(signature), 0x20, 0x00, 0x00, 0x00),
+		},
+		{
+			desc:    "no header",
+			data:    []byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"),
+			wantErr: true,
+		},
+		{
+			desc:    "PROXY protocol v1",
+			data:    append([]byte(signature), 0x11, 0x11, 0x00, 0x0c),
+			wantErr: true,
+		},
+		{
+			desc:    "truncated addresses",
+			data:    append([]byte(signature), 0x21, 0x11, 0x00, 0x0c, 203, 0, 113),
+			wantErr: true,
+		},
+		{
+			desc:    "nothing sent",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			t.Parallel()
+
+			conn, broker := net.Pipe()
+			defer func() { _ = conn.Close() }()
+
+			go func() {
+				_, _ = broker.Write(test.data)
+			}()
+
+			got, err := readProxyProtocolHeader(conn, 100*time.Millisecond)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+// proxyProtocolV4Header returns the PROXY protocol v2 header of a connection from 203.0.113.7:51234 to 10.0.0.1:443.
+func proxyProtocolV4Header() []byte {
+	return append([]byte("\r\n\r\n\x00\r\nQUIT\n"),
+		0x21, 0x11, 0x00, 0x0c,
+		203, 0, 113, 7,
+		10, 0, 0, 1,
+		0xc8, 0x22,
+		0x01, 0xbb,
+	)
+}
@@ -0,0 +1,189 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Headers exchanged with brokers on the HTTP/2 transport.
+const (
+	headerTunnelID    = "Hub-Tunnel-Id"
+	headerCompression = "Hub-Tunnel-Compression"
+)
+
+// http2Transport reaches brokers through an HTTP/2 CONNECT request, whose request and response bodies carry the
+// session. Compression is offered with a request header, and used if the broker acknowledges it in the response.
+// Each session gets its own connection to the broker, closed along with the session, so that sessions of a tunnel
+// are not multiplexed over the same connection.
+type http2Transport struct {
+	proxy       func(*http.Request) (*url.URL, error)
+	tlsConfig   *tls.Config
+	compression bool
+}
+
+func newHTTP2Transport(cfg Config) *http2Transport {
+	return &http2Transport{
+		proxy:       cfg.Proxy,
+		tlsConfig:   cfg.TLSConfig,
+		compression: cfg.Compression,
+	}
+}
+
+// Dial sends a CONNECT request to the broker of the given endpoint.
+func (t *http2Transport) Dial(ctx context.Context, endpoint Endpoint, token string) (io.ReadWriteCloser, error) {
+	u, err := url.Parse(endpoint.BrokerEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse broker endpoint: %w", err)
+	}
+
+	// The stream lives as long as the request, which outlives the dial.
+	ctx, cancel := context.WithCancel(ctx)
+
+	bodyReader, bodyWriter := io.Pipe()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodConnect, u.String(), bodyReader)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(headerTunnelID, endpoint.TunnelID)
+	if t.compression {
+		req.Header.Set(headerCompression, compressionDeflate)
+	}
+
+	transport, brokerConn := t.newSessionTransport()
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		cancel()
+		brokerConn.close()
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+		_ = resp.Body.Close()
+		cancel()
+		brokerConn.close()
+		return nil, fmt.Errorf("expected an HTTP/2 %d, got: %s %d", http.StatusOK, resp.Proto, resp.StatusCode)
+	}
+
+	var conn io.ReadWriteCloser = &http2Conn{
+		ReadCloser: resp.Body,
+		writer:     bodyWriter,
+		cancel:     cancel,
+		brokerConn: brokerConn,
+	}
+
+	if t.compression && resp.Header.Get(headerCompression) == compressionDeflate {
+		conn = newCompressedConn(conn)
+	}
+
+	return conn, nil
+}
+
+// newSessionTransport returns an HTTP transport dedicated to a single session, along with the connection it opens to
+// the broker, or to the proxy, so that it can be closed with the session.
+func (t *http2Transport) newSessionTransport() (*http.Transport, *sessionConn) {
+	brokerConn := &sessionConn{}
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		Proxy:           t.proxy,
+		TLSClientConfig: t.tlsConfig,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+
+			brokerConn.set(conn)
+
+			return conn, nil
+		},
+		TLSHandshakeTimeout: 10 * time.Second,
+		ForceAttemptHTTP2:   true,
+	}
+
+	return transport, brokerConn
+}
+
+// sessionConn holds the connection of a session to a broker.
+type sessionConn struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+func (c *sessionConn) set(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		_ = conn.Close()
+		return
+	}
+
+	c.conn = conn
+}
+
+func (c *sessionConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
+
+// http2Conn reads from the response body of a CONNECT request and writes to its request body.
+type http2Conn struct {
+	io.ReadCloser
+
+	writer     *io.PipeWriter
+	cancel     context.CancelFunc
+	brokerConn *sessionConn
+}
+
+// Write writes data to the request body.
+func (c *http2Conn) Write(p []byte) (int, error) {
+	return c.writer.Write(p)
+}
+
+// Close ends the request and closes the connection to the broker.
+func (c *http2Conn) Close() error {
+	_ = c.writer.Close()
+	err := c.ReadCloser.Close()
+	c.cancel()
+	c.brokerConn.close()
+
+	return err
+}
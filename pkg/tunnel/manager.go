@@ -19,19 +19,19 @@ package tunnel
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
-	"path"
+	"sort"
 	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
-	"github.com/hashicorp/yamux"
 	"github.com/rs/zerolog/log"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
 // Backend is able to call hub-tunnel API.
@@ -39,39 +39,129 @@ type Backend interface {
 	ListClusterTunnelEndpoints(ctx context.Context) ([]Endpoint, error)
 }
 
+// State is the connection state of a tunnel.
+type State string
+
+// Tunnel states.
+const (
+	StateConnecting State = "connecting"
+	StateConnected  State = "connected"
+	StateBackoff    State = "backoff"
+)
+
+// Status describes the current state of a tunnel.
+type Status struct {
+	TunnelID       string `json:"tunnelId"`
+	BrokerEndpoint string `json:"brokerEndpoint"`
+	// State is connected if at least one session is connected.
+	State State `json:"state"`
+	// Attempt is the lowest number of consecutive failed connection attempts among the sessions.
+	Attempt  int             `json:"attempt"`
+	Sessions []SessionStatus `json:"sessions"`
+	// Traffic holds the traffic statistics of the tunnel since the agent started.
+	Traffic Stats `json:"traffic"`
+}
+
+// SessionStatus describes the current state of a session of a tunnel.
+type SessionStatus struct {
+	State State `json:"state"`
+	// Attempt is the number of consecutive failed connection attempts.
+	Attempt int `json:"attempt"`
+}
+
+// Config configures tunnels.
+type Config struct {
+	// Sessions is the number of parallel sessions opened for each tunnel.
+	Sessions int
+	// HealthCheckInterval is the interval at which sessions are pinged. Sessions not answering are replaced.
+	HealthCheckInterval time.Duration
+	// DrainTimeout is the time given to active streams to finish when a tunnel is stopped or migrated.
+	DrainTimeout time.Duration
+	// SummaryInterval is the interval at which a summary of the traffic of each tunnel is logged.
+	// No summary is logged when zero.
+	SummaryInterval time.Duration
+
+	// Proxy selects the proxy to use to reach brokers. No proxy is used when nil.
+	Proxy func(*http.Request) (*url.URL, error)
+	// TLSConfig is the TLS configuration used to reach brokers. The default one is used when nil.
+	TLSConfig *tls.Config
+
+	// AcceptBacklog is the maximum number of streams waiting to be accepted on a session.
+	AcceptBacklog int
+	// MaxStreamWindowSize is the maximum receive window size of a stream, in bytes. It can't be lower than
+	// MinStreamWindowSize.
+	MaxStreamWindowSize uint32
+	// Compression enables negotiating the compression of the traffic with brokers.
+	Compression bool
+
+	// TraefikTLSConfig is the TLS configuration used to reach the Traefik tunnel entrypoint. Plain TCP is used when nil.
+	TraefikTLSConfig *tls.Config
+	// TraefikDialTimeout is the timeout of a connection attempt to the Traefik tunnel entrypoint.
+	TraefikDialTimeout time.Duration
+	// TraefikDialRetries is the number of times a failed connection to the Traefik tunnel entrypoint is retried
+	// before giving up on a stream.
+	TraefikDialRetries int
+	// TraefikProxyProtocol enables forwarding to Traefik the PROXY protocol v2 header, carrying the address of the
+	// client, which brokers send at the start of each stream. Streams without a valid header are rejected.
+	TraefikProxyProtocol bool
+	// TraefikPoolSize is the number of connections to the Traefik tunnel entrypoint dialed ahead of the streams,
+	// per tunnel. Connections are dialed on demand when zero.
+	TraefikPoolSize int
+}
+
+// MinStreamWindowSize is the minimum receive window size of a stream, in bytes, which is the initial one of yamux.
+const MinStreamWindowSize = 256 * 1024
+
+// DefaultConfig returns the default tunnel configuration.
+func DefaultConfig() Config {
+	return Config{
+		Sessions:            1,
+		Proxy:               http.ProxyFromEnvironment,
+		HealthCheckInterval: 30 * time.Second,
+		DrainTimeout:        30 * time.Second,
+		SummaryInterval:     5 * time.Minute,
+		AcceptBacklog:       256,
+		MaxStreamWindowSize: MinStreamWindowSize,
+		TraefikDialTimeout:  10 * time.Second,
+		TraefikDialRetries:  2,
+	}
+}
+
 // Manager manages tunnels.
 type Manager struct {
 	client      Backend
 	traefikAddr string
-	token       string
+	token       secret.Token
 	interval    time.Duration
+	intervalCh  chan time.Duration
+	reauthCh    chan struct{}
+	cfg         Config
 
 	tunnelsMu sync.Mutex
 	tunnels   map[string]*tunnel
-}
-
-type tunnel struct {
-	BrokerEndpoint  string
-	ClusterEndpoint string
-	Client          *closeAwareListener
-}
+	stats     map[string]*trafficStats
 
-func (t *tunnel) Close() error {
-	if t.Client != nil {
-		return t.Client.Close()
-	}
+	// lastSummary holds the statistics of each tunnel as of the last logged summary.
+	lastSummary map[string]Stats
 
-	return nil
+	// draining tracks tunnels being drained after having been replaced or removed.
+	draining sync.WaitGroup
 }
 
 // NewManager returns a new manager instance.
-func NewManager(tunnels Backend, traefikAddr, token string, interval time.Duration) Manager {
+// The token is read each time a tunnel is launched.
+func NewManager(tunnels Backend, traefikAddr string, token secret.Token, interval time.Duration, cfg Config) Manager {
 	return Manager{
 		client:      tunnels,
 		traefikAddr: traefikAddr,
 		token:       token,
 		interval:    interval,
+		intervalCh:  make(chan time.Duration, 1),
+		reauthCh:    make(chan struct{}, 1),
+		cfg:         cfg,
 		tunnels:     make(map[string]*tunnel),
+		stats:       make(map[string]*trafficStats),
+		lastSummary: make(map[string]Stats),
 	}
 }
 
@@ -82,6 +172,14 @@ func (m *Manager) Run(ctx context.Context) {
 	ticker := time.NewTicker(m.interval)
 	defer ticker.Stop()
 
+	var summary <-chan time.Time
+	if m.cfg.SummaryInterval > 0 {
+		summaryTicker := time.NewTicker(m.cfg.SummaryInterval)
+		defer summaryTicker.Stop()
+
+		summary = summaryTicker.C
+	}
+
 	if err := m.updateTunnels(ctx); err != nil {
 		log.Error().Err(err).Msg("Unable to update tunnels")
 	}
@@ -94,6 +192,17 @@ func (m *Manager) Run(ctx context.Context) {
 				continue
 			}
 
+		case interval := <-m.intervalCh:
+			ticker.Reset(interval)
+
+		case <-m.reauthCh:
+			if err := m.updateTunnels(ctx); err != nil {
+				log.Error().Err(err).Msg("Unable to re-authenticate tunnels")
+			}
+
+		case <-summary:
+			m.logSummary()
+
 		case <-ctx.Done():
 			m.stop()
 			return
@@ -101,16 +210,111 @@ func (m *Manager) Run(ctx context.Context) {
 	}
 }
 
-func (m *Manager) stop() {
+// SetInterval sets the interval at which tunnel endpoints are fetched. A non-positive interval restores the initial one.
+func (m *Manager) SetInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = m.interval
+	}
+
+	select {
+	case <-m.intervalCh:
+	default:
+	}
+
+	select {
+	case m.intervalCh <- interval:
+	default:
+	}
+}
+
+// Reauthenticate makes the manager replace the tunnels authenticated with a previous token. Each tunnel is
+// drained once its replacement is connected, so no stream gets rejected.
+func (m *Manager) Reauthenticate() {
+	select {
+	case m.reauthCh <- struct{}{}:
+	default:
+	}
+}
+
+// Statuses returns the status of every tunnel, sorted by tunnel ID.
+func (m *Manager) Statuses() []Status {
 	m.tunnelsMu.Lock()
 	defer m.tunnelsMu.Unlock()
 
-	for id, tunnel := range m.tunnels {
-		if err := tunnel.Close(); err != nil {
-			log.Error().Err(err).Msg("Unable to close tunnel")
-		}
+	statuses := make([]Status, 0, len(m.tunnels))
+	for _, t := range m.tunnels {
+		statuses = append(statuses, t.Status())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].TunnelID < statuses[j].TunnelID
+	})
+
+	return statuses
+}
+
+// logSummary logs the traffic of each tunnel since the previous summary.
+func (m *Manager) logSummary() {
+	lastSummary := make(map[string]Stats)
+
+	for _, status := range m.Statuses() {
+		traffic := status.Traffic.Sub(m.lastSummary[status.TunnelID])
+		lastSummary[status.TunnelID] = status.Traffic
+
+		log.Info().
+			Str("tunnel_id", status.TunnelID).
+			Str("broker_endpoint", status.BrokerEndpoint).
+			Str("state", string(status.State)).
+			Int64("active_streams", traffic.ActiveStreams).
+			Int64("streams", traffic.TotalStreams).
+			Int64("bytes_in", traffic.BytesIn).
+			Int64("bytes_out", traffic.BytesOut).
+			Int64("dial_failures", traffic.DialFailures).
+			Dur("avg_stream_duration", traffic.AvgStreamDuration()).
+			Msg("Tunnel traffic summary")
+	}
+
+	m.lastSummary = lastSummary
+}
+
+// stop drains all the tunnels and waits for them to be closed.
+func (m *Manager) stop() {
+	m.tunnelsMu.Lock()
+	for id, tun := range m.tunnels {
+		m.drain(tun, nil)
 		delete(m.tunnels, id)
+		delete(m.stats, id)
 	}
+	m.tunnelsMu.Unlock()
+
+	m.draining.Wait()
+}
+
+// drain drains the given tunnel in the background. If next is not nil, the tunnel is drained once next
+// is connected, or once the drain timeout is elapsed.
+func (m *Manager) drain(tun, next *tunnel) {
+	m.draining.Add(1)
+
+	go func() {
+		defer m.draining.Done()
+
+		if next != nil {
+			select {
+			case <-next.connected:
+			case <-time.After(m.cfg.DrainTimeout):
+				log.Warn().Str("tunnel_id", next.TunnelID).
+					Str("broker_endpoint", next.BrokerEndpoint).
+					Msg("New tunnel still not connected, draining the previous one anyway")
+			}
+		}
+
+		if err := tun.Drain(m.cfg.DrainTimeout); err != nil {
+			log.Error().Err(err).
+				Str("tunnel_id", tun.TunnelID).
+				Str("broker_endpoint", tun.BrokerEndpoint).
+				Msg("Unable to drain tunnel")
+		}
+	}()
 }
 
 func (m *Manager) updateTunnels(ctx context.Context) error {
@@ -132,119 +336,77 @@ func (m *Manager) updateTunnels(ctx context.Context) error {
 
 		tun, found := m.tunnels[endpoint.TunnelID]
 		if !found {
-			m.launchTunnel(endpoint)
+			if _, err = m.launchTunnel(ctx, endpoint); err != nil {
+				logger.Error().Err(err).Msg("Unable to launch tunnel")
+			}
 			continue
 		}
 
-		if tun.BrokerEndpoint != endpoint.BrokerEndpoint {
-			if err = tun.Close(); err != nil {
-				logger.Error().Err(err).Msg("Unable to close tunnel")
-			}
-			delete(m.tunnels, endpoint.TunnelID)
+		switch {
+		case tun.BrokerEndpoint != endpoint.BrokerEndpoint || tun.Transport != endpoint.Transport:
+			logger.Info().
+				Str("previous_broker_endpoint", tun.BrokerEndpoint).
+				Str("transport", endpoint.Transport).
+				Msg("Migrating tunnel to a new broker")
+		case tun.token != m.token.Value():
+			logger.Info().Msg("Re-authenticating tunnel with the new token")
+		default:
+			continue
+		}
 
-			m.launchTunnel(endpoint)
+		// Connect the new tunnel before draining the previous connection, so no stream gets rejected.
+		next, err := m.launchTunnel(ctx, endpoint)
+		if err != nil {
+			logger.Error().Err(err).Msg("Unable to launch tunnel")
+			continue
 		}
+
+		m.drain(tun, next)
 	}
 
-	for id := range m.tunnels {
+	for id, tun := range m.tunnels {
 		if _, found := currentTunnels[id]; !found {
-			if err = m.tunnels[id].Close(); err != nil {
-				log.Error().Err(err).
-					Str("tunnel_id", id).
-					Msg("Unable to close tunnel")
-			}
+			m.drain(tun, nil)
 			delete(m.tunnels, id)
+			delete(m.stats, id)
 		}
 	}
 
 	return nil
 }
 
-func (m *Manager) launchTunnel(endpoint Endpoint) {
-	t := &tunnel{BrokerEndpoint: endpoint.BrokerEndpoint}
-	m.tunnels[endpoint.TunnelID] = t
-
-	go func(t *tunnel, tunnelID string) {
-		err := t.launch(tunnelID, m.token, m.traefikAddr)
-		if err != nil {
-			log.Error().Err(err).Str("tunnel_id", tunnelID).Msg("Launch tunnel")
-		}
-
-		m.tunnelsMu.Lock()
-		delete(m.tunnels, tunnelID)
-		m.tunnelsMu.Unlock()
-	}(t, endpoint.TunnelID)
-}
-
-func (t *tunnel) launch(tunnelID, token, traefikAddr string) error {
-	u, err := url.Parse(t.BrokerEndpoint)
-	if err != nil {
-		return fmt.Errorf("parse broker endpoint: %w", err)
-	}
-	u.Path = path.Join(u.Path, tunnelID)
-
-	dialer := websocket.Dialer{
-		Proxy:            http.ProxyFromEnvironment,
-		HandshakeTimeout: 30 * time.Second,
-	}
-	connSocket, resp, err := dialer.Dial(u.String(), http.Header{"Authorization": []string{"Bearer " + token}})
-	if err != nil {
-		return fmt.Errorf("dial: %w", err)
-	}
-
-	if resp.StatusCode != http.StatusSwitchingProtocols {
-		return fmt.Errorf("expected protocol switching, got: %d", resp.StatusCode)
+// launchTunnel starts a tunnel for the given endpoint. The tunnel keeps reconnecting until it gets closed.
+func (m *Manager) launchTunnel(ctx context.Context, endpoint Endpoint) (*tunnel, error) {
+	stats, ok := m.stats[endpoint.TunnelID]
+	if !ok {
+		stats = &trafficStats{}
 	}
 
-	conn := &websocketNetConn{
-		Conn: connSocket,
-	}
-
-	cfg := &yamux.Config{
-		AcceptBacklog:          256,
-		EnableKeepAlive:        true,
-		KeepAliveInterval:      30 * time.Second,
-		ConnectionWriteTimeout: 10 * time.Second,
-		MaxStreamWindowSize:    256 * 1024,
-		StreamOpenTimeout:      75 * time.Second,
-		StreamCloseTimeout:     5 * time.Minute,
-		LogOutput:              io.Discard,
-	}
-	client, err := yamux.Client(conn, cfg)
+	t, err := newTunnel(endpoint, m.token.Value(), m.traefikAddr, m.cfg, stats)
 	if err != nil {
-		return fmt.Errorf("new yamux client: %w", err)
+		return nil, err
 	}
 
-	t.Client = &closeAwareListener{Listener: client}
-
-	for {
-		brokerConn, acceptErr := t.Client.Accept()
-		if acceptErr != nil {
-			if errors.Is(acceptErr, errListenerClosed) {
-				return nil
-			}
+	m.stats[endpoint.TunnelID] = stats
+	m.tunnels[endpoint.TunnelID] = t
 
-			return fmt.Errorf("accept: %w", acceptErr)
-		}
+	t.start(ctx)
 
-		go func(brokerConn net.Conn) {
-			if err = proxy(brokerConn, traefikAddr); err != nil {
-				log.Error().Err(err).Msg("Unable to proxy to Traefik")
-			}
-		}(brokerConn)
-	}
+	return t, nil
 }
 
-func proxy(sourceConn net.Conn, addr string) error {
-	targetConn, err := net.Dial("tcp", addr)
+func proxy(sourceConn net.Conn, dialer traefikDialer, stats *trafficStats) error {
+	targetConn, err := dialer.Dial(sourceConn)
 	if err != nil {
+		stats.dialFailed()
+		_ = sourceConn.Close()
 		return fmt.Errorf("dial: %w", err)
 	}
 
 	errCh := make(chan error)
 
-	go connCopy(errCh, targetConn, sourceConn)
-	go connCopy(errCh, sourceConn, targetConn)
+	go connCopy(errCh, targetConn, sourceConn, &stats.bytesIn)
+	go connCopy(errCh, sourceConn, targetConn, &stats.bytesOut)
 
 	err = <-errCh
 	<-errCh
@@ -256,8 +418,8 @@ func proxy(sourceConn net.Conn, addr string) error {
 	return nil
 }
 
-func connCopy(errCh chan<- error, dst io.WriteCloser, src io.Reader) {
-	_, err := io.Copy(dst, src)
+func connCopy(errCh chan<- error, dst io.WriteCloser, src io.Reader, count *int64) {
+	_, err := io.Copy(countingWriter{Writer: dst, count: count}, src)
 	errCh <- err
 
 	if err = dst.Close(); err != nil {
@@ -24,6 +24,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -31,6 +33,7 @@ import (
 	"github.com/hashicorp/yamux"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/traefik/hub-agent-traefik/pkg/secret"
 )
 
 func TestManager_updateTunnels(t *testing.T) {
@@ -69,19 +72,9 @@ func TestManager_updateTunnels(t *testing.T) {
 		}, nil).Once().
 		Parent
 
-	c := fakeClient(t)
-
-	manager := NewManager(client, traefikMockAddr, "token", time.Minute)
-	manager.tunnels["current-tunnel-new-broker"] = &tunnel{
-		BrokerEndpoint:  "old-endpoint",
-		ClusterEndpoint: "old-endpoint",
-		Client:          &closeAwareListener{Listener: c},
-	}
-	manager.tunnels["unused-tunnel"] = &tunnel{
-		BrokerEndpoint:  "old-endpoint",
-		ClusterEndpoint: "old-endpoint",
-		Client:          &closeAwareListener{Listener: c},
-	}
+	manager := NewManager(client, traefikMockAddr, secret.StaticToken("token"), time.Minute, testConfig())
+	manager.launchTunnel(ctx, Endpoint{TunnelID: "current-tunnel-new-broker", BrokerEndpoint: "old-endpoint"})
+	manager.launchTunnel(ctx, Endpoint{TunnelID: "unused-tunnel", BrokerEndpoint: "old-endpoint"})
 
 	stopped := make(chan struct{})
 	go func() {
@@ -114,6 +107,315 @@ func TestManager_updateTunnels(t *testing.T) {
 	manager.tunnelsMu.Unlock()
 }
 
+func TestManager_tunnelReconnects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wait := make(chan struct{})
+	traefikMockAddr := launchTraefikMock(t, wait, "rTunnel")
+
+	var connections int32
+	stableBroker := buildBroker(t, []byte("rTunnel"), "reconnecting-tunnel")
+	broker := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// Drop the first connection to simulate a broker hiccup.
+		if atomic.AddInt32(&connections, 1) == 1 {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		stableBroker.Config.Handler.ServeHTTP(rw, req)
+	}))
+	defer broker.Close()
+
+	brokerURL, err := url.Parse(broker.URL)
+	require.NoError(t, err)
+
+	client := newBackendMock(t).OnListClusterTunnelEndpoints().TypedReturns(
+		[]Endpoint{
+			{
+				TunnelID:       "reconnecting-tunnel",
+				BrokerEndpoint: "ws://" + brokerURL.Host,
+			},
+		}, nil).Once().
+		Parent
+
+	manager := NewManager(client, traefikMockAddr, secret.StaticToken("token"), time.Minute, testConfig())
+
+	stopped := make(chan struct{})
+	go func() {
+		manager.Run(ctx)
+		close(stopped)
+	}()
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	case <-wait:
+	}
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&connections))
+
+	statuses := manager.Statuses()
+	require.Len(t, statuses, 1)
+
+	// The broker keeps on streaming, traffic statistics are covered separately.
+	statuses[0].Traffic = Stats{}
+
+	assert.Equal(t, []Status{
+		{
+			TunnelID:       "reconnecting-tunnel",
+			BrokerEndpoint: "ws://" + brokerURL.Host,
+			State:          StateConnected,
+			Attempt:        0,
+			Sessions: []SessionStatus{
+				{State: StateConnected, Attempt: 0},
+			},
+		},
+	}, statuses)
+
+	cancel()
+	<-stopped
+
+	assert.Empty(t, manager.Statuses())
+}
+
+func TestManager_logSummary(t *testing.T) {
+	manager := NewManager(nil, "", secret.StaticToken("token"), time.Minute, testConfig())
+
+	stats := &trafficStats{totalStreams: 3, bytesIn: 100, bytesOut: 1000, streamDuration: int64(3 * time.Second)}
+	manager.stats["tunnel"] = stats
+	tun, err := newTunnel(Endpoint{TunnelID: "tunnel", BrokerEndpoint: "ws://broker"}, "token", "", testConfig(), stats)
+	require.NoError(t, err)
+	manager.tunnels["tunnel"] = tun
+
+	manager.logSummary()
+	assert.Equal(t, map[string]Stats{"tunnel": stats.Stats()}, manager.lastSummary)
+
+	stats.streamOpened()
+	stats.streamOpened()
+	stats.streamClosed(time.Second)
+	stats.dialFailed()
+
+	assert.Equal(t, Stats{
+		ActiveStreams:  1,
+		TotalStreams:   2,
+		DialFailures:   1,
+		StreamDuration: time.Second,
+	}, stats.Stats().Sub(manager.lastSummary["tunnel"]))
+
+	// Removed tunnels are forgotten.
+	delete(manager.tunnels, "tunnel")
+	manager.logSummary()
+	assert.Empty(t, manager.lastSummary)
+}
+
+func TestTunnel_acceptsStreamsFromAllSessions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	traefikMock, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"))
+	require.NoError(t, err)
+	defer func() { _ = traefikMock.Close() }()
+
+	proxied := make(chan string)
+	go func() {
+		for {
+			conn, aErr := traefikMock.Accept()
+			if aErr != nil {
+				return
+			}
+
+			b := make([]byte, 1)
+			_, _ = conn.Read(b)
+			proxied <- string(b)
+		}
+	}()
+
+	var connections int32
+	broker := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		id := atomic.AddInt32(&connections, 1)
+
+		upgrader := &websocket.Upgrader{}
+		websocketConn, uErr := upgrader.Upgrade(rw, req, nil)
+		require.NoError(t, uErr)
+
+		cfg := yamux.DefaultConfig()
+		cfg.LogOutput = io.Discard
+		server, sErr := yamux.Server(&websocketNetConn{Conn: websocketConn}, cfg)
+		require.NoError(t, sErr)
+
+		stream, oErr := server.Open()
+		require.NoError(t, oErr)
+
+		_, _ = stream.Write([]byte{'0' + byte(id)})
+
+		<-req.Context().Done()
+	}))
+	defer broker.Close()
+
+	cfg := testConfig()
+	cfg.Sessions = 3
+
+	tun, err := newTunnel(Endpoint{TunnelID: "tunnel", BrokerEndpoint: "ws://" + strings.TrimPrefix(broker.URL, "http://")}, "token", traefikMock.Addr().String(), cfg, &trafficStats{})
+	require.NoError(t, err)
+	tun.start(ctx)
+
+	received := make(map[string]struct{})
+	for len(received) < 3 {
+		select {
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		case msg := <-proxied:
+			received[msg] = struct{}{}
+		}
+	}
+
+	assert.Equal(t, map[string]struct{}{"1": {}, "2": {}, "3": {}}, received)
+
+	status := tun.Status()
+	assert.Equal(t, StateConnected, status.State)
+	assert.Len(t, status.Sessions, 3)
+
+	require.NoError(t, tun.Close())
+}
+
+func TestTunnel_DrainWaitsForActiveStreams(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Traefik answers slowly, so the stream is still active when the drain starts.
+	traefikMock, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"))
+	require.NoError(t, err)
+	defer func() { _ = traefikMock.Close() }()
+
+	proxied := make(chan struct{})
+	go func() {
+		conn, aErr := traefikMock.Accept()
+		if aErr != nil {
+			return
+		}
+
+		b := make([]byte, 5)
+		_, _ = conn.Read(b)
+		close(proxied)
+
+		time.Sleep(300 * time.Millisecond)
+
+		_, _ = conn.Write([]byte("world"))
+		_ = conn.Close()
+	}()
+
+	received := make(chan []byte, 1)
+	broker := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		upgrader := &websocket.Upgrader{}
+		websocketConn, uErr := upgrader.Upgrade(rw, req, nil)
+		require.NoError(t, uErr)
+
+		cfg := yamux.DefaultConfig()
+		cfg.LogOutput = io.Discard
+		server, sErr := yamux.Server(&websocketNetConn{Conn: websocketConn}, cfg)
+		require.NoError(t, sErr)
+
+		stream, oErr := server.Open()
+		require.NoError(t, oErr)
+
+		_, wErr := stream.Write([]byte("hello"))
+		require.NoError(t, wErr)
+
+		resp, _ := io.ReadAll(stream)
+		_ = stream.Close()
+		received <- resp
+	}))
+	defer broker.Close()
+
+	tun, err := newTunnel(Endpoint{TunnelID: "tunnel", BrokerEndpoint: "ws://" + strings.TrimPrefix(broker.URL, "http://")}, "token", traefikMock.Addr().String(), testConfig(), &trafficStats{})
+	require.NoError(t, err)
+	tun.start(ctx)
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	case <-proxied:
+	}
+
+	start := time.Now()
+	require.NoError(t, tun.Drain(5*time.Second))
+
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, []byte("world"), <-received)
+
+	// The stream is accounted once the proxy goroutine is done, which may happen after the drain.
+	assert.Eventually(t, func() bool {
+		return tun.Status().Traffic.ActiveStreams == 0
+	}, time.Second, 10*time.Millisecond)
+
+	traffic := tun.Status().Traffic
+	assert.Equal(t, int64(1), traffic.TotalStreams)
+	assert.Equal(t, int64(5), traffic.BytesIn)
+	assert.Equal(t, int64(5), traffic.BytesOut)
+	assert.Equal(t, int64(0), traffic.DialFailures)
+	assert.GreaterOrEqual(t, traffic.AvgStreamDuration(), 200*time.Millisecond)
+}
+
+func TestTunnel_forwardsBrokerProxyProtocolHeader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	traefikMock, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"))
+	require.NoError(t, err)
+	defer func() { _ = traefikMock.Close() }()
+
+	proxied := make(chan []byte, 1)
+	go func() {
+		conn, aErr := traefikMock.Accept()
+		if aErr != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		b := make([]byte, len(proxyProtocolV4Header())+5)
+		_, _ = io.ReadFull(conn, b)
+		proxied <- b
+	}()
+
+	broker := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		upgrader := &websocket.Upgrader{}
+		websocketConn, uErr := upgrader.Upgrade(rw, req, nil)
+		require.NoError(t, uErr)
+
+		cfg := yamux.DefaultConfig()
+		cfg.LogOutput = io.Discard
+		server, sErr := yamux.Server(&websocketNetConn{Conn: websocketConn}, cfg)
+		require.NoError(t, sErr)
+
+		stream, oErr := server.Open()
+		require.NoError(t, oErr)
+
+		// The broker announces the address of the client before its traffic.
+		_, _ = stream.Write(append(proxyProtocolV4Header(), []byte("hello")...))
+
+		<-req.Context().Done()
+	}))
+	defer broker.Close()
+
+	cfg := testConfig()
+	cfg.TraefikProxyProtocol = true
+
+	tun, err := newTunnel(Endpoint{TunnelID: "tunnel", BrokerEndpoint: "ws://" + strings.TrimPrefix(broker.URL, "http://")}, "token", traefikMock.Addr().String(), cfg, &trafficStats{})
+	require.NoError(t, err)
+	tun.start(ctx)
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	case got := <-proxied:
+		assert.Equal(t, append(proxyProtocolV4Header(), []byte("hello")...), got)
+	}
+
+	require.NoError(t, tun.Close())
+}
+
 func Test_proxy(t *testing.T) {
 	echoListener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"))
 	require.NoError(t, err)
@@ -136,11 +438,15 @@ func Test_proxy(t *testing.T) {
 	require.NoError(t, err)
 
 	// Start proxy server.
+	stats := &trafficStats{}
+	proxied := make(chan struct{})
 	go func() {
+		defer close(proxied)
+
 		conn, aerr := proxyListener.Accept()
 		require.NoError(t, aerr)
 
-		perr := proxy(conn, echoListener.Addr().String())
+		perr := proxy(conn, traefikDialer{addr: echoListener.Addr().String()}, stats)
 		require.NoError(t, perr)
 	}()
 
@@ -164,6 +470,11 @@ func Test_proxy(t *testing.T) {
 	assert.Equal(t, len(message), read)
 
 	assert.Equal(t, message, received[:read])
+
+	_ = conn.Close()
+	<-proxied
+
+	assert.Equal(t, Stats{BytesIn: 5, BytesOut: 5}, stats.Stats())
 }
 
 func Test_proxy_targetUnreachable(t *testing.T) {
@@ -193,8 +504,105 @@ func Test_proxy_targetUnreachable(t *testing.T) {
 
 	<-ready
 
-	err = proxy(proxyConn, "127.0.0.1:44444")
+	stats := &trafficStats{}
+	err = proxy(proxyConn, traefikDialer{addr: "127.0.0.1:44444"}, stats)
 	require.Error(t, err)
+
+	assert.Equal(t, Stats{DialFailures: 1}, stats.Stats())
+}
+
+type tokenMock struct {
+	value atomic.Value
+}
+
+func (t *tokenMock) Value() string {
+	return t.value.Load().(string)
+}
+
+func TestManager_reauthenticate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	endpoint := Endpoint{TunnelID: "tunnel", BrokerEndpoint: "ws://127.0.0.1:1"}
+	client := newBackendMock(t).OnListClusterTunnelEndpoints().TypedReturns([]Endpoint{endpoint}, nil).
+		Parent
+
+	token := &tokenMock{}
+	token.value.Store("token-1")
+
+	manager := NewManager(client, "", token, time.Minute, testConfig())
+	previous, err := manager.launchTunnel(ctx, endpoint)
+	require.NoError(t, err)
+
+	// Tunnels authenticated with the current token are kept.
+	require.NoError(t, manager.updateTunnels(ctx))
+	assert.Same(t, previous, manager.tunnels["tunnel"])
+
+	token.value.Store("token-2")
+	require.NoError(t, manager.updateTunnels(ctx))
+
+	next := manager.tunnels["tunnel"]
+	assert.NotSame(t, previous, next)
+	assert.Equal(t, "token-2", next.token)
+
+	// The previous tunnel is drained, even though the new one never gets connected.
+	select {
+	case <-previous.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("previous tunnel not drained")
+	}
+
+	cancel()
+	manager.stop()
+}
+
+func TestManager_migrateBroker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wait := make(chan struct{})
+	traefikMockAddr := launchTraefikMock(t, wait, "cTunnel")
+
+	broker := buildBroker(t, []byte("cTunnel"), "current-tunnel")
+	brokerURL, err := url.Parse(broker.URL)
+	require.NoError(t, err)
+
+	endpoint := Endpoint{TunnelID: "current-tunnel", BrokerEndpoint: "ws://" + brokerURL.Host}
+	client := newBackendMock(t).OnListClusterTunnelEndpoints().TypedReturns([]Endpoint{endpoint}, nil).
+		Parent
+
+	manager := NewManager(client, traefikMockAddr, secret.StaticToken("token"), time.Minute, testConfig())
+	previous, err := manager.launchTunnel(ctx, Endpoint{TunnelID: "current-tunnel", BrokerEndpoint: "ws://127.0.0.1:1"})
+	require.NoError(t, err)
+
+	require.NoError(t, manager.updateTunnels(ctx))
+
+	next := manager.tunnels["current-tunnel"]
+	assert.NotSame(t, previous, next)
+	assert.Equal(t, endpoint.BrokerEndpoint, next.BrokerEndpoint)
+
+	select {
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	case <-wait:
+	}
+
+	// The previous tunnel is drained once the new one is connected.
+	select {
+	case <-previous.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("previous tunnel not drained")
+	}
+
+	cancel()
+	manager.stop()
+}
+
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.DrainTimeout = 100 * time.Millisecond
+
+	return cfg
 }
 
 func launchTraefikMock(t *testing.T, wait chan struct{}, messages ...string) string {
@@ -236,17 +644,6 @@ func contains(haystack []string, needle string) bool {
 	return false
 }
 
-func fakeClient(t *testing.T) *yamux.Session {
-	t.Helper()
-
-	cfg := yamux.DefaultConfig()
-	cfg.LogOutput = io.Discard
-	c, err := yamux.Client(&readWriteCloseMock{}, cfg)
-	require.NoError(t, err)
-
-	return c
-}
-
 func buildBroker(t *testing.T, message []byte, tunnelID string) *httptest.Server {
 	t.Helper()
 
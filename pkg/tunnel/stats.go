@@ -0,0 +1,119 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tunnel
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the traffic statistics of a tunnel.
+type Stats struct {
+	// ActiveStreams is the number of streams currently proxied to Traefik.
+	ActiveStreams int64 `json:"activeStreams"`
+	// TotalStreams is the number of streams accepted from the broker.
+	TotalStreams int64 `json:"totalStreams"`
+	// BytesIn is the number of bytes received from the broker and sent to Traefik.
+	BytesIn int64 `json:"bytesIn"`
+	// BytesOut is the number of bytes received from Traefik and sent to the broker.
+	BytesOut int64 `json:"bytesOut"`
+	// DialFailures is the number of streams which could not be proxied because Traefik could not be reached.
+	DialFailures int64 `json:"dialFailures"`
+	// StreamDuration is the cumulated duration of the closed streams.
+	StreamDuration time.Duration `json:"streamDuration"`
+}
+
+// ClosedStreams returns the number of streams which are done.
+func (s Stats) ClosedStreams() int64 {
+	return s.TotalStreams - s.ActiveStreams
+}
+
+// AvgStreamDuration returns the average duration of the closed streams.
+func (s Stats) AvgStreamDuration() time.Duration {
+	closed := s.ClosedStreams()
+	if closed <= 0 {
+		return 0
+	}
+
+	return s.StreamDuration / time.Duration(closed)
+}
+
+// Sub returns the statistics accumulated since prev. The number of active streams is left as is.
+func (s Stats) Sub(prev Stats) Stats {
+	return Stats{
+		ActiveStreams:  s.ActiveStreams,
+		TotalStreams:   s.TotalStreams - prev.TotalStreams,
+		BytesIn:        s.BytesIn - prev.BytesIn,
+		BytesOut:       s.BytesOut - prev.BytesOut,
+		DialFailures:   s.DialFailures - prev.DialFailures,
+		StreamDuration: s.StreamDuration - prev.StreamDuration,
+	}
+}
+
+// trafficStats accumulates the traffic statistics of a tunnel. It's shared by all the sessions of a tunnel, and by
+// the successive tunnels opened for a tunnel ID, so statistics survive broker migrations.
+type trafficStats struct {
+	activeStreams  int64
+	totalStreams   int64
+	bytesIn        int64
+	bytesOut       int64
+	dialFailures   int64
+	streamDuration int64
+}
+
+// Stats returns a snapshot of the statistics.
+func (t *trafficStats) Stats() Stats {
+	return Stats{
+		ActiveStreams:  atomic.LoadInt64(&t.activeStreams),
+		TotalStreams:   atomic.LoadInt64(&t.totalStreams),
+		BytesIn:        atomic.LoadInt64(&t.bytesIn),
+		BytesOut:       atomic.LoadInt64(&t.bytesOut),
+		DialFailures:   atomic.LoadInt64(&t.dialFailures),
+		StreamDuration: time.Duration(atomic.LoadInt64(&t.streamDuration)),
+	}
+}
+
+func (t *trafficStats) streamOpened() {
+	atomic.AddInt64(&t.totalStreams, 1)
+	atomic.AddInt64(&t.activeStreams, 1)
+}
+
+func (t *trafficStats) streamClosed(d time.Duration) {
+	atomic.AddInt64(&t.streamDuration, int64(d))
+	atomic.AddInt64(&t.activeStreams, -1)
+}
+
+func (t *trafficStats) dialFailed() {
+	atomic.AddInt64(&t.dialFailures, 1)
+}
+
+// countingWriter counts the bytes written to the underlying writer as they are written,
+// so statistics are up-to-date while long-lived streams are still active.
+type countingWriter struct {
+	io.Writer
+
+	count *int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	atomic.AddInt64(w.count, int64(n))
+
+	return n, err
+}
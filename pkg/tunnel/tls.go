@@ -0,0 +1,154 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// ALPN protocols negotiated with brokers on the TLS transport.
+const (
+	alpnTunnel        = "hub-tunnel"
+	alpnTunnelDeflate = "hub-tunnel+" + compressionDeflate
+)
+
+// tlsHandshake is sent by the agent to authenticate on the TLS transport, as a single JSON line.
+type tlsHandshake struct {
+	TunnelID string `json:"tunnelId"`
+	Token    string `json:"token"`
+}
+
+// tlsHandshakeResp is sent back by the broker, as a single JSON line. The tunnel is open if it holds no error.
+type tlsHandshakeResp struct {
+	Error string `json:"error,omitempty"`
+}
+
+// tlsTransport reaches brokers over a raw TLS connection. The agent authenticates with a JSON handshake, and
+// compression is negotiated with ALPN.
+type tlsTransport struct {
+	cfg Config
+}
+
+func newTLSTransport(cfg Config) *tlsTransport {
+	return &tlsTransport{cfg: cfg}
+}
+
+// Dial opens a TLS connection to the broker of the given endpoint and authenticates.
+func (t *tlsTransport) Dial(ctx context.Context, endpoint Endpoint, token string) (io.ReadWriteCloser, error) {
+	u, err := url.Parse(endpoint.BrokerEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse broker endpoint: %w", err)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	conn, err := dialThroughProxy(ctx, t.cfg.Proxy, addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if t.cfg.TLSConfig != nil {
+		tlsConfig = t.cfg.TLSConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	tlsConfig.NextProtos = []string{alpnTunnel}
+	if t.cfg.Compression {
+		tlsConfig.NextProtos = []string{alpnTunnelDeflate, alpnTunnel}
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("TLS handshake: %w", err)
+	}
+
+	reader, err := authenticate(ctx, tlsConn, tlsHandshake{TunnelID: endpoint.TunnelID, Token: token})
+	if err != nil {
+		_ = tlsConn.Close()
+		return nil, err
+	}
+
+	var rwc io.ReadWriteCloser = &bufferedConn{Conn: tlsConn, reader: reader}
+	if tlsConn.ConnectionState().NegotiatedProtocol == alpnTunnelDeflate {
+		rwc = newCompressedConn(rwc)
+	}
+
+	return rwc, nil
+}
+
+// authenticate sends the handshake on the given connection and waits for the broker to accept it.
+// It returns the reader to use from now on, which may hold data sent by the broker after its response.
+func authenticate(ctx context.Context, conn net.Conn, handshake tlsHandshake) (*bufio.Reader, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+
+	if err := json.NewEncoder(conn).Encode(handshake); err != nil {
+		return nil, fmt.Errorf("write handshake: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("read handshake response: %w", err)
+	}
+
+	var resp tlsHandshakeResp
+	if err = json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("decode handshake response: %w", err)
+	}
+
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+
+	return reader, nil
+}
+
+// bufferedConn is a net.Conn whose reads go through a buffered reader.
+type bufferedConn struct {
+	net.Conn
+
+	reader *bufio.Reader
+}
+
+// Read reads data from the buffered reader.
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
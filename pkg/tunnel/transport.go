@@ -0,0 +1,239 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tunnel
+
+import (
+	"bufio"
+	"compress/flate"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	netproxy "golang.org/x/net/proxy"
+)
+
+// Transports a tunnel can be opened with.
+const (
+	TransportWebSocket = "websocket"
+	TransportHTTP2     = "http2"
+	TransportTLS       = "tls"
+)
+
+// compressionDeflate is the name of the compression negotiated with brokers.
+const compressionDeflate = "deflate"
+
+// Transport opens the connection to a broker over which a tunnel session is multiplexed.
+type Transport interface {
+	Dial(ctx context.Context, endpoint Endpoint, token string) (io.ReadWriteCloser, error)
+}
+
+// newTransport returns the transport to use to reach the broker of the given endpoint.
+// The websocket transport is used when the endpoint doesn't specify one.
+func newTransport(endpoint Endpoint, cfg Config) (Transport, error) {
+	switch endpoint.Transport {
+	case "", TransportWebSocket:
+		return newWebSocketTransport(cfg), nil
+	case TransportHTTP2:
+		return newHTTP2Transport(cfg), nil
+	case TransportTLS:
+		return newTLSTransport(cfg), nil
+	default:
+		return nil, fmt.Errorf("unsupported transport %q", endpoint.Transport)
+	}
+}
+
+// flateWriterPool pools flate writers, which are expensive to allocate.
+var flateWriterPool sync.Pool
+
+// compressedConn compresses what is written to the underlying connection and decompresses what is read from it.
+// Every write is flushed, so frames written by yamux are not held back.
+type compressedConn struct {
+	io.ReadWriteCloser
+
+	reader io.ReadCloser
+
+	writerMu sync.Mutex
+	writer   *flate.Writer
+}
+
+func newCompressedConn(conn io.ReadWriteCloser) *compressedConn {
+	writer, ok := flateWriterPool.Get().(*flate.Writer)
+	if ok {
+		writer.Reset(conn)
+	} else {
+		// Never fails with a valid compression level.
+		writer, _ = flate.NewWriter(conn, flate.BestSpeed)
+	}
+
+	return &compressedConn{
+		ReadWriteCloser: conn,
+		reader:          flate.NewReader(conn),
+		writer:          writer,
+	}
+}
+
+// Read reads decompressed data from the connection.
+func (c *compressedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// Write compresses the given data and writes it to the connection.
+func (c *compressedConn) Write(p []byte) (int, error) {
+	c.writerMu.Lock()
+	defer c.writerMu.Unlock()
+
+	if c.writer == nil {
+		return 0, io.ErrClosedPipe
+	}
+
+	n, err := c.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	if err = c.writer.Flush(); err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+// Close closes the connection and releases the compression resources.
+func (c *compressedConn) Close() error {
+	c.writerMu.Lock()
+	if c.writer != nil {
+		c.writer.Reset(io.Discard)
+		flateWriterPool.Put(c.writer)
+		c.writer = nil
+	}
+	c.writerMu.Unlock()
+
+	_ = c.reader.Close()
+
+	return c.ReadWriteCloser.Close()
+}
+
+// dialThroughProxy opens a TCP connection to the given address, through the proxy selected for it if any.
+// HTTP(S) proxies are traversed with a CONNECT request, SOCKS5 ones with a SOCKS5 handshake.
+func dialThroughProxy(ctx context.Context, proxyFunc func(*http.Request) (*url.URL, error), addr string) (net.Conn, error) {
+	var dialer net.Dialer
+
+	var proxyURL *url.URL
+	if proxyFunc != nil {
+		var err error
+		proxyURL, err = proxyFunc(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+		if err != nil {
+			return nil, fmt.Errorf("select proxy: %w", err)
+		}
+	}
+
+	if proxyURL == nil {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5":
+		socksDialer, err := netproxy.FromURL(proxyURL, &dialer)
+		if err != nil {
+			return nil, fmt.Errorf("create SOCKS5 dialer: %w", err)
+		}
+
+		contextDialer, ok := socksDialer.(netproxy.ContextDialer)
+		if !ok {
+			return nil, errors.New("SOCKS5 dialer does not support contexts")
+		}
+
+		return contextDialer.DialContext(ctx, "tcp", addr)
+
+	case "http", "https":
+		return dialHTTPProxy(ctx, &dialer, proxyURL, addr)
+
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+}
+
+func dialHTTPProxy(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("dial proxy: %w", err)
+	}
+
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("proxy TLS handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+		defer func() { _ = conn.SetDeadline(time.Time{}) }()
+	}
+
+	if err = req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("write CONNECT request: %w", err)
+	}
+
+	// The broker doesn't send anything before the TLS handshake starts, so nothing past the response gets buffered.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("read CONNECT response: %w", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy refused to connect: %s", resp.Status)
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,409 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/yamux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebSocketTransport_Dial(t *testing.T) {
+	tests := []struct {
+		desc        string
+		compression bool
+	}{
+		{desc: "without compression"},
+		{desc: "with compression", compression: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			negotiated := make(chan bool, 1)
+			broker := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+				assert.Equal(t, "/tunnel", req.URL.Path)
+
+				negotiated <- strings.Contains(req.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+
+				upgrader := websocket.Upgrader{EnableCompression: true, WriteBufferPool: websocketWriteBufferPool}
+				conn, err := upgrader.Upgrade(rw, req, nil)
+				require.NoError(t, err)
+
+				serveBroker(t, &websocketNetConn{Conn: conn})
+			}))
+			defer broker.Close()
+
+			transport, err := newTransport(Endpoint{}, Config{Compression: test.compression})
+			require.NoError(t, err)
+
+			endpoint := Endpoint{TunnelID: "tunnel", BrokerEndpoint: "ws://" + strings.TrimPrefix(broker.URL, "http://")}
+			conn, err := transport.Dial(context.Background(), endpoint, "token")
+			require.NoError(t, err)
+
+			assert.Equal(t, test.compression, <-negotiated)
+
+			assertSession(t, conn)
+		})
+	}
+}
+
+func TestHTTP2Transport_Dial(t *testing.T) {
+	tests := []struct {
+		desc              string
+		compression       bool
+		brokerCompression bool
+	}{
+		{desc: "without compression"},
+		{desc: "with compression", compression: true, brokerCompression: true},
+		{desc: "compression not supported by the broker", compression: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			broker := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+				assert.Equal(t, http.MethodConnect, req.Method)
+				assert.Equal(t, 2, req.ProtoMajor)
+				assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+				assert.Equal(t, "tunnel", req.Header.Get(headerTunnelID))
+
+				compress := test.brokerCompression && req.Header.Get(headerCompression) == compressionDeflate
+				if compress {
+					rw.Header().Set(headerCompression, compressionDeflate)
+				}
+
+				rw.WriteHeader(http.StatusOK)
+				rw.(http.Flusher).Flush()
+
+				var conn io.ReadWriteCloser = &flushingConn{ReadCloser: req.Body, rw: rw}
+				if compress {
+					conn = newCompressedConn(conn)
+				}
+
+				serveBroker(t, conn)
+			}))
+			broker.EnableHTTP2 = true
+			broker.StartTLS()
+			defer broker.Close()
+
+			cfg := Config{
+				TLSConfig:   broker.Client().Transport.(*http.Transport).TLSClientConfig,
+				Compression: test.compression,
+			}
+
+			transport, err := newTransport(Endpoint{Transport: TransportHTTP2}, cfg)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			conn, err := transport.Dial(ctx, Endpoint{TunnelID: "tunnel", BrokerEndpoint: broker.URL}, "token")
+			require.NoError(t, err)
+
+			_, compressed := conn.(*compressedConn)
+			assert.Equal(t, test.brokerCompression, compressed)
+
+			assertSession(t, conn)
+		})
+	}
+}
+
+func TestHTTP2Transport_Dial_rejected(t *testing.T) {
+	broker := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusUnauthorized)
+	}))
+	broker.EnableHTTP2 = true
+	broker.StartTLS()
+	defer broker.Close()
+
+	cfg := Config{TLSConfig: broker.Client().Transport.(*http.Transport).TLSClientConfig}
+
+	_, err := newHTTP2Transport(cfg).Dial(context.Background(), Endpoint{TunnelID: "tunnel", BrokerEndpoint: broker.URL}, "token")
+	assert.Error(t, err)
+}
+
+func TestHTTP2Transport_Dial_connectionPerSession(t *testing.T) {
+	var newConns, closedConns int32
+	broker := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		rw.(http.Flusher).Flush()
+
+		<-req.Context().Done()
+	}))
+	broker.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt32(&newConns, 1)
+		case http.StateClosed, http.StateHijacked:
+			atomic.AddInt32(&closedConns, 1)
+		}
+	}
+	broker.EnableHTTP2 = true
+	broker.StartTLS()
+	defer broker.Close()
+
+	cfg := Config{TLSConfig: broker.Client().Transport.(*http.Transport).TLSClientConfig}
+	transport := newHTTP2Transport(cfg)
+
+	// Sessions of a tunnel share its transport.
+	var conns []io.ReadWriteCloser
+	for i := 0; i < 3; i++ {
+		conn, err := transport.Dial(context.Background(), Endpoint{TunnelID: "tunnel", BrokerEndpoint: broker.URL}, "token")
+		require.NoError(t, err)
+
+		conns = append(conns, conn)
+	}
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&newConns))
+
+	for _, conn := range conns {
+		require.NoError(t, conn.Close())
+	}
+
+	// Closing the sessions closes their connections.
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&closedConns) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTLSTransport_Dial(t *testing.T) {
+	tests := []struct {
+		desc        string
+		compression bool
+	}{
+		{desc: "without compression"},
+		{desc: "with compression", compression: true},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.desc, func(t *testing.T) {
+			addr, rootCAs := launchTLSBroker(t, "")
+
+			cfg := Config{
+				TLSConfig:   &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12},
+				Compression: test.compression,
+			}
+
+			transport, err := newTransport(Endpoint{Transport: TransportTLS}, cfg)
+			require.NoError(t, err)
+
+			conn, err := transport.Dial(context.Background(), Endpoint{TunnelID: "tunnel", BrokerEndpoint: "tls://" + addr}, "token")
+			require.NoError(t, err)
+
+			_, compressed := conn.(*compressedConn)
+			assert.Equal(t, test.compression, compressed)
+
+			assertSession(t, conn)
+		})
+	}
+}
+
+func TestTLSTransport_Dial_rejected(t *testing.T) {
+	addr, rootCAs := launchTLSBroker(t, "unknown tunnel")
+
+	cfg := Config{TLSConfig: &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}}
+
+	_, err := newTLSTransport(cfg).Dial(context.Background(), Endpoint{TunnelID: "tunnel", BrokerEndpoint: "tls://" + addr}, "token")
+	assert.EqualError(t, err, "unknown tunnel")
+}
+
+func TestTLSTransport_Dial_throughHTTPProxy(t *testing.T) {
+	brokerAddr, rootCAs := launchTLSBroker(t, "")
+
+	proxyListener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"))
+	require.NoError(t, err)
+	defer func() { _ = proxyListener.Close() }()
+
+	go func() {
+		conn, aErr := proxyListener.Accept()
+		if aErr != nil {
+			return
+		}
+
+		reader := bufio.NewReader(conn)
+		req, rErr := http.ReadRequest(reader)
+		require.NoError(t, rErr)
+
+		assert.Equal(t, http.MethodConnect, req.Method)
+		assert.Equal(t, brokerAddr, req.Host)
+		assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("user:secret")), req.Header.Get("Proxy-Authorization"))
+
+		target, dErr := net.Dial("tcp", brokerAddr)
+		require.NoError(t, dErr)
+
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+		go func() { _, _ = io.Copy(target, reader) }()
+		_, _ = io.Copy(conn, target)
+	}()
+
+	proxyURL, err := url.Parse("http://user:secret@" + proxyListener.Addr().String())
+	require.NoError(t, err)
+
+	cfg := Config{
+		Proxy:     http.ProxyURL(proxyURL),
+		TLSConfig: &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12},
+	}
+
+	conn, err := newTLSTransport(cfg).Dial(context.Background(), Endpoint{TunnelID: "tunnel", BrokerEndpoint: "tls://" + brokerAddr}, "token")
+	require.NoError(t, err)
+
+	assertSession(t, conn)
+}
+
+func Test_newTransport_unsupported(t *testing.T) {
+	_, err := newTransport(Endpoint{Transport: "carrier-pigeon"}, Config{})
+	assert.Error(t, err)
+}
+
+// launchTLSBroker launches a broker accepting a single connection on the TLS transport. The broker rejects the
+// handshake with the given error if not empty.
+func launchTLSBroker(t *testing.T, rejection string) (string, *x509.CertPool) {
+	t.Helper()
+
+	// Borrow the certificate of an httptest server, valid for 127.0.0.1.
+	certSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(certSrv.Close)
+
+	listener, err := tls.Listen("tcp", net.JoinHostPort("127.0.0.1", "0"), &tls.Config{
+		Certificates: certSrv.TLS.Certificates,
+		NextProtos:   []string{alpnTunnelDeflate, alpnTunnel},
+		MinVersion:   tls.VersionTLS12,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		conn, aErr := listener.Accept()
+		if aErr != nil {
+			return
+		}
+
+		tlsConn := conn.(*tls.Conn)
+		require.NoError(t, tlsConn.Handshake())
+
+		reader := bufio.NewReader(tlsConn)
+
+		var handshake tlsHandshake
+		require.NoError(t, json.NewDecoder(reader).Decode(&handshake))
+		assert.Equal(t, tlsHandshake{TunnelID: "tunnel", Token: "token"}, handshake)
+
+		require.NoError(t, json.NewEncoder(tlsConn).Encode(tlsHandshakeResp{Error: rejection}))
+		if rejection != "" {
+			_ = tlsConn.Close()
+			return
+		}
+
+		var rwc io.ReadWriteCloser = tlsConn
+		if tlsConn.ConnectionState().NegotiatedProtocol == alpnTunnelDeflate {
+			rwc = newCompressedConn(tlsConn)
+		}
+
+		serveBroker(t, rwc)
+	}()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certSrv.Certificate())
+
+	return listener.Addr().String(), pool
+}
+
+// serveBroker opens a stream on the given connection, sends "hello" and expects "world" back.
+func serveBroker(t *testing.T, conn io.ReadWriteCloser) {
+	t.Helper()
+
+	cfg := yamux.DefaultConfig()
+	cfg.LogOutput = io.Discard
+	server, err := yamux.Server(conn, cfg)
+	require.NoError(t, err)
+	defer func() { _ = server.Close() }()
+
+	stream, err := server.Open()
+	require.NoError(t, err)
+
+	_, err = stream.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	b := make([]byte, 5)
+	_, err = io.ReadFull(stream, b)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(b))
+
+	_ = stream.Close()
+}
+
+// assertSession asserts a session can be multiplexed over the given connection to a broker served by serveBroker.
+func assertSession(t *testing.T, conn io.ReadWriteCloser) {
+	t.Helper()
+
+	cfg := yamux.DefaultConfig()
+	cfg.LogOutput = io.Discard
+	client, err := yamux.Client(conn, cfg)
+	require.NoError(t, err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.Accept()
+	require.NoError(t, err)
+
+	require.NoError(t, stream.SetDeadline(time.Now().Add(5*time.Second)))
+
+	b := make([]byte, 5)
+	_, err = io.ReadFull(stream, b)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	_, err = stream.Write([]byte("world"))
+	require.NoError(t, err)
+
+	_, err = io.ReadAll(stream)
+	require.NoError(t, err)
+}
+
+// flushingConn serves a CONNECT request, reading from its body and flushing every write to the response.
+type flushingConn struct {
+	io.ReadCloser
+
+	rw http.ResponseWriter
+}
+
+func (c *flushingConn) Write(p []byte) (int, error) {
+	n, err := c.rw.Write(p)
+	c.rw.(http.Flusher).Flush()
+
+	return n, err
+}
@@ -0,0 +1,456 @@
+/*
+Copyright (C) 2022 Traefik Labs
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published
+by the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/hashicorp/yamux"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// tunnel is a tunnel to a broker. It is made of a pool of sessions, each of them reconnecting with an
+// exponential backoff whenever its connection drops.
+type tunnel struct {
+	TunnelID       string
+	BrokerEndpoint string
+	Transport      string
+
+	transport Transport
+	token     string
+	traefik   traefikDialer
+	cfg       Config
+	stats     *trafficStats
+
+	sessions []*session
+
+	// connected is closed once a session of the tunnel has been connected for the first time.
+	connected     chan struct{}
+	connectedOnce sync.Once
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newTunnel(endpoint Endpoint, token, traefikAddr string, cfg Config, stats *trafficStats) (*tunnel, error) {
+	transport, err := newTransport(endpoint, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &tunnel{
+		TunnelID:       endpoint.TunnelID,
+		BrokerEndpoint: endpoint.BrokerEndpoint,
+		Transport:      endpoint.Transport,
+		transport:      transport,
+		token:          token,
+		traefik:        newTraefikDialer(traefikAddr, cfg),
+		cfg:            cfg,
+		stats:          stats,
+		connected:      make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+
+	sessions := cfg.Sessions
+	if sessions < 1 {
+		sessions = 1
+	}
+
+	for i := 0; i < sessions; i++ {
+		t.sessions = append(t.sessions, &session{
+			tunnel: t,
+			index:  i,
+			state:  StateConnecting,
+		})
+	}
+
+	return t, nil
+}
+
+// Status returns the status of the tunnel. The tunnel is connected as long as one of its sessions is connected.
+func (t *tunnel) Status() Status {
+	status := Status{
+		TunnelID:       t.TunnelID,
+		BrokerEndpoint: t.BrokerEndpoint,
+		State:          StateBackoff,
+		Attempt:        -1,
+		Traffic:        t.stats.Stats(),
+	}
+
+	for _, sess := range t.sessions {
+		sessStatus := sess.Status()
+		status.Sessions = append(status.Sessions, sessStatus)
+
+		switch {
+		case sessStatus.State == StateConnected:
+			status.State = StateConnected
+		case sessStatus.State == StateConnecting && status.State == StateBackoff:
+			status.State = StateConnecting
+		}
+
+		if status.Attempt < 0 || sessStatus.Attempt < status.Attempt {
+			status.Attempt = sessStatus.Attempt
+		}
+	}
+
+	return status
+}
+
+func (t *tunnel) start(ctx context.Context) {
+	ctx, t.cancel = context.WithCancel(ctx)
+
+	var wg sync.WaitGroup
+	for _, sess := range t.sessions {
+		wg.Add(1)
+
+		go func(sess *session) {
+			defer wg.Done()
+			sess.run(ctx)
+		}(sess)
+	}
+
+	go func() {
+		wg.Wait()
+		close(t.done)
+	}()
+}
+
+// Close stops the tunnel from reconnecting, closes its sessions and waits for them to stop.
+func (t *tunnel) Close() error {
+	t.cancel()
+
+	var closeErr error
+	for _, sess := range t.sessions {
+		if err := sess.Close(); err != nil {
+			closeErr = err
+		}
+	}
+
+	<-t.done
+
+	t.traefik.Close()
+
+	return closeErr
+}
+
+// Drain stops the tunnel from reconnecting and from accepting new streams, waits for its active streams
+// to be done for at most the given timeout, and closes it.
+func (t *tunnel) Drain(timeout time.Duration) error {
+	t.cancel()
+
+	var wg sync.WaitGroup
+	for _, sess := range t.sessions {
+		wg.Add(1)
+
+		go func(sess *session) {
+			defer wg.Done()
+			sess.drain(timeout)
+		}(sess)
+	}
+	wg.Wait()
+
+	return t.Close()
+}
+
+// session is a member of the session pool of a tunnel.
+type session struct {
+	tunnel *tunnel
+	index  int
+
+	stateMu sync.RWMutex
+	state   State
+	attempt int
+	mux     *yamux.Session
+	client  *closeAwareListener
+}
+
+// Status returns the status of the session.
+func (s *session) Status() SessionStatus {
+	s.stateMu.RLock()
+	defer s.stateMu.RUnlock()
+
+	return SessionStatus{
+		State:   s.state,
+		Attempt: s.attempt,
+	}
+}
+
+// Close closes the connection of the session.
+func (s *session) Close() error {
+	s.stateMu.Lock()
+	client := s.client
+	s.client = nil
+	s.stateMu.Unlock()
+
+	if client != nil {
+		return client.Close()
+	}
+
+	return nil
+}
+
+func (s *session) drain(timeout time.Duration) {
+	s.stateMu.RLock()
+	sess := s.mux
+	s.stateMu.RUnlock()
+
+	if sess == nil {
+		return
+	}
+
+	logger := s.logger()
+
+	if err := sess.GoAway(); err != nil {
+		logger.Debug().Err(err).Msg("Unable to send go away to the broker")
+	}
+
+	if err := waitForStreams(sess, timeout); err != nil {
+		logger.Warn().Err(err).
+			Int("active_streams", sess.NumStreams()).
+			Msg("Closing tunnel session with active streams")
+	}
+}
+
+// waitForStreams waits for all the streams of the given session to be closed, or the timeout to be elapsed.
+func waitForStreams(session *yamux.Session, timeout time.Duration) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	deadline := time.After(timeout)
+
+	for session.NumStreams() > 0 {
+		select {
+		case <-session.CloseChan():
+			return nil
+		case <-deadline:
+			return errors.New("drain timeout")
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+func (s *session) logger() zerolog.Logger {
+	return log.With().
+		Str("tunnel_id", s.tunnel.TunnelID).
+		Str("broker_endpoint", s.tunnel.BrokerEndpoint).
+		Int("session", s.index).
+		Logger()
+}
+
+func (s *session) run(ctx context.Context) {
+	logger := s.logger()
+
+	exp := backoff.NewExponentialBackOff()
+	exp.InitialInterval = time.Second
+	exp.MaxInterval = time.Minute
+	exp.MaxElapsedTime = 0
+
+	for {
+		err := s.connectAndServe(ctx, exp)
+		if ctx.Err() != nil {
+			return
+		}
+
+		retryIn := exp.NextBackOff()
+
+		s.stateMu.Lock()
+		s.state = StateBackoff
+		s.attempt++
+		attempt := s.attempt
+		s.stateMu.Unlock()
+
+		logger.Error().Err(err).Int("attempt", attempt).Dur("retry_in", retryIn).Msg("Tunnel session disconnected")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryIn):
+		}
+	}
+}
+
+// connectAndServe connects to the broker and proxies connections to Traefik until the connection is closed.
+// It returns the error which made it stop.
+func (s *session) connectAndServe(ctx context.Context, exp backoff.BackOff) error {
+	s.setState(StateConnecting)
+
+	sess, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+
+	client := &closeAwareListener{Listener: sess}
+	if !s.setClient(ctx, sess, client) {
+		return ctx.Err()
+	}
+	defer s.setClient(ctx, nil, nil)
+
+	exp.Reset()
+
+	logger := s.logger()
+	logger.Info().Msg("Tunnel session connected")
+
+	healthCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go s.checkHealth(healthCtx, sess, client)
+
+	if err = s.serve(client); err != nil {
+		return err
+	}
+
+	return errors.New("session closed")
+}
+
+// checkHealth pings the broker at the configured interval and closes the session when it's not responding,
+// so it gets replaced by a new one.
+func (s *session) checkHealth(ctx context.Context, sess *yamux.Session, client *closeAwareListener) {
+	if s.tunnel.cfg.HealthCheckInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.tunnel.cfg.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sess.CloseChan():
+			return
+		case <-ticker.C:
+			if err := ping(sess, s.tunnel.cfg.HealthCheckInterval); err != nil {
+				logger := s.logger()
+				logger.Error().Err(err).Msg("Tunnel session unhealthy, replacing it")
+				_ = client.Close()
+
+				return
+			}
+		}
+	}
+}
+
+func ping(sess *yamux.Session, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := sess.Ping()
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(timeout):
+		return errors.New("ping timeout")
+	}
+}
+
+func (s *session) setState(state State) {
+	s.stateMu.Lock()
+	s.state = state
+	s.stateMu.Unlock()
+}
+
+// setClient sets the current connection of the session and marks it as connected, or disconnected if client is nil.
+// It closes the given client and returns false if the tunnel has been closed in between.
+func (s *session) setClient(ctx context.Context, sess *yamux.Session, client *closeAwareListener) bool {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if client != nil && ctx.Err() != nil {
+		_ = client.Close()
+		return false
+	}
+
+	s.mux = sess
+	s.client = client
+	if client != nil {
+		s.state = StateConnected
+		s.attempt = 0
+		s.tunnel.connectedOnce.Do(func() { close(s.tunnel.connected) })
+	}
+
+	return true
+}
+
+func (s *session) connect(ctx context.Context) (*yamux.Session, error) {
+	endpoint := Endpoint{
+		TunnelID:       s.tunnel.TunnelID,
+		BrokerEndpoint: s.tunnel.BrokerEndpoint,
+		Transport:      s.tunnel.Transport,
+	}
+
+	conn, err := s.tunnel.transport.Dial(ctx, endpoint, s.tunnel.token)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &yamux.Config{
+		AcceptBacklog:          s.tunnel.cfg.AcceptBacklog,
+		EnableKeepAlive:        true,
+		KeepAliveInterval:      30 * time.Second,
+		ConnectionWriteTimeout: 10 * time.Second,
+		MaxStreamWindowSize:    s.tunnel.cfg.MaxStreamWindowSize,
+		StreamOpenTimeout:      75 * time.Second,
+		StreamCloseTimeout:     5 * time.Minute,
+		LogOutput:              io.Discard,
+	}
+	client, err := yamux.Client(conn, cfg)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("new yamux client: %w", err)
+	}
+
+	return client, nil
+}
+
+// serve accepts connections from the broker and proxies them to Traefik. It returns nil once the listener is closed.
+func (s *session) serve(client *closeAwareListener) error {
+	for {
+		brokerConn, acceptErr := client.Accept()
+		if acceptErr != nil {
+			if errors.Is(acceptErr, errListenerClosed) {
+				return nil
+			}
+
+			return fmt.Errorf("accept: %w", acceptErr)
+		}
+
+		go func(brokerConn net.Conn) {
+			stats := s.tunnel.stats
+
+			stats.streamOpened()
+			start := time.Now()
+			defer func() { stats.streamClosed(time.Since(start)) }()
+
+			if err := proxy(brokerConn, s.tunnel.traefik, stats); err != nil {
+				log.Error().Err(err).Msg("Unable to proxy to Traefik")
+			}
+		}(brokerConn)
+	}
+}
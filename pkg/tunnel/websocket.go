@@ -18,37 +18,93 @@ along with this program. If not, see <https://www.gnu.org/licenses/>.
 package tunnel
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// websocketWriteBufferPool pools the write buffers of websocket connections.
+var websocketWriteBufferPool = &sync.Pool{}
+
+// websocketTransport reaches brokers over a websocket. Compression is negotiated with the permessage-deflate extension.
+type websocketTransport struct {
+	dialer *websocket.Dialer
+}
+
+func newWebSocketTransport(cfg Config) *websocketTransport {
+	return &websocketTransport{
+		dialer: &websocket.Dialer{
+			Proxy:             cfg.Proxy,
+			TLSClientConfig:   cfg.TLSConfig,
+			HandshakeTimeout:  30 * time.Second,
+			WriteBufferPool:   websocketWriteBufferPool,
+			EnableCompression: cfg.Compression,
+		},
+	}
+}
+
+// Dial opens a websocket to the broker of the given endpoint.
+func (t *websocketTransport) Dial(ctx context.Context, endpoint Endpoint, token string) (io.ReadWriteCloser, error) {
+	u, err := url.Parse(endpoint.BrokerEndpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse broker endpoint: %w", err)
+	}
+	u.Path = path.Join(u.Path, endpoint.TunnelID)
+
+	connSocket, resp, err := t.dialer.DialContext(ctx, u.String(), http.Header{"Authorization": []string{"Bearer " + token}})
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		_ = connSocket.Close()
+		return nil, fmt.Errorf("expected protocol switching, got: %d", resp.StatusCode)
+	}
+
+	return &websocketNetConn{Conn: connSocket}, nil
+}
+
 // websocketNetConn wraps a websocket.Conn and exposes it as a net.Conn.
 type websocketNetConn struct {
 	*websocket.Conn
 
-	buff []byte
+	reader io.Reader
 }
 
-// Read reads data from the connection. This method is not thread-safe, multiple read shouldn't
-// be attempted simultaneously.
+// Read reads data from the connection, directly from the current message. This method is not thread-safe,
+// multiple read shouldn't be attempted simultaneously.
 func (c *websocketNetConn) Read(dst []byte) (int, error) {
-	// Read on the connection if there's nothing left in the buffer.
-	if len(c.buff) == 0 {
-		_, msg, err := c.Conn.ReadMessage()
-		if err != nil {
-			return 0, err
+	for {
+		if c.reader == nil {
+			_, reader, err := c.Conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+
+			c.reader = reader
 		}
 
-		c.buff = msg
-	}
+		n, err := c.reader.Read(dst)
+		if errors.Is(err, io.EOF) {
+			// Move on to the next message, unless something has been read from this one.
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
 
-	// Copy as much as possible from the buffer to dst and keep the rest in the buffer.
-	n := copy(dst, c.buff)
-	c.buff = c.buff[n:]
+			return n, nil
+		}
 
-	return n, nil
+		return n, err
+	}
 }
 
 // Write writes data to the connection.